@@ -1,12 +1,27 @@
 package HotStuff
 
 import (
+	"bytes"
 	"crypto/md5"
 	"github.com/salemmohammed/PaxiBFT"
 	"github.com/salemmohammed/PaxiBFT/log"
+	"github.com/salemmohammed/PaxiBFT/payload"
+	"github.com/salemmohammed/PaxiBFT/tree"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
+
+const walVote = "vote/"
+const walCommit = "commit/"
+
+// vote is the digest this replica voted for in a slot, persisted before the vote is sent
+type vote struct {
+	Ballot PaxiBFT.Ballot
+	Digest []byte
+}
+
 type status int8
 const (
 	NONE status = iota
@@ -31,6 +46,7 @@ type entry struct {
 	Cstatus    status
 	Rstatus	   status
 	Digest     []byte
+	command    PaxiBFT.Command
 }
 type HotStuff struct {
 	PaxiBFT.Node
@@ -46,6 +62,11 @@ type HotStuff struct {
 	count 		   				int
 	leader						bool
 	mux 						sync.Mutex
+	tree						*tree.Tree					// dissemination of proposals and votes
+	payload						*payload.Store				// payloads of digests ordered in hash first mode
+	hashFirst					bool						// order digests only, execute once payload is present
+	replies						map[int]PaxiBFT.Reply		// replies of executed slots whose request has not arrived yet
+	votes						map[int]vote				// votes persisted in write-ahead log
 }
 func NewHotStuff(n PaxiBFT.Node, options ...func(*HotStuff)) *HotStuff {
 	p := &HotStuff{
@@ -55,12 +76,69 @@ func NewHotStuff(n PaxiBFT.Node, options ...func(*HotStuff)) *HotStuff {
 		quorum:        	 	PaxiBFT.NewQuorum(),
 		Requests:      	 	make([]*PaxiBFT.Request, 0),
 		count:				0,
+		tree:				tree.NewTree(n),
+		payload:			payload.NewStore(n),
+		hashFirst:			PaxiBFT.GetConfig().HashFirst,
+		replies:			make(map[int]PaxiBFT.Reply),
+		votes:				make(map[int]vote),
 	}
 	for _, opt := range options {
 		opt(p)
 	}
+	if n.WAL().Recovering() {
+		p.recover()
+	}
 	return p
 }
+
+// recover restores votes and ballot from write-ahead log,
+// and executes the committed prefix again to rebuild the state machine
+func (p *HotStuff) recover() {
+	w := p.WAL()
+	for _, k := range w.Keys(walVote) {
+		s, err := strconv.Atoi(strings.TrimPrefix(k, walVote))
+		if err != nil {
+			continue
+		}
+		var v vote
+		if !w.Get(k, &v) {
+			continue
+		}
+		p.votes[s] = v
+		if v.Ballot > p.ballot {
+			p.ballot = v.Ballot
+		}
+	}
+	var cmd PaxiBFT.Command
+	for w.Get(walCommit+strconv.Itoa(p.execute), &cmd) {
+		p.Execute(cmd)
+		p.execute++
+		cmd = PaxiBFT.Command{}
+	}
+	// replies of replayed slots come from the client session table
+	p.slot = p.execute - 1
+	log.Infof("Replica %s recovered %d votes and executed up to %d", p.ID(), len(p.votes), p.execute)
+}
+
+// vote persists vote for digest of slot s in ballot before it is sent,
+// returns false if this replica already voted for another digest of slot s in the same ballot
+func (p *HotStuff) vote(s int, ballot PaxiBFT.Ballot, digest []byte) bool {
+	if p.WAL() == nil {
+		return true
+	}
+	v, exists := p.votes[s]
+	if exists && v.Ballot == ballot {
+		if !bytes.Equal(v.Digest, digest) {
+			log.Warningf("Replica %s refuses to vote for slot %d again in ballot %v", p.ID(), s, ballot)
+			return false
+		}
+		return true
+	}
+	v = vote{Ballot: ballot, Digest: digest}
+	p.WAL().Put(walVote+strconv.Itoa(s), v)
+	p.votes[s] = v
+	return true
+}
 func GetMD5Hash(r *PaxiBFT.Request) []byte {
 	hasher := md5.New()
 	hasher.Write([]byte(r.Command.Value))
@@ -68,7 +146,18 @@ func GetMD5Hash(r *PaxiBFT.Request) []byte {
 }
 func (p *HotStuff) HandleRequest(r PaxiBFT.Request) {
 	log.Debugf("<---R----HandleRequest----R------>")
-	p.Broadcast(Prepare{
+	if p.hashFirst {
+		d := payload.Digest(r.Command)
+		p.receive(p.slot, d, p.ID())
+		p.tree.Multicast(Prepare{
+			Ballot:     p.ballot,
+			ID:         p.ID(),
+			Slot:       p.slot,
+			Digest:		d,
+		})
+		return
+	}
+	p.tree.Multicast(Prepare{
 		Ballot:     p.ballot,
 		ID:         p.ID(),
 		Slot:       p.slot,
@@ -104,8 +193,19 @@ func (p *HotStuff) handlePrepare(m Prepare) {
 	}
 
 	e = p.log[m.Slot]
+	if p.hashFirst {
+		if !ok {
+			// proposal carries no request, reply goes to the local one once it arrives
+			e.request = nil
+		}
+		e.Digest = m.Digest
+		p.receive(m.Slot, m.Digest, m.ID)
+	}
+	if !p.vote(m.Slot, m.Ballot, e.Digest) {
+		return
+	}
 	e.Pstatus = PREPARED
-	p.Send(m.ID, ActPrepare{
+	p.tree.Vote(m.ID, ActPrepare{
 		Ballot:     m.Ballot,
 		ID:         p.ID(),
 		Slot:       m.Slot,
@@ -130,7 +230,7 @@ func (p *HotStuff) handleActPrepare(m ActPrepare){
 	e.Q1.ACK(m.ID)
 	if e.Q1.Majority(){
 		e.Q1.Reset()
-		p.Broadcast(PreCommit{
+		p.tree.Multicast(PreCommit{
 		Ballot:     p.ballot,
 		ID:         p.ID(),
 		Slot:       m.Slot,
@@ -147,8 +247,11 @@ func (p *HotStuff) handlePreCommit(m PreCommit) {
 		log.Debugf("m.ballot is bigger")
 		p.ballot = m.Ballot
 	}
+	if !p.vote(m.Slot, m.Ballot, m.Digest) {
+		return
+	}
 
-	p.Send(m.ID, ActPreCommit{
+	p.tree.Vote(m.ID, ActPreCommit{
 		Ballot:     p.ballot,
 		ID:         p.ID(),
 		Slot:       m.Slot,
@@ -172,7 +275,7 @@ func (p *HotStuff) handleActPreCommit(m ActPreCommit) {
 	e.Q2.ACK(m.ID)
 	if e.Q2.Majority(){
 		e.Q2.Reset()
-		p.Broadcast(Commit{
+		p.tree.Multicast(Commit{
 			Ballot:     p.ballot,
 			ID:         p.ID(),
 			Slot:       m.Slot,
@@ -188,7 +291,10 @@ func (p *HotStuff) handleCommit(m Commit) {
 		log.Debugf("m.ballot is bigger")
 		p.ballot = m.Ballot
 	}
-	p.Send(m.ID, ActCommit{
+	if !p.vote(m.Slot, m.Ballot, m.Digest) {
+		return
+	}
+	p.tree.Vote(m.ID, ActCommit{
 		Ballot:  p.ballot,
 		ID:      p.ID(),
 		Slot:    m.Slot,
@@ -211,7 +317,7 @@ func (p *HotStuff) handleActCommit(m ActCommit) {
 	e.Q3.ACK(m.ID)
 	if e.Q3.Majority() {
 		e.Q3.Reset()
-		p.Broadcast(Decide{
+		p.tree.Multicast(Decide{
 			Ballot: p.ballot,
 			ID:     p.ID(),
 			Slot:   m.Slot,
@@ -252,12 +358,31 @@ func (p *HotStuff) exec() {
 	for {
 		log.Debugf("p.execute %v", p.execute)
 		e, ok := p.log[p.execute]
-		if !ok || !e.commit {
+		if !ok || !e.commit || p.hashFirst && e.Rstatus != RECEIVED {
 			log.Debugf("Break")
 			break
 		}
 
-		value := p.Execute(e.request.Command)
+		var value PaxiBFT.Value
+		if p.hashFirst {
+			p.WAL().Put(walCommit+strconv.Itoa(p.execute), e.command)
+			value = p.Execute(e.command)
+			if e.request == nil && p.slot < p.execute && e.command.ClientID != "" && e.command.CommandID > 0 {
+				// client session answers the request once it arrives and keeps it from the replica,
+				// so the slot is counted here
+				p.slot = p.execute
+			} else if e.request == nil {
+				// request of slot has not arrived yet
+				p.replies[p.execute] = PaxiBFT.Reply{
+					Command:    e.command,
+					Value:      value,
+					Properties: make(map[string]string),
+				}
+			}
+		} else {
+			p.WAL().Put(walCommit+strconv.Itoa(p.execute), e.request.Command)
+			value = p.Execute(e.request.Command)
+		}
 
 		if e.request != nil && e.leader{
 			reply := PaxiBFT.Reply{
@@ -286,4 +411,19 @@ func (p *HotStuff) exec() {
 		delete(p.log, p.execute)
 		p.execute++
 	}
-}
\ No newline at end of file
+}
+// receive waits for payload of digest ordered at slot s, fetching it from proposer if missing,
+// the slot executes only once its payload is present
+func (p *HotStuff) receive(s int, digest []byte, from PaxiBFT.ID) {
+	p.payload.Wait(digest, from, func(cmd PaxiBFT.Command) {
+		e, ok := p.log[s]
+		if !ok || s < p.execute {
+			return
+		}
+		e.command = cmd
+		e.Rstatus = RECEIVED
+		if e.commit {
+			p.exec()
+		}
+	})
+}
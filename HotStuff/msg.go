@@ -23,10 +23,11 @@ type Prepare struct {
 	Ballot 		PaxiBFT.Ballot
 	ID     		PaxiBFT.ID
 	Request 	PaxiBFT.Request
+	Digest 		[]byte // digest of request in hash first mode, Request is left empty
 	Slot 		int
 }
 func (m Prepare) String() string {
-	return fmt.Sprintf("Prepare {Ballot %v,Request %v, Slot %v, ID %v}", m.Ballot, m.Request, m.Slot, m.ID)
+	return fmt.Sprintf("Prepare {Ballot %v,Request %v, Digest %x, Slot %v, ID %v}", m.Ballot, m.Request, m.Digest, m.Slot, m.ID)
 }
 type ActPrepare struct {
 	Ballot 	PaxiBFT.Ballot
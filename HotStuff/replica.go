@@ -25,16 +25,16 @@ func NewReplica(id PaxiBFT.ID) *Replica {
 	r.HotStuff = NewHotStuff(r)
 	r.Register(PaxiBFT.Request{},  r.handleRequest)
 
-	r.Register(Prepare{},          r.handlePrepare)
+	r.tree.Register(Prepare{},          r.handlePrepare)
 	r.Register(ActPrepare{},       r.handleActPrepare)
 
-	r.Register(PreCommit{},        r.handlePreCommit)
+	r.tree.Register(PreCommit{},        r.handlePreCommit)
 	r.Register(ActPreCommit{},     r.handleActPreCommit)
 
-	r.Register(Commit{},           r.handleCommit)
+	r.tree.Register(Commit{},           r.handleCommit)
 	r.Register(ActCommit{},        r.handleActCommit)
 
-	r.Register(Decide{},        r.handleDecide)
+	r.tree.Register(Decide{},        r.handleDecide)
 
 	return r
 }
@@ -44,6 +44,15 @@ func (p *Replica) handleRequest(m PaxiBFT.Request) {
 		fmt.Print("-------------------HotStuff-------------------------")
 	}
 	p.slot++
+	if p.hashFirst {
+		p.payload.Put(m.Command)
+		if reply, ok := p.replies[p.slot]; ok {
+			// slot executed with payload fetched before request arrived
+			delete(p.replies, p.slot)
+			m.Reply(reply)
+			return
+		}
+	}
 	p.Requests = append(p.Requests, &m)
 	e, ok := p.log[p.slot]
 	if !ok {
@@ -84,6 +93,10 @@ func (p *Replica) handleRequest(m PaxiBFT.Request) {
 		p.HandleRequest(m)
 	}
 
+	if p.hashFirst {
+		// payload of ordered digest marks the slot received
+		return
+	}
 	e.Rstatus = RECEIVED
 	log.Debugf("e.Pstatus = %v", e.Pstatus)
 	log.Debugf("e.Cstatus = %v", e.Cstatus)
@@ -0,0 +1,564 @@
+package HotStuff2
+
+import (
+	"bytes"
+	"flag"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/salemmohammed/PaxiBFT"
+	"github.com/salemmohammed/PaxiBFT/log"
+	"github.com/salemmohammed/PaxiBFT/payload"
+)
+
+var timeout = flag.Int("view_timeout", 1000, "view timeout of two-phase hotstuff in milliseconds, doubled every view change")
+
+const walSlot = "slot/"
+const walCommit = "commit/"
+
+// record is the voting state of an undecided slot, persisted before the messages that depend on it are sent
+type record struct {
+	View      int
+	Prepared  int
+	Committed int
+	Proposed  int
+	Digest    []byte
+	Locked    *QC
+	Proposals map[string]PaxiBFT.Request
+}
+
+// entry is the state of single-shot consensus instance of one slot
+type entry struct {
+	view      int                        // current view
+	request   *PaxiBFT.Request           // local request slot was opened for, view timer runs while set
+	proposals map[string]PaxiBFT.Request // proposed requests voted for, by digest
+	prepared  int                        // highest view voted in phase 1
+	committed int                        // highest view voted in phase 2
+	locked    *QC                        // highest prepare QC
+	command   PaxiBFT.Command            // decided command
+	commit    bool
+	timer     *time.Timer
+
+	// leader state
+	proposed    int             // highest view proposed in
+	digest      []byte          // digest proposed in view proposed
+	Q1          *PaxiBFT.Quorum // phase 1 votes of proposal
+	Q2          *PaxiBFT.Quorum // phase 2 votes of proposal
+	nv          int             // view new view messages are collected for
+	newview     *PaxiBFT.Quorum // new view messages of view nv
+	proof       map[PaxiBFT.ID]QC
+	high        *QC              // highest lock in new view messages
+	highRequest *PaxiBFT.Request // request of highest lock
+}
+
+// HotStuff2 is two-phase hotstuff: a prepare phase forms the QC replicas lock on and a commit
+// phase forms the QC that decides. Leaders rotate by slot and view; a replica that times out
+// sends its lock to the next leader, who proposes once 2f+1 locks are collected and extends
+// the highest of them, attaching all of them as proof so that no lock can be hidden.
+type HotStuff2 struct {
+	PaxiBFT.Node
+	log      map[int]*entry              // log ordered by slot
+	ids      []PaxiBFT.ID                // sorted replica ids for leader rotation
+	execute  int                         // next execute slot number
+	requests map[string]*PaxiBFT.Request // local requests waiting for reply, by digest
+	queue    []string                    // digests of waiting requests in arrival order
+	replies  map[string]PaxiBFT.Reply    // replies of executed commands whose request has not arrived yet
+}
+
+// NewHotStuff2 creates new two-phase hotstuff instance
+func NewHotStuff2(n PaxiBFT.Node, options ...func(*HotStuff2)) *HotStuff2 {
+	ids := PaxiBFT.GetConfig().IDs()
+	sort.Sort(PaxiBFT.IDs(ids))
+	p := &HotStuff2{
+		Node:     n,
+		log:      make(map[int]*entry, PaxiBFT.GetConfig().BufferSize),
+		ids:      ids,
+		requests: make(map[string]*PaxiBFT.Request),
+		replies:  make(map[string]PaxiBFT.Reply),
+	}
+	for _, opt := range options {
+		opt(p)
+	}
+	if n.WAL().Recovering() {
+		p.recover()
+	}
+	return p
+}
+
+// recover executes the decided prefix again to rebuild the state machine,
+// and restores views, votes, proposals and locks of the undecided slots after it from write-ahead log
+func (p *HotStuff2) recover() {
+	w := p.WAL()
+	var cmd PaxiBFT.Command
+	for w.Get(walCommit+strconv.Itoa(p.execute), &cmd) {
+		p.Execute(cmd)
+		p.execute++
+		cmd = PaxiBFT.Command{}
+	}
+	for _, k := range w.Keys(walSlot) {
+		s, err := strconv.Atoi(strings.TrimPrefix(k, walSlot))
+		if err != nil || s < p.execute {
+			continue
+		}
+		var r record
+		if !w.Get(k, &r) {
+			continue
+		}
+		e := p.get(s)
+		e.view = r.View
+		e.prepared = r.Prepared
+		e.committed = r.Committed
+		e.proposed = r.Proposed
+		e.digest = r.Digest
+		e.locked = r.Locked
+		for d, req := range r.Proposals {
+			e.proposals[d] = req
+		}
+	}
+	// replies of replayed slots come from the client session table
+	log.Infof("Replica %s recovered %d undecided slots and executed up to %d", p.ID(), len(p.log), p.execute)
+}
+
+// persist writes the voting state of slot s to write-ahead log
+func (p *HotStuff2) persist(s int) {
+	e := p.log[s]
+	p.WAL().Put(walSlot+strconv.Itoa(s), record{
+		View:      e.view,
+		Prepared:  e.prepared,
+		Committed: e.committed,
+		Proposed:  e.proposed,
+		Digest:    e.digest,
+		Locked:    e.locked,
+		Proposals: e.proposals,
+	})
+}
+
+// GetMD5Hash returns digest of the whole request command, so that locks and QCs
+// of one command are never satisfied by another command with the same value
+func GetMD5Hash(r *PaxiBFT.Request) []byte {
+	return payload.Digest(r.Command)
+}
+
+// leader returns leader of slot s in view v
+func (p *HotStuff2) leader(s, v int) PaxiBFT.ID {
+	return p.ids[(s+v)%len(p.ids)]
+}
+
+// get returns entry of slot s, creating it on first access
+func (p *HotStuff2) get(s int) *entry {
+	e, ok := p.log[s]
+	if !ok {
+		e = &entry{
+			proposals: make(map[string]PaxiBFT.Request),
+			prepared:  -1,
+			committed: -1,
+			proposed:  -1,
+		}
+		p.log[s] = e
+	}
+	return e
+}
+
+// valid returns true if qc certifies slot s in view v with 2f+1 votes
+func (p *HotStuff2) valid(qc *QC, s, v int) bool {
+	if qc != nil && qc.View < 0 {
+		return false
+	}
+	if qc == nil || qc.Slot != s || qc.View != v {
+		return false
+	}
+	q := PaxiBFT.NewQuorum()
+	for _, id := range qc.IDs {
+		q.ACK(id)
+	}
+	return q.ByzantineMajority()
+}
+
+// timer restarts view timer of slot s, doubling the timeout in every view
+func (p *HotStuff2) timer(s int) {
+	e := p.log[s]
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	if e.commit || e.request == nil {
+		return
+	}
+	v := e.view
+	shift := v
+	if shift > 6 {
+		shift = 6
+	}
+	d := time.Duration(*timeout) * time.Millisecond << uint(shift)
+	e.timer = time.AfterFunc(d, func() {
+		p.Send(p.ID(), Timeout{View: v, Slot: s})
+	})
+}
+
+// enter moves slot s into view v
+func (p *HotStuff2) enter(s, v int) {
+	e := p.log[s]
+	if v > e.view {
+		e.view = v
+		p.timer(s)
+	}
+}
+
+// HandleRequest queues request until its command is decided in any slot
+func (p *HotStuff2) HandleRequest(r PaxiBFT.Request) {
+	d := string(GetMD5Hash(&r))
+	if reply, ok := p.replies[d]; ok {
+		delete(p.replies, d)
+		reply.Timestamp = r.Timestamp
+		r.Reply(reply)
+		return
+	}
+	if _, ok := p.requests[d]; !ok {
+		p.queue = append(p.queue, d)
+	}
+	p.requests[d] = &r
+	p.open()
+}
+
+// open starts view timer of one slot past the last executed for every waiting request
+// and proposes in the slots it leads, so that no slot is assigned by local arrival order
+func (p *HotStuff2) open() {
+	for i, d := range p.queue {
+		s := p.execute + i
+		e := p.get(s)
+		if e.request == nil {
+			e.request = p.requests[d]
+			p.timer(s)
+		}
+		p.propose(s)
+	}
+}
+
+// pick returns the waiting request to propose in slot s, preferring the one at the same position
+// in arrival order and skipping requests already proposed in an undecided lower slot
+func (p *HotStuff2) pick(s int) *PaxiBFT.Request {
+	i := s - p.execute
+	for j := range p.queue {
+		d := p.queue[(i+j)%len(p.queue)]
+		proposed := false
+		for k := p.execute; k < s && !proposed; k++ {
+			if e, ok := p.log[k]; ok {
+				_, proposed = e.proposals[d]
+			}
+		}
+		if !proposed {
+			return p.requests[d]
+		}
+	}
+	return nil
+}
+
+// propose broadcasts proposal of slot s in view nv once leader of it has a value to propose
+func (p *HotStuff2) propose(s int) {
+	e := p.log[s]
+	v := e.nv
+	if e.commit || e.proposed >= v || v < e.view || p.leader(s, v) != p.ID() {
+		return
+	}
+	if v > 0 && !e.newview.ByzantineMajority() {
+		return
+	}
+	r := e.highRequest
+	if e.high == nil {
+		r = p.pick(s)
+	}
+	if r == nil {
+		return
+	}
+
+	e.proposed = v
+	e.digest = GetMD5Hash(r)
+	e.Q1 = PaxiBFT.NewQuorum()
+	e.Q2 = PaxiBFT.NewQuorum()
+	proof := make(map[PaxiBFT.ID]QC, len(e.proof))
+	for id, qc := range e.proof {
+		proof[id] = qc
+	}
+	m := Prepare{
+		View:    v,
+		ID:      p.ID(),
+		Slot:    s,
+		Request: *r,
+		Digest:  e.digest,
+		Justify: e.high,
+		Proof:   proof,
+	}
+	log.Debugf("Replica %s proposes %v", p.ID(), m)
+	p.persist(s)
+	p.Broadcast(m)
+	p.HandlePrepare(m)
+}
+
+// justified returns true if proposal extends the highest of 2f+1 reported locks,
+// every lock is a valid QC of the slot, a replica without lock reports view -1
+func (p *HotStuff2) justified(m Prepare) bool {
+	q := PaxiBFT.NewQuorum()
+	high := -1
+	if m.Justify != nil {
+		if !p.valid(m.Justify, m.Slot, m.Justify.View) {
+			return false
+		}
+		high = m.Justify.View
+	}
+	found := m.Justify == nil
+	for id, qc := range m.Proof {
+		if qc.View >= 0 && !p.valid(&qc, m.Slot, qc.View) || qc.View < 0 && qc.Slot != m.Slot {
+			return false
+		}
+		q.ACK(id)
+		if qc.View > high {
+			return false
+		}
+		if m.Justify != nil && qc.View == high && bytes.Equal(qc.Digest, m.Justify.Digest) {
+			found = true
+		}
+	}
+	return found && q.ByzantineMajority()
+}
+
+// HandlePrepare votes for a safe proposal: one that carries the locked value or is justified by a higher lock
+func (p *HotStuff2) HandlePrepare(m Prepare) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, p.ID())
+	if m.Slot < p.execute {
+		return
+	}
+	e := p.get(m.Slot)
+	if e.commit || m.View < e.view || m.View <= e.prepared || p.leader(m.Slot, m.View) != m.ID {
+		return
+	}
+	if m.View > 0 && !p.justified(m) {
+		log.Errorf("proposal %v is not justified by the highest lock", m)
+		return
+	}
+	if !bytes.Equal(GetMD5Hash(&m.Request), m.Digest) {
+		log.Errorf("proposal %v does not match its digest", m)
+		return
+	}
+	if e.locked != nil && !bytes.Equal(e.locked.Digest, m.Digest) && (m.Justify == nil || m.Justify.View < e.locked.View) {
+		return
+	}
+	p.enter(m.Slot, m.View)
+	e.prepared = m.View
+	e.proposals[string(m.Digest)] = m.Request
+	p.persist(m.Slot)
+	p.Send(m.ID, ActPrepare{
+		View:   m.View,
+		ID:     p.ID(),
+		Slot:   m.Slot,
+		Digest: m.Digest,
+	})
+}
+
+// HandleActPrepare forms the prepare QC with 2f+1 votes
+func (p *HotStuff2) HandleActPrepare(m ActPrepare) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, p.ID())
+	e, ok := p.log[m.Slot]
+	if !ok || e.proposed != m.View || !bytes.Equal(e.digest, m.Digest) || e.Q1.ByzantineMajority() {
+		return
+	}
+	e.Q1.ACK(m.ID)
+	e.Q1.AID_ID(m.ID)
+	if e.Q1.ByzantineMajority() {
+		c := Commit{
+			View: m.View,
+			ID:   p.ID(),
+			Slot: m.Slot,
+			QC: &QC{
+				View:   m.View,
+				Slot:   m.Slot,
+				Digest: e.digest,
+				IDs:    append([]PaxiBFT.ID(nil), e.Q1.AID...),
+			},
+		}
+		p.Broadcast(c)
+		p.HandleCommit(c)
+	}
+}
+
+// HandleCommit locks on the prepare QC and votes
+func (p *HotStuff2) HandleCommit(m Commit) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, p.ID())
+	if m.Slot < p.execute {
+		return
+	}
+	e := p.get(m.Slot)
+	if e.commit || m.View < e.view || m.View <= e.committed || p.leader(m.Slot, m.View) != m.ID {
+		return
+	}
+	if !p.valid(m.QC, m.Slot, m.View) {
+		log.Errorf("invalid prepare QC in %v", m)
+		return
+	}
+	if _, ok := e.proposals[string(m.QC.Digest)]; !ok {
+		return
+	}
+	p.enter(m.Slot, m.View)
+	e.committed = m.View
+	if e.locked == nil || m.QC.View > e.locked.View {
+		e.locked = m.QC
+	}
+	p.persist(m.Slot)
+	p.Send(m.ID, ActCommit{
+		View:   m.View,
+		ID:     p.ID(),
+		Slot:   m.Slot,
+		Digest: m.QC.Digest,
+	})
+}
+
+// HandleActCommit forms the commit QC with 2f+1 votes and decides
+func (p *HotStuff2) HandleActCommit(m ActCommit) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, p.ID())
+	e, ok := p.log[m.Slot]
+	if !ok || e.proposed != m.View || !bytes.Equal(e.digest, m.Digest) || e.Q2.ByzantineMajority() {
+		return
+	}
+	e.Q2.ACK(m.ID)
+	e.Q2.AID_ID(m.ID)
+	if e.Q2.ByzantineMajority() {
+		d := Decide{
+			View: m.View,
+			ID:   p.ID(),
+			Slot: m.Slot,
+			QC: &QC{
+				View:   m.View,
+				Slot:   m.Slot,
+				Digest: e.digest,
+				IDs:    append([]PaxiBFT.ID(nil), e.Q2.AID...),
+			},
+		}
+		p.Broadcast(d)
+		p.HandleDecide(d)
+	}
+}
+
+// HandleDecide commits the value certified by commit QC
+func (p *HotStuff2) HandleDecide(m Decide) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, p.ID())
+	if m.Slot < p.execute {
+		return
+	}
+	e := p.get(m.Slot)
+	if e.commit {
+		return
+	}
+	if !p.valid(m.QC, m.Slot, m.View) {
+		log.Errorf("invalid commit QC in %v", m)
+		return
+	}
+	r, ok := e.proposals[string(m.QC.Digest)]
+	if !ok {
+		log.Errorf("Replica %s decided slot %d without proposal %x", p.ID(), m.Slot, m.QC.Digest)
+		return
+	}
+	e.commit = true
+	e.command = r.Command
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	p.exec()
+}
+
+// HandleTimeout moves slot into next view and sends own lock to its leader
+func (p *HotStuff2) HandleTimeout(m Timeout) {
+	e, ok := p.log[m.Slot]
+	if !ok || e.commit || m.View != e.view {
+		return
+	}
+	log.Infof("Replica %s view %d of slot %d timed out", p.ID(), m.View, m.Slot)
+	e.view++
+	nv := NewView{
+		View:   e.view,
+		ID:     p.ID(),
+		Slot:   m.Slot,
+		Locked: e.locked,
+	}
+	if e.locked != nil {
+		nv.Request = e.proposals[string(e.locked.Digest)]
+	}
+	p.persist(m.Slot)
+	p.Send(p.leader(m.Slot, e.view), nv)
+	p.timer(m.Slot)
+}
+
+// HandleNewView collects locks of 2f+1 replicas for view of leader
+func (p *HotStuff2) HandleNewView(m NewView) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, p.ID())
+	if m.Slot < p.execute {
+		return
+	}
+	e := p.get(m.Slot)
+	if e.commit || m.View < e.nv || p.leader(m.Slot, m.View) != p.ID() {
+		return
+	}
+	if m.View > e.nv || e.newview == nil {
+		e.nv = m.View
+		e.newview = PaxiBFT.NewQuorum()
+		e.proof = make(map[PaxiBFT.ID]QC)
+		e.high = nil
+		e.highRequest = nil
+	}
+	if _, ok := e.proof[m.ID]; ok {
+		return
+	}
+	if m.Locked != nil && (!p.valid(m.Locked, m.Slot, m.Locked.View) || !bytes.Equal(GetMD5Hash(&m.Request), m.Locked.Digest)) {
+		log.Errorf("invalid lock in %v", m)
+		return
+	}
+	e.newview.ACK(m.ID)
+	e.proof[m.ID] = QC{View: -1, Slot: m.Slot}
+	if m.Locked != nil {
+		e.proof[m.ID] = *m.Locked
+		if e.high == nil || m.Locked.View > e.high.View {
+			r := m.Request
+			e.high = m.Locked
+			e.highRequest = &r
+		}
+	}
+	p.propose(m.Slot)
+}
+
+// exec executes decided slots in order and replies to the local request of each decided command
+func (p *HotStuff2) exec() {
+	for {
+		e, ok := p.log[p.execute]
+		if !ok || !e.commit {
+			break
+		}
+		p.WAL().Put(walCommit+strconv.Itoa(p.execute), e.command)
+		p.WAL().Delete(walSlot + strconv.Itoa(p.execute))
+		value := p.Execute(e.command)
+		d := string(payload.Digest(e.command))
+		reply := PaxiBFT.Reply{
+			Command:    e.command,
+			Value:      value,
+			Properties: make(map[string]string),
+		}
+		if r, ok := p.requests[d]; ok {
+			reply.Timestamp = r.Timestamp
+			r.Reply(reply)
+			delete(p.requests, d)
+			for i := range p.queue {
+				if p.queue[i] == d {
+					p.queue = append(p.queue[:i], p.queue[i+1:]...)
+					break
+				}
+			}
+		} else if e.command.ClientID == "" || e.command.CommandID <= 0 {
+			// request has not arrived yet, the client session answers it if tracked
+			p.replies[d] = reply
+		}
+		if e.timer != nil {
+			e.timer.Stop()
+		}
+		delete(p.log, p.execute)
+		p.execute++
+	}
+	p.open()
+}
@@ -0,0 +1,232 @@
+package HotStuff2
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/salemmohammed/PaxiBFT"
+)
+
+var errIncomplete = errors.New("history incomplete")
+
+// cluster starts n replicas in simulation with configuration c,
+// they are closed at the end of test before the next cluster changes configuration
+func cluster(t *testing.T, c PaxiBFT.Config, port, n int) map[PaxiBFT.ID]*Replica {
+	PaxiBFT.Simulation()
+	c.Addrs = make(map[PaxiBFT.ID]string)
+	c.HTTPAddrs = make(map[PaxiBFT.ID]string)
+	for i := 1; i <= n; i++ {
+		id := PaxiBFT.NewID(1, i)
+		p := strconv.Itoa(port + i)
+		c.Addrs[id] = "chan://127.0.0.1:" + p
+		c.HTTPAddrs[id] = "http://127.0.0.1:" + p
+	}
+	PaxiBFT.SetConfig(c)
+
+	replicas := make(map[PaxiBFT.ID]*Replica)
+	t.Cleanup(func() {
+		for _, r := range replicas {
+			r.Close()
+		}
+	})
+	for id := range c.Addrs {
+		replicas[id] = NewReplica(id)
+		go replicas[id].Run()
+		err := PaxiBFT.Retry(func() error {
+			r, err := http.Get(c.HTTPAddrs[id] + "/history?key=0")
+			if err == nil {
+				r.Body.Close()
+			}
+			return err
+		}, 50, 10*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return replicas
+}
+
+// put sends the same write to every given replica and waits for all replies
+func put(t *testing.T, client *PaxiBFT.HTTPClient, ids []PaxiBFT.ID, key PaxiBFT.Key, value PaxiBFT.Value) {
+	var wait sync.WaitGroup
+	for _, id := range ids {
+		wait.Add(1)
+		go func(id PaxiBFT.ID) {
+			defer wait.Done()
+			if _, _, err := client.RESTPut(id, key, value); err != nil {
+				t.Errorf("put %s to %v: %v", value, id, err)
+			}
+		}(id)
+	}
+	wait.Wait()
+}
+
+func TestHotStuff2(t *testing.T) {
+	*timeout = 200
+	c := PaxiBFT.MakeDefaultConfig()
+	c.MultiVersion = true
+	replicas := cluster(t, c, 20400, 4)
+	client := PaxiBFT.NewHTTPClient("")
+	client.Client.Timeout = 10 * time.Second
+
+	// leader of every slot in view 0 rotates over all replicas
+	all := []PaxiBFT.ID{"1.1", "1.2", "1.3", "1.4"}
+	for i := 0; i < 8; i++ {
+		put(t, client, all, "0", PaxiBFT.Value("v"+strconv.Itoa(i)))
+	}
+
+	// slots led by crashed replica are decided by next leader after view change
+	replicas["1.2"].Crash(10)
+	live := []PaxiBFT.ID{"1.1", "1.3", "1.4"}
+	for i := 8; i < 12; i++ {
+		put(t, client, live, "0", PaxiBFT.Value("v"+strconv.Itoa(i)))
+	}
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	var history []PaxiBFT.Value
+	err := PaxiBFT.Retry(func() error {
+		for _, id := range live {
+			if len(replicas[id].History("0")) < 12 {
+				return errIncomplete
+			}
+		}
+		return nil
+	}, 50, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range live {
+		h := replicas[id].History("0")
+		if history == nil {
+			history = h
+		}
+		for i := range h {
+			if !bytes.HasPrefix(h[i], []byte("v"+strconv.Itoa(i))) || !bytes.Equal(h[i], history[i]) {
+				t.Fatalf("replica %v executed %.8s at %d", id, h[i], i)
+			}
+		}
+	}
+}
+
+func TestDigest(t *testing.T) {
+	commands := []PaxiBFT.Command{
+		{Key: "a"},
+		{Key: "b"},
+		{Key: "a", Value: PaxiBFT.Value("v")},
+		{Key: "b", Value: PaxiBFT.Value("v")},
+		{Key: "a", Value: PaxiBFT.Value("v"), ClientID: "c", CommandID: 1},
+		{Key: "a", Value: PaxiBFT.Value("v"), ClientID: "c", CommandID: 2},
+		{Key: "a", Value: PaxiBFT.Value("v"), Expect: PaxiBFT.Value("w")},
+		{Op: PaxiBFT.OpTxn, Commands: []PaxiBFT.Command{{Key: "a"}}},
+		{Op: PaxiBFT.OpTxn, Commands: []PaxiBFT.Command{{Key: "b"}}},
+	}
+	digests := make(map[string]PaxiBFT.Command)
+	for _, c := range commands {
+		d := string(GetMD5Hash(&PaxiBFT.Request{Command: c}))
+		if other, ok := digests[d]; ok {
+			t.Errorf("commands %v and %v have the same digest", other, c)
+		}
+		digests[d] = c
+	}
+}
+
+func TestOrder(t *testing.T) {
+	*timeout = 200
+	c := PaxiBFT.MakeDefaultConfig()
+	c.MultiVersion = true
+	replicas := cluster(t, c, 20420, 4)
+	all := []PaxiBFT.ID{"1.1", "1.2", "1.3", "1.4"}
+
+	// two clients write different keys concurrently, replicas receive their requests in different order
+	var wait sync.WaitGroup
+	for _, key := range []PaxiBFT.Key{"a", "b"} {
+		wait.Add(1)
+		go func(key PaxiBFT.Key) {
+			defer wait.Done()
+			client := PaxiBFT.NewHTTPClient("")
+			client.Client.Timeout = 10 * time.Second
+			for i := 0; i < 5; i++ {
+				client.CID = i + 1
+				put(t, client, all, key, PaxiBFT.Value("v"+strconv.Itoa(i)))
+			}
+		}(key)
+	}
+	wait.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+	for _, key := range []PaxiBFT.Key{"a", "b"} {
+		for _, id := range all {
+			h := replicas[id].History(key)
+			if len(h) != 5 {
+				t.Fatalf("replica %v executed %d writes of key %v, expected 5", id, len(h), key)
+			}
+			for i := range h {
+				if !bytes.HasPrefix(h[i], []byte("v"+strconv.Itoa(i))) {
+					t.Fatalf("replica %v executed %.8s at %d of key %v", id, h[i], i, key)
+				}
+			}
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	*timeout = 200
+	all := []PaxiBFT.ID{"1.1", "1.2", "1.3", "1.4"}
+	c := PaxiBFT.MakeDefaultConfig()
+	c.WAL = PaxiBFT.StorageConfig{Engine: "file", Dir: t.TempDir(), Sync: "always"}
+	c.MultiVersion = true
+	replicas := cluster(t, c, 20440, 4)
+	client := PaxiBFT.NewHTTPClient("")
+	client.Client.Timeout = 10 * time.Second
+	client.Session = "c"
+	for i := 0; i < 3; i++ {
+		client.CID = i + 1
+		put(t, client, all, "0", PaxiBFT.Value("v"+strconv.Itoa(i)))
+	}
+	if t.Failed() {
+		t.FailNow()
+	}
+
+	// kill 1.4 and restart it from its write-ahead log
+	replicas["1.4"].Close()
+	flag.Set("recover", "true")
+	defer flag.Set("recover", "false")
+	r := NewReplica("1.4")
+	replicas["1.4"] = r
+	go r.Run()
+	err := PaxiBFT.Retry(func() error {
+		rep, err := http.Get(PaxiBFT.GetConfig().HTTPAddrs["1.4"] + "/history?key=0")
+		if err == nil {
+			rep.Body.Close()
+		}
+		return err
+	}, 50, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := r.History("0"); len(h) != 3 {
+		t.Fatalf("recovered history of 0 has %d values, expected 3", len(h))
+	}
+
+	// recovered replica answers a retry from its session table and keeps deciding new requests
+	if _, _, err := client.RESTPut("1.4", "0", PaxiBFT.Value("v2")); err != nil {
+		t.Errorf("retry to recovered 1.4: %v", err)
+	}
+	client.CID = 4
+	put(t, client, all, "0", PaxiBFT.Value("v3"))
+	for _, id := range all {
+		h := replicas[id].History("0")
+		if len(h) != 4 || !bytes.HasPrefix(h[3], []byte("v3")) {
+			t.Fatalf("replica %v executed %d values of 0, expected 4", id, len(h))
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package HotStuff2
+
+import (
+	"encoding/gob"
+	"fmt"
+
+	"github.com/salemmohammed/PaxiBFT"
+)
+
+func init() {
+	gob.Register(Prepare{})
+	gob.Register(ActPrepare{})
+	gob.Register(Commit{})
+	gob.Register(ActCommit{})
+	gob.Register(Decide{})
+	gob.Register(NewView{})
+}
+
+// QC is a quorum certificate of 2f+1 votes for digest of slot in view
+type QC struct {
+	View   int
+	Slot   int
+	Digest []byte
+	IDs    []PaxiBFT.ID
+}
+
+func (q *QC) String() string {
+	if q == nil {
+		return "QC {}"
+	}
+	return fmt.Sprintf("QC {View %v, Slot %v, Digest %x, IDs %v}", q.View, q.Slot, q.Digest, q.IDs)
+}
+
+// Prepare is the proposal of leader, justified by the highest lock among 2f+1 new view messages
+type Prepare struct {
+	View    int
+	ID      PaxiBFT.ID
+	Slot    int
+	Request PaxiBFT.Request
+	Digest  []byte
+	Justify *QC               // highest lock the proposal extends, nil if none
+	Proof   map[PaxiBFT.ID]QC // locks reported in new view messages by sender, view -1 if none
+}
+
+func (m Prepare) String() string {
+	return fmt.Sprintf("Prepare {View %v, ID %v, Slot %v, Digest %x, Justify %v}", m.View, m.ID, m.Slot, m.Digest, m.Justify)
+}
+
+// ActPrepare is the first phase vote
+type ActPrepare struct {
+	View   int
+	ID     PaxiBFT.ID
+	Slot   int
+	Digest []byte
+}
+
+func (m ActPrepare) String() string {
+	return fmt.Sprintf("ActPrepare {View %v, ID %v, Slot %v, Digest %x}", m.View, m.ID, m.Slot, m.Digest)
+}
+
+// Commit carries the prepare QC that replicas lock on
+type Commit struct {
+	View int
+	ID   PaxiBFT.ID
+	Slot int
+	QC   *QC
+}
+
+func (m Commit) String() string {
+	return fmt.Sprintf("Commit {View %v, ID %v, Slot %v, QC %v}", m.View, m.ID, m.Slot, m.QC)
+}
+
+// ActCommit is the second phase vote
+type ActCommit struct {
+	View   int
+	ID     PaxiBFT.ID
+	Slot   int
+	Digest []byte
+}
+
+func (m ActCommit) String() string {
+	return fmt.Sprintf("ActCommit {View %v, ID %v, Slot %v, Digest %x}", m.View, m.ID, m.Slot, m.Digest)
+}
+
+// Decide carries the commit QC
+type Decide struct {
+	View int
+	ID   PaxiBFT.ID
+	Slot int
+	QC   *QC
+}
+
+func (m Decide) String() string {
+	return fmt.Sprintf("Decide {View %v, ID %v, Slot %v, QC %v}", m.View, m.ID, m.Slot, m.QC)
+}
+
+// NewView is sent to the leader of next view on timeout, carrying the lock of sender
+// and the request it locked on
+type NewView struct {
+	View    int
+	ID      PaxiBFT.ID
+	Slot    int
+	Locked  *QC
+	Request PaxiBFT.Request
+}
+
+func (m NewView) String() string {
+	return fmt.Sprintf("NewView {View %v, ID %v, Slot %v, Locked %v}", m.View, m.ID, m.Slot, m.Locked)
+}
+
+// Timeout is a local event of view timer
+type Timeout struct {
+	View int
+	Slot int
+}
@@ -0,0 +1,34 @@
+package HotStuff2
+
+import (
+	"github.com/salemmohammed/PaxiBFT"
+	"github.com/salemmohammed/PaxiBFT/log"
+)
+
+// Replica for one two-phase hotstuff instance
+type Replica struct {
+	PaxiBFT.Node
+	*HotStuff2
+}
+
+// NewReplica generates new two-phase hotstuff replica
+func NewReplica(id PaxiBFT.ID) *Replica {
+	r := new(Replica)
+	r.Node = PaxiBFT.NewNode(id)
+	r.HotStuff2 = NewHotStuff2(r)
+	r.Register(PaxiBFT.Request{}, r.handleRequest)
+	r.Register(Prepare{}, r.HandlePrepare)
+	r.Register(ActPrepare{}, r.HandleActPrepare)
+	r.Register(Commit{}, r.HandleCommit)
+	r.Register(ActCommit{}, r.HandleActCommit)
+	r.Register(Decide{}, r.HandleDecide)
+	r.Register(NewView{}, r.HandleNewView)
+	r.Register(Timeout{}, r.HandleTimeout)
+	return r
+}
+
+// handleRequest queues request, replicas may receive requests in different order
+func (r *Replica) handleRequest(m PaxiBFT.Request) {
+	log.Debugf("Replica %s received %v\n", r.ID(), m)
+	r.HotStuff2.HandleRequest(m)
+}
@@ -77,7 +77,7 @@ func RemoveIndex(s []*PaxiBFT.Request, index int) []*PaxiBFT.Request {
 	if len(s) > 0 {
 		return append(s[:index], s[index+1:]...)
 	}else {
-		return append(s[:index])
+		return s[:index]
 	}
 }
 func (p *HotStuff) HandleRequest(r PaxiBFT.Request, slot int,total int) {
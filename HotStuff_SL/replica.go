@@ -84,7 +84,6 @@ func (p *Replica) handleRequest(m PaxiBFT.Request) {
 	log.Debugf("p.Node_ID = %v", p.Node_ID)
 
 	if p.Node_ID == p.ID() {
-		fmt.Println("w=%v", w)
 		log.Debugf(" The request appended = %v ", m.Command.Key)
 		log.Debugf("leader")
 		e.active = true
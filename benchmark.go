@@ -4,6 +4,7 @@ import (
 	"github.com/salemmohammed/PaxiBFT/log"
 	"math"
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 )
@@ -15,6 +16,13 @@ type DB interface {
 	Stop() error
 }
 
+// TxnDB is implemented by client libraries that run transactions,
+// a nil value reads its key
+type TxnDB interface {
+	DB
+	Txn(keys []int, values [][]byte) error
+}
+
 // Bconfig holds all benchmark configuration
 type Bconfig struct {
 	T                    int     // total number of running time in seconds
@@ -44,6 +52,9 @@ type Bconfig struct {
 	// exponential distribution
 	Lambda float64 // rate parameter
 	Size int // payload size
+
+	// transaction workload
+	TxnSize int // number of commands per transaction, single commands if less than 2
 }
 
 // DefaultBConfig returns a default benchmark config
@@ -67,6 +78,7 @@ func DefaultBConfig() Bconfig {
 		ZipfianV:             1,
 		Lambda:               0.01,
 		Size:				  128,
+		TxnSize:              0,
 	}
 }
 
@@ -139,7 +151,6 @@ func (b *Benchmark) Run() {
 	var stop chan bool
 	if b.Move {
 		move := func() { b.Mu = float64(int(b.Mu+1) % b.K) }
-		log.Debugf("b.move %v ", move)
 		stop = Schedule(move, time.Duration(b.Speed)*time.Millisecond)
 		log.Debugf("stop %v ", stop)
 		defer close(stop)
@@ -156,6 +167,7 @@ func (b *Benchmark) Run() {
 	}
 
 	b.db.Init()
+	load, traffic := b.load(), b.traffic()
 	b.startTime = time.Now()
 	if b.T > 0 {
 		timer := time.NewTimer(time.Second * time.Duration(b.T))
@@ -172,6 +184,7 @@ func (b *Benchmark) Run() {
 			}
 		}
 	} else {
+		go b.collect(latencies)
 		for i := 0; i < b.N; i++ {
 			log.Debugf("b.wait.Add Total number of request")
 			b.wait.Add(1)
@@ -187,9 +200,12 @@ func (b *Benchmark) Run() {
 	log.Infof("Concurrency = %d", b.Concurrency)
 	log.Infof("Write Ratio = %f", b.W)
 	log.Infof("Number of Keys = %d", b.K)
+	log.Infof("Storage Engine = %s (sync %s)", config.Storage.Engine, config.Storage.Sync)
 	log.Infof("Benchmark Time = %v\n", t)
 	log.Infof("Throughput = %f\n", float64(len(b.latency))/t.Seconds())
 	log.Info(stat)
+	b.reportLoad(load, b.load(), t)
+	b.reportTraffic(traffic, b.traffic(), len(b.latency))
 
 	stat.WriteFile("latency")
 	b.History.WriteFile("history")
@@ -206,6 +222,113 @@ func (b *Benchmark) Run() {
 	}
 }
 
+// load returns number of messages through the link of every node
+func (b *Benchmark) load() map[ID]Load {
+	c := NewHTTPClient("")
+	loads := make(map[ID]Load)
+	for id := range config.HTTPAddrs {
+		l, err := c.Load(id)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		loads[id] = l
+	}
+	return loads
+}
+
+// reportLoad logs messages through the link of every node during benchmark,
+// the busiest link is the one of the leader
+func (b *Benchmark) reportLoad(before, after map[ID]Load, t time.Duration) {
+	var leader ID
+	max := int64(-1)
+	for id, l := range after {
+		sent := l.Sent - before[id].Sent
+		received := l.Received - before[id].Received
+		log.Infof("Link load of %v = sent %d, received %d messages", id, sent, received)
+		if sent+received > max {
+			leader, max = id, sent+received
+		}
+	}
+	if max >= 0 {
+		log.Infof("Leader link load = %v with %f messages/s", leader, float64(max)/t.Seconds())
+	}
+
+	// bandwidth saved by compression over all links and its cost
+	var size, wire, nanos int64
+	for id, l := range after {
+		size += l.Bytes - before[id].Bytes
+		wire += l.Wire - before[id].Wire
+		nanos += l.Compress - before[id].Compress
+	}
+	if size > 0 {
+		log.Infof("Link bytes = %d on the wire for %d bytes of messages, %.1f%% saved in %v of compression",
+			wire, size, 100*float64(size-wire)/float64(size), time.Duration(nanos))
+	}
+
+	// keys are spread over shards by the router of client
+	for name, ids := range config.Shards {
+		var messages int64
+		for _, id := range ids {
+			messages += after[id].Sent - before[id].Sent + after[id].Received - before[id].Received
+		}
+		log.Infof("Shard %s load = %d messages with %f messages/s", name, messages, float64(messages)/t.Seconds())
+	}
+}
+
+// traffic returns messages and bytes every node sent by peer and message type
+func (b *Benchmark) traffic() map[ID]Traffic {
+	c := NewHTTPClient("")
+	traffic := make(map[ID]Traffic)
+	for id := range config.HTTPAddrs {
+		t, err := c.Traffic(id)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		traffic[id] = t
+	}
+	return traffic
+}
+
+// reportTraffic logs messages and bytes sent by every node and of every message type
+// during benchmark per committed request, the message complexity of the protocol
+func (b *Benchmark) reportTraffic(before, after map[ID]Traffic, requests int) {
+	if requests == 0 {
+		return
+	}
+	r := float64(requests)
+	types := make(map[string]Count)
+	var total Count
+	for id, t := range after {
+		prior := before[id].Total()
+		var sent Count
+		for name, c := range t.Total() {
+			c.Messages -= prior[name].Messages
+			c.Bytes -= prior[name].Bytes
+			s := types[name]
+			s.Messages += c.Messages
+			s.Bytes += c.Bytes
+			types[name] = s
+			sent.Messages += c.Messages
+			sent.Bytes += c.Bytes
+		}
+		total.Messages += sent.Messages
+		total.Bytes += sent.Bytes
+		log.Infof("Traffic of %v = sent %.2f messages, %.0f bytes per request", id, float64(sent.Messages)/r, float64(sent.Bytes)/r)
+	}
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := types[name]
+		log.Infof("Traffic of %s = %.2f messages, %.0f bytes per request", name, float64(c.Messages)/r, float64(c.Bytes)/r)
+	}
+	log.Infof("Traffic = %.2f messages, %.0f bytes per request over %d requests", float64(total.Messages)/r, float64(total.Bytes)/r, requests)
+}
+
 // generates key based on distribution
 func (b *Benchmark) next() int {
 	var key int
@@ -258,6 +381,10 @@ func (b *Benchmark) worker(keys <-chan int, result chan<- time.Duration) {
 	var err error
 	//data := make([]byte, 100)
 	for k := range keys {
+		if b.TxnSize > 1 {
+			b.txn(k, result)
+			continue
+		}
 		op := new(operation)
 		if rand.Float64() < b.W {
 			v = GenerateRandVal(b.Bconfig.Size)
@@ -279,6 +406,45 @@ func (b *Benchmark) worker(keys <-chan int, result chan<- time.Duration) {
 	}
 }
 
+// txn runs a transaction of the generated key and TxnSize-1 more keys chosen uniformly,
+// every command is a write by write ratio or a read
+func (b *Benchmark) txn(k int, result chan<- time.Duration) {
+	db, ok := b.db.(TxnDB)
+	if !ok {
+		log.Fatal("client library does not support transactions")
+	}
+	keys := make([]int, b.TxnSize)
+	values := make([][]byte, b.TxnSize)
+	keys[0] = k
+	for i := 1; i < b.TxnSize; i++ {
+		keys[i] = rand.Intn(b.K) + b.Min
+	}
+	for i := range values {
+		if rand.Float64() < b.W {
+			values[i] = GenerateRandVal(b.Bconfig.Size)
+		}
+	}
+	s := time.Now()
+	err := db.Txn(keys, values)
+	e := time.Now()
+	if err == nil {
+		result <- e.Sub(s)
+	} else {
+		log.Error(err)
+	}
+	for i := range keys {
+		op := &operation{input: values[i], start: s.Sub(b.startTime).Nanoseconds()}
+		if err == nil {
+			op.end = e.Sub(b.startTime).Nanoseconds()
+		} else {
+			op.end = math.MaxInt64
+		}
+		if values[i] != nil {
+			b.History.AddOperation(keys[i], op)
+		}
+	}
+}
+
 func (b *Benchmark) collect(latencies <-chan time.Duration) {
 	for t := range latencies {
 		b.latency = append(b.latency, t)
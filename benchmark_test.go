@@ -37,7 +37,7 @@ func (f *FakeDB) Read(key int) (int, error) {
 	return 0, nil
 }
 
-func (f *FakeDB) Write(key, value int) error {
+func (f *FakeDB) Write(key int, value []byte) error {
 	//log.Debugf("Write %d", key)
 	f.lock.Lock()
 	f.total++
@@ -2,5 +2,5 @@
 
 PID_FILE=server.pid
 
-./server -log_dir=. -log_level=info -id $1 -algorithm $2 -fz=0 &
+./server -log_dir=. -log_level=info -id $1 -algorithm $2 &
 echo $! >> ${PID_FILE}
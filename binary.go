@@ -0,0 +1,388 @@
+package PaxiBFT
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// binaryHeader of every message in the binary codec is its length in 4 bytes and type id in 2,
+// type id 0 is a message of unregistered type encoded by gob
+const binaryHeader = 6
+
+// binaryMaxLength bounds the length of one message, a longer one is taken as a corrupted stream
+const binaryMaxLength = 1 << 28
+
+// binaryMaxDepth bounds commands nested in commands, such as the commands of a transaction
+const binaryMaxDepth = 8
+
+// ErrMalformed is returned decoding a message that is cut short or otherwise corrupted
+var ErrMalformed = errors.New("malformed binary message")
+
+type codecBinary struct {
+	rw io.ReadWriter
+}
+
+func (c *codecBinary) Scheme() string {
+	return "binary"
+}
+
+func (c *codecBinary) Encode(m interface{}) error {
+	id, p, err := marshalBinary(message(m))
+	if err != nil {
+		return err
+	}
+	// one write of header and payload, so that concurrent frames never interleave
+	b := make([]byte, binaryHeader+len(p))
+	binary.BigEndian.PutUint32(b, uint32(len(p)))
+	binary.BigEndian.PutUint16(b[4:], id)
+	copy(b[binaryHeader:], p)
+	_, err = c.rw.Write(b)
+	return err
+}
+
+func (c *codecBinary) Decode(m interface{}) error {
+	var h [binaryHeader]byte
+	if _, err := io.ReadFull(c.rw, h[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(h[:])
+	if n > binaryMaxLength {
+		return ErrMalformed
+	}
+	p := make([]byte, n)
+	if _, err := io.ReadFull(c.rw, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	v, err := unmarshalBinary(binary.BigEndian.Uint16(h[4:]), p)
+	if err != nil {
+		return err
+	}
+	return decoded(m, v)
+}
+
+// marshalBinary encodes message by its registered id, or by gob with id 0
+func marshalBinary(m interface{}) (uint16, []byte, error) {
+	messages.RLock()
+	id, exists := messages.types[reflect.TypeOf(m)]
+	messages.RUnlock()
+	if exists {
+		w := new(BinaryWriter)
+		m.(BinaryMessage).WriteBinary(w)
+		return id, w.Bytes(), w.err
+	}
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(&m)
+	return 0, b.Bytes(), err
+}
+
+// unmarshalBinary decodes message of type id, a message read from the wire
+// must not take the receiver down however malformed it is
+func unmarshalBinary(id uint16, b []byte) (m interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			m, err = nil, ErrMalformed
+		}
+	}()
+	if id == 0 {
+		err = gob.NewDecoder(bytes.NewReader(b)).Decode(&m)
+		return m, err
+	}
+	messages.RLock()
+	t, exists := messages.ids[id]
+	messages.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("unknown binary message type %d", id)
+	}
+	v := reflect.New(t)
+	r := NewBinaryReader(b)
+	v.Interface().(binaryReader).ReadBinary(r)
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	return v.Elem().Interface(), nil
+}
+
+// BinaryWriter appends fields of a message for the binary codec,
+// integers as varints and byte strings prefixed by their length
+type BinaryWriter struct {
+	b   []byte
+	err error
+}
+
+// fail keeps the first error of encoding a nested message
+func (w *BinaryWriter) fail(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// Bytes returns the message written
+func (w *BinaryWriter) Bytes() []byte {
+	return w.b
+}
+
+func (w *BinaryWriter) Uint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.b = append(w.b, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func (w *BinaryWriter) Int(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	w.b = append(w.b, b[:binary.PutVarint(b[:], v)]...)
+}
+
+func (w *BinaryWriter) Bool(v bool) {
+	if v {
+		w.b = append(w.b, 1)
+	} else {
+		w.b = append(w.b, 0)
+	}
+}
+
+// Data writes byte string b, keeping nil apart from empty
+func (w *BinaryWriter) Data(b []byte) {
+	if b == nil {
+		w.Uint(0)
+		return
+	}
+	w.Uint(uint64(len(b)) + 1)
+	w.b = append(w.b, b...)
+}
+
+func (w *BinaryWriter) String(s string) {
+	w.Uint(uint64(len(s)))
+	w.b = append(w.b, s...)
+}
+
+// Map writes string map m in key order of iteration
+func (w *BinaryWriter) Map(m map[string]string) {
+	w.Uint(uint64(len(m)))
+	for k, v := range m {
+		w.String(k)
+		w.String(v)
+	}
+}
+
+func (w *BinaryWriter) Command(c Command) {
+	w.String(string(c.Key))
+	w.Data(c.Value)
+	w.String(string(c.ClientID))
+	w.Int(int64(c.CommandID))
+	w.Uint(uint64(c.Op))
+	w.String(string(c.To))
+	w.Int(int64(c.Limit))
+	w.Data(c.Expect)
+	w.Uint(uint64(len(c.Commands)))
+	for _, cmd := range c.Commands {
+		w.Command(cmd)
+	}
+}
+
+// BinaryReader reads fields in the order BinaryWriter appended them,
+// reading past the end gives zero values and ErrMalformed from Err
+type BinaryReader struct {
+	b     []byte
+	err   error
+	depth int // of the command being read
+}
+
+// NewBinaryReader reads message b
+func NewBinaryReader(b []byte) *BinaryReader {
+	return &BinaryReader{b: b}
+}
+
+// Err returns ErrMalformed if a field was cut short
+func (r *BinaryReader) Err() error {
+	return r.err
+}
+
+func (r *BinaryReader) Uint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = ErrMalformed
+		r.b = nil
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *BinaryReader) Int() int64 {
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.err = ErrMalformed
+		r.b = nil
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *BinaryReader) Bool() bool {
+	if len(r.b) == 0 {
+		r.err = ErrMalformed
+		return false
+	}
+	v := r.b[0] == 1
+	r.b = r.b[1:]
+	return v
+}
+
+// Len reads the number of elements that follow, each taking at least one byte,
+// so that a count corrupted on the wire never allocates more than the message
+func (r *BinaryReader) Len() int {
+	n := r.Uint()
+	if n > uint64(len(r.b)) {
+		r.err = ErrMalformed
+		r.b = nil
+		return 0
+	}
+	return int(n)
+}
+
+// next returns the next n bytes
+func (r *BinaryReader) next(n uint64) []byte {
+	if n > uint64(len(r.b)) {
+		r.err = ErrMalformed
+		r.b = nil
+		return nil
+	}
+	b := r.b[:n:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *BinaryReader) Data() []byte {
+	n := r.Uint()
+	if n == 0 {
+		return nil
+	}
+	return append([]byte{}, r.next(n-1)...)
+}
+
+func (r *BinaryReader) String() string {
+	return string(r.next(r.Uint()))
+}
+
+func (r *BinaryReader) Map() map[string]string {
+	n := r.Len()
+	m := make(map[string]string, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		k := r.String()
+		m[k] = r.String()
+	}
+	return m
+}
+
+func (r *BinaryReader) Command() Command {
+	c := Command{
+		Key:       Key(r.String()),
+		Value:     r.Data(),
+		ClientID:  ID(r.String()),
+		CommandID: int(r.Int()),
+		Op:        Op(r.Uint()),
+		To:        Key(r.String()),
+		Limit:     int(r.Int()),
+		Expect:    r.Data(),
+	}
+	if n := r.Len(); n > 0 {
+		if r.depth++; r.depth > binaryMaxDepth {
+			r.err = ErrMalformed
+			r.b = nil
+			return c
+		}
+		c.Commands = make([]Command, 0, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			c.Commands = append(c.Commands, r.Command())
+		}
+		r.depth--
+	}
+	return c
+}
+
+// errors replies carry by their message, others are recreated from it
+var replyErrors = map[string]error{
+	ErrStaleRequest.Error(): ErrStaleRequest,
+	ErrMismatch.Error():     ErrMismatch,
+	ErrAborted.Error():      ErrAborted,
+	ErrLocked.Error():       ErrLocked,
+}
+
+// replyError returns error of message s, nil if empty
+func replyError(s string) error {
+	if s == "" {
+		return nil
+	}
+	if err, ok := replyErrors[s]; ok {
+		return err
+	}
+	return errors.New(s)
+}
+
+func (r Request) WriteBinary(w *BinaryWriter) {
+	w.Command(r.Command)
+	w.Map(r.Properties)
+	w.Int(r.Timestamp)
+	w.String(string(r.NodeID))
+}
+
+func (r *Request) ReadBinary(br *BinaryReader) {
+	r.Command = br.Command()
+	r.Properties = br.Map()
+	r.Timestamp = br.Int()
+	r.NodeID = ID(br.String())
+}
+
+func (r Reply) WriteBinary(w *BinaryWriter) {
+	w.Command(r.Command)
+	w.Data(r.Value)
+	w.Map(r.Properties)
+	w.Int(r.Timestamp)
+	if r.Err != nil {
+		w.String(r.Err.Error())
+	} else {
+		w.String("")
+	}
+}
+
+func (r *Reply) ReadBinary(br *BinaryReader) {
+	r.Command = br.Command()
+	r.Value = br.Data()
+	r.Properties = br.Map()
+	r.Timestamp = br.Int()
+	r.Err = replyError(br.String())
+}
+
+func (e Envelope) WriteBinary(w *BinaryWriter) {
+	id, p, err := marshalBinary(e.Message)
+	if err != nil {
+		w.fail(err)
+	}
+	w.String(e.Namespace)
+	w.String(string(e.From))
+	w.Uint(uint64(id))
+	w.Data(p)
+}
+
+func (e *Envelope) ReadBinary(r *BinaryReader) {
+	e.Namespace = r.String()
+	e.From = ID(r.String())
+	id := r.Uint()
+	p := r.Data()
+	if r.Err() != nil {
+		return
+	}
+	m, err := unmarshalBinary(uint16(id), p)
+	if err != nil {
+		r.err = err
+	}
+	e.Message = m
+}
@@ -0,0 +1,605 @@
+package bullshark
+
+import (
+	"flag"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/salemmohammed/PaxiBFT"
+	"github.com/salemmohammed/PaxiBFT/log"
+)
+
+var batch = flag.Int("batch", 100, "maximum number of requests in one DAG vertex")
+var timeout = flag.Int("vertex_timeout", 100, "time in ms before asking replicas again for a missing vertex")
+var depth = flag.Int("gc_depth", 50, "rounds below the last committed anchor kept for replicas fetching them")
+
+const walVertex = "vertex/"
+const walCommit = "commit/"
+
+// anchored is the causal history of a committed anchor, persisted before it is executed
+type anchored struct {
+	Round    int
+	Commands []PaxiBFT.Command
+}
+
+// vertex is local state of one DAG vertex identified by round and author
+type vertex struct {
+	*Vertex   // content, nil until received
+	round     int
+	author    PaxiBFT.ID
+	quorum    *PaxiBFT.Quorum // votes collected by author
+	voted     bool            // voted for this round and author
+	certified bool            // certificate received or formed
+	votes     []PaxiBFT.ID    // voters of certificate
+	delivered bool            // in local DAG with all parents
+	ordered   bool            // executed as part of a committed anchor
+	fetching  bool            // replicas asked for missing content or certificate
+}
+
+func (v *vertex) parent(id PaxiBFT.ID) bool {
+	for _, p := range v.Parents {
+		if p == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Bullshark orders a DAG of reliably broadcast vertices without extra consensus messages:
+// every replica proposes one vertex per round, anchors in even rounds are committed when
+// f+1 vertices of the next round refer to them, and the causal history of each committed
+// anchor is executed in deterministic order.
+//
+// A replica missing the content or certificate of a vertex that it needs fetches both from
+// every replica. Rounds below the last committed anchor are pruned, their vertices not ordered
+// by then are never ordered and own requests in them are proposed again. Pruned rounds are
+// kept gc_depth rounds longer for replicas behind to fetch from.
+type Bullshark struct {
+	PaxiBFT.Node
+
+	ids       []PaxiBFT.ID                   // sorted replica ids for anchor rotation
+	f         int                            // number of byzantine replicas tolerated
+	round     int                            // round of next own vertex
+	highest   int                            // highest round seen
+	dag       map[int]map[PaxiBFT.ID]*vertex // vertices by round and author
+	waiting   map[*vertex]bool               // vertices not yet delivered into DAG
+	pending   []*PaxiBFT.Request             // requests waiting to be proposed
+	batches   map[int][]*PaxiBFT.Request     // own proposed requests by round, waiting for reply
+	committed int                            // round of last committed anchor, lowest round not pruned
+	unordered int                            // delivered vertices with requests not ordered yet
+	execute   int                            // number of committed anchors persisted in write-ahead log
+}
+
+// NewBullshark creates new bullshark instance
+func NewBullshark(n PaxiBFT.Node, options ...func(*Bullshark)) *Bullshark {
+	ids := PaxiBFT.GetConfig().IDs()
+	sort.Sort(PaxiBFT.IDs(ids))
+	p := &Bullshark{
+		Node:      n,
+		ids:       ids,
+		f:         (len(ids) - 1) / 3,
+		highest:   -1,
+		dag:       make(map[int]map[PaxiBFT.ID]*vertex),
+		waiting:   make(map[*vertex]bool),
+		pending:   make([]*PaxiBFT.Request, 0),
+		batches:   make(map[int][]*PaxiBFT.Request),
+		committed: -2,
+	}
+	for _, opt := range options {
+		opt(p)
+	}
+	if n.WAL().Recovering() {
+		p.recover()
+	}
+	return p
+}
+
+// recover executes the committed anchors from write-ahead log again to rebuild the state machine,
+// and restores the vertices this replica voted for or proposed in rounds not pruned
+func (p *Bullshark) recover() {
+	w := p.WAL()
+	var a anchored
+	for w.Get(walCommit+strconv.Itoa(p.execute), &a) {
+		for _, c := range a.Commands {
+			p.Execute(c)
+		}
+		p.committed = a.Round
+		p.execute++
+		a = anchored{}
+	}
+	if p.committed >= 0 {
+		// the anchor is fetched again only as parent of later vertices
+		p.get(p.committed, p.leader(p.committed)).ordered = true
+		p.round = p.committed + 1
+	}
+	n := 0
+	for _, k := range w.Keys(walVertex) {
+		var m Vertex
+		if !w.Get(k, &m) {
+			continue
+		}
+		if m.Round < p.committed {
+			w.Delete(k)
+			continue
+		}
+		v := p.get(m.Round, m.Author)
+		v.Vertex = &m
+		v.voted = true
+		n++
+		if m.Author != p.ID() {
+			continue
+		}
+		v.quorum = PaxiBFT.NewQuorum()
+		v.quorum.ACK(p.ID())
+		v.quorum.AID_ID(p.ID())
+		if m.Round >= p.round {
+			p.round = m.Round + 1
+		}
+		// votes for own vertex are lost in the crash
+		p.Send(p.ID(), retry{Round: m.Round, Author: m.Author})
+	}
+	log.Infof("Replica %s recovered %d vertices and committed anchors up to round %d", p.ID(), n, p.committed)
+}
+
+// key returns write-ahead log key of vertex of author in round
+func key(round int, author PaxiBFT.ID) string {
+	return walVertex + strconv.Itoa(round) + "/" + string(author)
+}
+
+// equal returns true if vertices have the same parents and requests
+func equal(a, b *Vertex) bool {
+	if len(a.Parents) != len(b.Parents) || len(a.Requests) != len(b.Requests) {
+		return false
+	}
+	for i := range a.Parents {
+		if a.Parents[i] != b.Parents[i] {
+			return false
+		}
+	}
+	for i := range a.Requests {
+		if !a.Requests[i].Command.Equal(b.Requests[i].Command) {
+			return false
+		}
+	}
+	return true
+}
+
+// quorum is the size of byzantine quorum 2f+1
+func (p *Bullshark) quorum() int {
+	return 2*p.f + 1
+}
+
+// get returns local state of vertex, creating it on first access
+func (p *Bullshark) get(round int, author PaxiBFT.ID) *vertex {
+	if _, exists := p.dag[round]; !exists {
+		p.dag[round] = make(map[PaxiBFT.ID]*vertex)
+	}
+	v, exists := p.dag[round][author]
+	if !exists {
+		v = &vertex{
+			round:  round,
+			author: author,
+		}
+		p.dag[round][author] = v
+		p.waiting[v] = true
+	}
+	if round > p.highest {
+		p.highest = round
+	}
+	return v
+}
+
+// leader returns the anchor author of even round r
+func (p *Bullshark) leader(r int) PaxiBFT.ID {
+	return p.ids[(r/2)%len(p.ids)]
+}
+
+// anchor returns the delivered anchor vertex of round r
+func (p *Bullshark) anchor(r int) *vertex {
+	v, exists := p.dag[r][p.leader(r)]
+	if !exists || !v.delivered {
+		return nil
+	}
+	return v
+}
+
+// HandleRequest queues client request into next own vertex
+func (p *Bullshark) HandleRequest(r PaxiBFT.Request) {
+	p.pending = append(p.pending, &r)
+	p.propose()
+}
+
+// ready returns true if previous round has 2f+1 delivered vertices including own,
+// and there is something to order so that an idle DAG does not grow
+func (p *Bullshark) ready() bool {
+	if p.round > 0 {
+		prev := p.dag[p.round-1]
+		// own vertex is missing only in rounds skipped after pruning
+		if v, exists := prev[p.ID()]; exists && !v.delivered {
+			return false
+		}
+		n := 0
+		for _, v := range prev {
+			if v.delivered {
+				n++
+			}
+		}
+		if n < p.quorum() {
+			return false
+		}
+	}
+	return len(p.pending) > 0 || p.unordered > 0 || p.highest >= p.round
+}
+
+// propose broadcasts own vertex of current round referring to every delivered vertex of previous round
+func (p *Bullshark) propose() {
+	if !p.ready() {
+		return
+	}
+
+	parents := make([]PaxiBFT.ID, 0)
+	for id, v := range p.dag[p.round-1] {
+		if v.delivered {
+			parents = append(parents, id)
+		}
+	}
+	sort.Sort(PaxiBFT.IDs(parents))
+
+	size := len(p.pending)
+	if size > *batch {
+		size = *batch
+	}
+	requests := make([]PaxiBFT.Request, size)
+	for i, r := range p.pending[:size] {
+		requests[i] = *r
+	}
+	p.batches[p.round] = p.pending[:size]
+	p.pending = p.pending[size:]
+
+	m := Vertex{
+		Round:    p.round,
+		Author:   p.ID(),
+		Parents:  parents,
+		Requests: requests,
+	}
+	log.Debugf("Replica %s proposes %v", p.ID(), m)
+
+	v := p.get(p.round, p.ID())
+	v.Vertex = &m
+	v.voted = true
+	v.quorum = PaxiBFT.NewQuorum()
+	v.quorum.ACK(p.ID())
+	v.quorum.AID_ID(p.ID())
+	p.round++
+	p.WAL().Put(key(m.Round, m.Author), m)
+	p.Broadcast(m)
+}
+
+// HandleVertex votes for the first vertex of each author in a round
+func (p *Bullshark) HandleVertex(m Vertex) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.Author, m, p.ID())
+	if m.Round < p.committed {
+		return
+	}
+	if m.Round > 0 && len(m.Parents) < p.quorum() {
+		log.Errorf("vertex %v refers to less than %d parents", m, p.quorum())
+		return
+	}
+	v := p.get(m.Round, m.Author)
+	if v.voted {
+		// a recovered author broadcasts its vertex again for votes lost in its crash
+		if v.Vertex != nil && equal(v.Vertex, &m) {
+			p.Send(m.Author, Vote{Round: m.Round, Author: m.Author, ID: p.ID()})
+		}
+		return
+	}
+	v.voted = true
+	v.Vertex = &m
+	p.WAL().Put(key(m.Round, m.Author), m)
+	p.Send(m.Author, Vote{
+		Round:  m.Round,
+		Author: m.Author,
+		ID:     p.ID(),
+	})
+	p.deliver()
+	p.propose()
+}
+
+// HandleVote certifies own vertex with 2f+1 votes
+func (p *Bullshark) HandleVote(m Vote) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, p.ID())
+	v, exists := p.dag[m.Round][p.ID()]
+	if !exists || m.Author != p.ID() || v.certified {
+		return
+	}
+	v.quorum.ACK(m.ID)
+	v.quorum.AID_ID(m.ID)
+	if v.quorum.Size() >= p.quorum() {
+		v.certified = true
+		v.votes = v.quorum.AID
+		p.Broadcast(Certificate{
+			Round:  m.Round,
+			Author: p.ID(),
+			Votes:  v.quorum.AID,
+		})
+		p.deliver()
+		p.propose()
+	}
+}
+
+// HandleCertificate marks vertex as reliably broadcast
+func (p *Bullshark) HandleCertificate(m Certificate) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.Author, m, p.ID())
+	if m.Round < p.committed || !p.valid(m.Votes) {
+		return
+	}
+	v := p.get(m.Round, m.Author)
+	v.certified = true
+	v.votes = m.Votes
+	p.deliver()
+	p.propose()
+}
+
+// valid returns true if votes of certificate come from 2f+1 replicas
+func (p *Bullshark) valid(votes []PaxiBFT.ID) bool {
+	q := PaxiBFT.NewQuorum()
+	for _, id := range votes {
+		q.ACK(id)
+	}
+	if q.Size() < p.quorum() {
+		log.Errorf("certificate with votes %v has less than %d votes", votes, p.quorum())
+		return false
+	}
+	return true
+}
+
+// fetch asks every replica for vertex v until it has content and certificate
+func (p *Bullshark) fetch(v *vertex) {
+	if v.fetching {
+		return
+	}
+	v.fetching = true
+	p.ask(v)
+}
+
+// ask broadcasts fetch of vertex v and retries after timeout
+func (p *Bullshark) ask(v *vertex) {
+	log.Debugf("Replica %s fetches vertex r=%d author=%v", p.ID(), v.round, v.author)
+	p.Broadcast(Fetch{Round: v.round, Author: v.author, ID: p.ID()})
+	time.AfterFunc(time.Duration(*timeout)*time.Millisecond, func() {
+		p.Send(p.ID(), retry{Round: v.round, Author: v.author})
+	})
+}
+
+// HandleRetry asks again for a vertex still missing,
+// or broadcasts again own vertex restored from write-ahead log without certificate
+func (p *Bullshark) HandleRetry(m retry) {
+	v, exists := p.dag[m.Round][m.Author]
+	if !exists || m.Round < p.committed || v.Vertex != nil && v.certified {
+		return
+	}
+	if m.Author == p.ID() && v.Vertex != nil {
+		p.Broadcast(*v.Vertex)
+		return
+	}
+	p.ask(v)
+}
+
+// HandleFetch answers with vertex and its certificate if both are present
+func (p *Bullshark) HandleFetch(m Fetch) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, p.ID())
+	if v, exists := p.dag[m.Round][m.Author]; exists && v.Vertex != nil && v.certified {
+		p.Send(m.ID, Certified{Vertex: *v.Vertex, Votes: v.votes})
+	}
+}
+
+// HandleCertified adds fetched vertex with its certificate
+func (p *Bullshark) HandleCertified(m Certified) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.Author, m, p.ID())
+	if m.Round < p.committed || !p.valid(m.Votes) {
+		return
+	}
+	v := p.get(m.Round, m.Author)
+	if v.Vertex == nil {
+		v.Vertex = &m.Vertex
+	}
+	v.certified = true
+	v.votes = m.Votes
+	p.deliver()
+	p.propose()
+}
+
+// deliver adds certified vertices into local DAG once all their parents are delivered,
+// fetching certified vertices without content and parents missing content or certificate;
+// parents in pruned rounds are never ordered and not needed
+func (p *Bullshark) deliver() {
+	for progress := true; progress; {
+		progress = false
+		for v := range p.waiting {
+			if v.certified && v.Vertex == nil {
+				p.fetch(v)
+			}
+			if v.Vertex == nil || !v.certified {
+				continue
+			}
+			ok := true
+			for _, id := range v.Parents {
+				if v.round-1 < p.committed {
+					break
+				}
+				u := p.get(v.round-1, id)
+				if u.Vertex == nil || !u.certified {
+					p.fetch(u)
+				}
+				ok = ok && u.delivered
+			}
+			if !ok {
+				continue
+			}
+			delete(p.waiting, v)
+			v.delivered = true
+			if len(v.Requests) > 0 && !v.ordered {
+				p.unordered++
+			}
+			progress = true
+			if v.round%2 == 1 {
+				p.commit(v.round - 1)
+			}
+		}
+	}
+}
+
+// commit commits anchor of even round r if f+1 vertices in round r+1 refer to it,
+// together with every previous uncommitted anchor it has a path to
+func (p *Bullshark) commit(r int) {
+	if r <= p.committed {
+		return
+	}
+	a := p.anchor(r)
+	if a == nil {
+		return
+	}
+	votes := 0
+	for _, v := range p.dag[r+1] {
+		if v.delivered && v.parent(a.author) {
+			votes++
+		}
+	}
+	if votes < p.f+1 {
+		return
+	}
+
+	anchors := []*vertex{a}
+	for i := r - 2; i > p.committed; i -= 2 {
+		if b := p.anchor(i); b != nil && p.path(anchors[len(anchors)-1], b) {
+			anchors = append(anchors, b)
+		}
+	}
+	for i := len(anchors) - 1; i >= 0; i-- {
+		log.Debugf("Replica %s commits anchor r=%d author=%v", p.ID(), anchors[i].round, anchors[i].author)
+		p.order(anchors[i])
+		// history of next anchor ends at this one on every replica however anchors are committed together
+		p.prune(anchors[i].round)
+	}
+}
+
+// prune stops delivering and ordering vertices below committed anchor of round r,
+// proposing own requests not ordered in them again, and removes rounds gc_depth rounds below
+func (p *Bullshark) prune(r int) {
+	for round := p.committed; round < r; round++ {
+		for _, v := range p.dag[round] {
+			if v.delivered && !v.ordered && len(v.Requests) > 0 {
+				p.unordered--
+			}
+			if v.voted {
+				p.WAL().Delete(key(round, v.author))
+			}
+			delete(p.waiting, v)
+		}
+	}
+	p.committed = r
+	for round := range p.dag {
+		if round < r-*depth {
+			delete(p.dag, round)
+		}
+	}
+	pending := make([]*PaxiBFT.Request, 0, len(p.pending))
+	for round, requests := range p.batches {
+		if round < r {
+			pending = append(pending, requests...)
+			delete(p.batches, round)
+		}
+	}
+	p.pending = append(pending, p.pending...)
+	if p.round <= r {
+		// round r has 2f+1 delivered vertices to refer to
+		p.round = r + 1
+	}
+}
+
+// path returns true if vertex from causally follows vertex to
+func (p *Bullshark) path(from, to *vertex) bool {
+	visited := make(map[*vertex]bool)
+	stack := []*vertex{from}
+	for len(stack) > 0 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if v == to {
+			return true
+		}
+		if visited[v] || v.round <= to.round {
+			continue
+		}
+		visited[v] = true
+		for _, id := range v.Parents {
+			stack = append(stack, p.dag[v.round-1][id])
+		}
+	}
+	return false
+}
+
+// order executes the not yet ordered causal history of anchor by round and author
+func (p *Bullshark) order(anchor *vertex) {
+	history := make([]*vertex, 0)
+	stack := []*vertex{anchor}
+	for len(stack) > 0 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if v.ordered {
+			continue
+		}
+		v.ordered = true
+		history = append(history, v)
+		if v.round-1 < p.committed {
+			// rounds below last committed anchor are pruned
+			continue
+		}
+		for _, id := range v.Parents {
+			stack = append(stack, p.dag[v.round-1][id])
+		}
+	}
+	sort.Slice(history, func(i, j int) bool {
+		if history[i].round != history[j].round {
+			return history[i].round < history[j].round
+		}
+		return PaxiBFT.IDs{history[i].author, history[j].author}.Less(0, 1)
+	})
+	if p.WAL() != nil {
+		a := anchored{Round: anchor.round, Commands: make([]PaxiBFT.Command, 0)}
+		for _, v := range history {
+			for _, r := range v.Requests {
+				a.Commands = append(a.Commands, r.Command)
+			}
+		}
+		p.WAL().Put(walCommit+strconv.Itoa(p.execute), a)
+		p.execute++
+	}
+	for _, v := range history {
+		p.exec(v)
+	}
+}
+
+// exec executes requests of vertex and replies to clients of own vertex
+func (p *Bullshark) exec(v *vertex) {
+	if len(v.Requests) > 0 {
+		p.unordered--
+	}
+	for i, r := range v.Requests {
+		value := p.Execute(r.Command)
+		if v.author != p.ID() || p.batches[v.round] == nil {
+			// requests of own vertex restored from write-ahead log are answered from client sessions
+			continue
+		}
+		reply := PaxiBFT.Reply{
+			Command:    r.Command,
+			Value:      value,
+			Properties: make(map[string]string),
+			Timestamp:  r.Timestamp,
+		}
+		p.batches[v.round][i].Reply(reply)
+	}
+	if v.author == p.ID() {
+		delete(p.batches, v.round)
+	}
+}
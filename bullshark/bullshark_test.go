@@ -0,0 +1,196 @@
+package bullshark
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/salemmohammed/PaxiBFT"
+)
+
+var errIncomplete = errors.New("history incomplete")
+
+// cluster starts n bullshark replicas in simulation with configuration c,
+// they are closed at the end of test before the next cluster changes configuration
+func cluster(t *testing.T, c PaxiBFT.Config, port, n int) map[PaxiBFT.ID]*Replica {
+	PaxiBFT.Simulation()
+	c.Addrs = make(map[PaxiBFT.ID]string)
+	c.HTTPAddrs = make(map[PaxiBFT.ID]string)
+	for i := 1; i <= n; i++ {
+		id := PaxiBFT.NewID(1, i)
+		p := strconv.Itoa(port + i)
+		c.Addrs[id] = "chan://127.0.0.1:" + p
+		c.HTTPAddrs[id] = "http://127.0.0.1:" + p
+	}
+	PaxiBFT.SetConfig(c)
+
+	replicas := make(map[PaxiBFT.ID]*Replica)
+	t.Cleanup(func() {
+		for _, r := range replicas {
+			r.Close()
+		}
+	})
+	for id := range c.Addrs {
+		replicas[id] = NewReplica(id)
+		go replicas[id].Run()
+		err := PaxiBFT.Retry(func() error {
+			r, err := http.Get(c.HTTPAddrs[id] + "/history?key=0")
+			if err == nil {
+				r.Body.Close()
+			}
+			return err
+		}, 50, 10*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return replicas
+}
+
+// puts writes concurrently through every replica, each one alternating the given keys
+func puts(t *testing.T, replicas map[PaxiBFT.ID]*Replica, keys, writes int) {
+	var wait sync.WaitGroup
+	for id := range replicas {
+		wait.Add(1)
+		go func(id PaxiBFT.ID) {
+			defer wait.Done()
+			client := NewClient(id)
+			client.Client.Timeout = 5 * time.Second
+			for i := 0; i < writes; i++ {
+				v := PaxiBFT.Value(string(id) + "-" + strconv.Itoa(i))
+				if err := client.PutMUL(PaxiBFT.Key(strconv.Itoa(i%keys)), v); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(id)
+	}
+	wait.Wait()
+}
+
+// executed waits until every replica executed n writes of key and checks they did in the same order
+func executed(t *testing.T, replicas map[PaxiBFT.ID]*Replica, key PaxiBFT.Key, n int) {
+	err := PaxiBFT.Retry(func() error {
+		for _, r := range replicas {
+			if len(r.History(key)) < n {
+				return errIncomplete
+			}
+		}
+		return nil
+	}, 50, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var history []PaxiBFT.Value
+	for id, r := range replicas {
+		h := r.History(key)
+		if history == nil {
+			history = h
+			continue
+		}
+		for i := range h {
+			if !bytes.Equal(h[i], history[i]) {
+				t.Fatalf("replica %v executed %.8s at %d of key %v, others %.8s", id, h[i], i, key, history[i])
+			}
+		}
+	}
+}
+
+func TestBullshark(t *testing.T) {
+	c := PaxiBFT.MakeDefaultConfig()
+	c.MultiVersion = true
+	replicas := cluster(t, c, 20300, 4)
+
+	// every replica proposes its own clients' writes to the same keys concurrently,
+	// and executes the same sequence of writes
+	const writes = 20
+	puts(t, replicas, 2, writes)
+	for k := 0; k < 2; k++ {
+		executed(t, replicas, PaxiBFT.Key(strconv.Itoa(k)), len(replicas)*writes/2)
+	}
+
+	// anchors of crashed replica are skipped while others keep committing
+	replicas["1.4"].Crash(10)
+	var wait sync.WaitGroup
+	for _, id := range []PaxiBFT.ID{"1.1", "1.2", "1.3"} {
+		wait.Add(1)
+		go func(id PaxiBFT.ID) {
+			defer wait.Done()
+			client := NewClient(id)
+			client.Client.Timeout = 5 * time.Second
+			for i := 0; i < 5; i++ {
+				if err := client.PutMUL(PaxiBFT.Key("2"), PaxiBFT.Value(id)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(id)
+	}
+	wait.Wait()
+}
+
+func TestFetch(t *testing.T) {
+	c := PaxiBFT.MakeDefaultConfig()
+	c.MultiVersion = true
+	replicas := cluster(t, c, 20310, 4)
+
+	// 1.3 receives no vertex nor certificate of 1.4, it fetches those others refer to
+	replicas["1.4"].Drop("1.3", 1)
+	const writes = 10
+	puts(t, replicas, 1, writes)
+	executed(t, replicas, "0", len(replicas)*writes)
+
+	// rounds gc_depth below the last committed anchor are removed
+	for id, r := range replicas {
+		// dag is read once no handle function runs
+		r.Close()
+		if r.committed < 0 {
+			t.Errorf("replica %v committed no anchor", id)
+		}
+		for round := range r.dag {
+			if round < r.committed-*depth {
+				t.Errorf("replica %v keeps round %d below committed anchor %d", id, round, r.committed)
+			}
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	c := PaxiBFT.MakeDefaultConfig()
+	c.WAL = PaxiBFT.StorageConfig{Engine: "file", Dir: t.TempDir(), Sync: "always"}
+	c.MultiVersion = true
+	replicas := cluster(t, c, 20320, 4)
+
+	// 1.4 restarts from its write-ahead log with the writes of committed anchors
+	const writes = 5
+	puts(t, replicas, 1, writes)
+	executed(t, replicas, "0", len(replicas)*writes)
+	replicas["1.4"].Close()
+	flag.Set("recover", "true")
+	defer flag.Set("recover", "false")
+	r := NewReplica("1.4")
+	replicas["1.4"] = r
+	if r.committed < 0 {
+		t.Fatal("recovered 1.4 committed no anchor")
+	}
+	go r.Run()
+	err := PaxiBFT.Retry(func() error {
+		rep, err := http.Get(PaxiBFT.GetConfig().HTTPAddrs["1.4"] + "/history?key=0")
+		if err == nil {
+			rep.Body.Close()
+		}
+		return err
+	}, 50, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// recovered replica keeps proposing and ordering the writes of every replica
+	puts(t, replicas, 1, writes)
+	executed(t, replicas, "0", 2*len(replicas)*writes)
+}
@@ -0,0 +1,23 @@
+package bullshark
+
+import (
+	"github.com/salemmohammed/PaxiBFT"
+)
+
+// Client sends every request to a single replica,
+// because all replicas propose batches and there is no leader to reach
+type Client struct {
+	*PaxiBFT.HTTPClient
+}
+
+// NewClient creates client of replica id
+func NewClient(id PaxiBFT.ID) *Client {
+	return &Client{
+		HTTPClient: PaxiBFT.NewHTTPClient(id),
+	}
+}
+
+// PutMUL puts the value through local replica only instead of every replica
+func (c *Client) PutMUL(key PaxiBFT.Key, value PaxiBFT.Value) error {
+	return c.Put(key, value)
+}
@@ -0,0 +1,76 @@
+package bullshark
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/salemmohammed/PaxiBFT"
+)
+
+func init() {
+	gob.Register(Vertex{})
+	gob.Register(Vote{})
+	gob.Register(Certificate{})
+	gob.Register(Fetch{})
+	gob.Register(Certified{})
+}
+
+// Vertex is one DAG node carrying a batch of requests, broadcast by its author once per round
+type Vertex struct {
+	Round    int
+	Author   PaxiBFT.ID
+	Parents  []PaxiBFT.ID // authors of certified vertices in previous round
+	Requests []PaxiBFT.Request
+}
+
+func (m Vertex) String() string {
+	return fmt.Sprintf("Vertex {r=%d author=%v parents=%v batch=%d}", m.Round, m.Author, m.Parents, len(m.Requests))
+}
+
+// Vote acknowledges the first vertex received from author in round
+type Vote struct {
+	Round  int
+	Author PaxiBFT.ID
+	ID     PaxiBFT.ID // from node id
+}
+
+func (m Vote) String() string {
+	return fmt.Sprintf("Vote {r=%d author=%v id=%v}", m.Round, m.Author, m.ID)
+}
+
+// Certificate proves 2f+1 replicas voted for the vertex, so it is reliably broadcast
+type Certificate struct {
+	Round  int
+	Author PaxiBFT.ID
+	Votes  []PaxiBFT.ID
+}
+
+func (m Certificate) String() string {
+	return fmt.Sprintf("Certificate {r=%d author=%v votes=%v}", m.Round, m.Author, m.Votes)
+}
+
+// Fetch asks for the content and certificate of vertex of author in round
+type Fetch struct {
+	Round  int
+	Author PaxiBFT.ID
+	ID     PaxiBFT.ID // from node id
+}
+
+func (m Fetch) String() string {
+	return fmt.Sprintf("Fetch {r=%d author=%v id=%v}", m.Round, m.Author, m.ID)
+}
+
+// Certified answers fetch with vertex and votes of its certificate
+type Certified struct {
+	Vertex
+	Votes []PaxiBFT.ID
+}
+
+func (m Certified) String() string {
+	return fmt.Sprintf("Certified {%v votes=%v}", m.Vertex, m.Votes)
+}
+
+// retry is a local timer event of a fetch
+type retry struct {
+	Round  int
+	Author PaxiBFT.ID
+}
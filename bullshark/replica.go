@@ -0,0 +1,32 @@
+package bullshark
+
+import (
+	"github.com/salemmohammed/PaxiBFT"
+	"github.com/salemmohammed/PaxiBFT/log"
+)
+
+// Replica for one Bullshark instance
+type Replica struct {
+	PaxiBFT.Node
+	*Bullshark
+}
+
+// NewReplica generates new Bullshark replica
+func NewReplica(id PaxiBFT.ID) *Replica {
+	r := new(Replica)
+	r.Node = PaxiBFT.NewNode(id)
+	r.Bullshark = NewBullshark(r)
+	r.Register(PaxiBFT.Request{}, r.handleRequest)
+	r.Register(Vertex{}, r.HandleVertex)
+	r.Register(Vote{}, r.HandleVote)
+	r.Register(Certificate{}, r.HandleCertificate)
+	r.Register(Fetch{}, r.HandleFetch)
+	r.Register(Certified{}, r.HandleCertified)
+	r.Register(retry{}, r.HandleRetry)
+	return r
+}
+
+func (r *Replica) handleRequest(m PaxiBFT.Request) {
+	log.Debugf("Replica %s received %v\n", r.ID(), m)
+	r.Bullshark.HandleRequest(m)
+}
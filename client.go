@@ -10,8 +10,11 @@ import (
 	"github.com/salemmohammed/PaxiBFT/log"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
+	"sort"
 	"strconv"
 	"sync"
 )
@@ -20,6 +23,7 @@ import (
 type Client interface {
 	PutMUL(Key, Value) error
 	Put(Key, Value) error
+	Txn([]Command) (TransactionReply, error)
 }
 
 // AdminClient interface provides fault injection opeartion
@@ -42,6 +46,42 @@ type HTTPClient struct {
 	Count  int
 	*http.Client
 	MyList []ID
+
+	Session ID      // client id of requests, unique to this client
+	Router  *Router // shard of every key, nil if keys are not sharded
+	// Namespace of the consensus instance keys are accessed in, the default instance if empty
+	Namespace string
+	mu      sync.Mutex
+	idle    []*session // sessions without outstanding request
+	opened  int        // number of sessions opened
+}
+
+// session of a client sends one request at a time with increasing command ids,
+// so that replicas can tell a retry of the last request from a new one
+type session struct {
+	id  ID
+	cid int
+}
+
+// session takes an idle session of client or opens a new one, so concurrent requests
+// of the client never share a session
+func (c *HTTPClient) session() *session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n := len(c.idle); n > 0 {
+		s := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		return s
+	}
+	c.opened++
+	return &session{id: ID(fmt.Sprintf("%s/%d", c.Session, c.opened))}
+}
+
+// release returns session once its request is replied
+func (c *HTTPClient) release(s *session) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.idle = append(c.idle, s)
 }
 
 // NewHTTPClient creates a new Client from config
@@ -52,9 +92,11 @@ func NewHTTPClient(id ID) *HTTPClient {
 		Addrs:  config.Addrs,
 		HTTP:   config.HTTPAddrs,
 		Client: &http.Client{},
+		Router: NewRouter(config.Shards),
 		Limit:  0,
 		Count:  0,
 	}
+	c.Session = ID(fmt.Sprintf("%s-%x", id, rand.Uint64()))
 	if id != "" {
 		i := 0
 		for node := range c.Addrs {
@@ -68,24 +110,34 @@ func NewHTTPClient(id ID) *HTTPClient {
 	return c
 }
 func (c *HTTPClient) Put(key Key, value Value) error {
-	c.CID++
-	_, _, err := c.RESTPut(c.ID, key, value)
+	_, _, err := c.PutTo(c.ID, key, value)
 	return err
 }
+
+// PutTo puts value to node id as the next request of a client session and returns previous value
+func (c *HTTPClient) PutTo(id ID, key Key, value Value) (Value, map[string]string, error) {
+	s := c.session()
+	defer c.release(s)
+	s.cid++
+	return c.rest(id, key, value, s.id, s.cid)
+}
 func (c *HTTPClient) PutMUL(key Key, value Value) error {
 	log.Debugf("<----------------PutMUL---------------->")
 	i  := 0
 	errs := make(chan error, 0)
 	c.Count++
-	for id := range c.HTTP {
+	// every replica gets the same request of the session
+	s := c.session()
+	defer c.release(s)
+	s.cid++
+	for _, id := range c.replicas(key) {
 		//if i>1{
 		//	continue
 		//}
 		log.Debugf("range id %v", id)
 		//c.MyList = append(c.MyList,id)
 		go func(id ID) {
-			c.CID++
-			_, _, err := c.rest(id, key, value,c.CID)
+			_, _, err := c.rest(id, key, value, s.id, s.cid)
 			if err != nil {
 				log.Error(err)
 				return
@@ -103,7 +155,47 @@ func (c *HTTPClient) PutMUL(key Key, value Value) error {
 	fmt.Println("----------------Done PutMUL---------------->")
 	return errors[0]
 }
+// replicas returns every node replicating key
+func (c *HTTPClient) replicas(key Key) []ID {
+	if c.Router != nil {
+		return c.Router.Replicas(key)
+	}
+	ids := make([]ID, 0, len(c.HTTP))
+	for id := range c.HTTP {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// route returns id if node id replicates key, otherwise a replica of the shard of key,
+// preferably in the zone of client
+func (c *HTTPClient) route(id ID, key Key) ID {
+	if c.Router == nil {
+		return id
+	}
+	return c.pick(id, c.Router.Replicas(key))
+}
+
+// pick returns id if it is one of replicas, otherwise a replica in the zone of client or the first one
+func (c *HTTPClient) pick(id ID, replicas []ID) ID {
+	for _, r := range replicas {
+		if r == id {
+			return id
+		}
+	}
+	for _, r := range replicas {
+		if c.ID != "" && r.Zone() == c.ID.Zone() {
+			return r
+		}
+	}
+	return replicas[0]
+}
+
+// GetURL returns url of key on node id, or on a replica of its shard if node id does not own key
 func (c *HTTPClient) GetURL(id ID, key Key) string {
+	if key != "" {
+		id = c.route(id, key)
+	}
 	if id == "" {
 		for id = range c.HTTP {
 			if c.ID == "" || id.Zone() == c.ID.Zone() {
@@ -111,12 +203,20 @@ func (c *HTTPClient) GetURL(id ID, key Key) string {
 			}
 		}
 	}
-	return c.HTTP[id] + "/" + strconv.Itoa(int(key))
+	return c.HTTP[id] + c.path("/") + url.PathEscape(string(key))
+}
+
+// path returns http path p in the namespace of client
+func (c *HTTPClient) path(p string) string {
+	if c.Namespace == "" {
+		return p
+	}
+	return "/ns/" + url.PathEscape(c.Namespace) + p
 }
 
-// rest accesses server's REST API with url = http://ip:port/key
+// rest accesses server's REST API with url = http://ip:port/key as command cid of client
 // if value == nil, it's a read
-func (c *HTTPClient) rest(id ID, key Key, value Value,count int) (Value, map[string]string, error) {
+func (c *HTTPClient) rest(id ID, key Key, value Value, client ID, cid int) (Value, map[string]string, error) {
 
 	url := c.GetURL(id, key)
 	log.Debugf("In REST %v", id)
@@ -137,13 +237,28 @@ func (c *HTTPClient) rest(id ID, key Key, value Value,count int) (Value, map[str
 		log.Error(err)
 		return nil, nil, err
 	}
+
+	v, metadata, err := c.do(req, client, cid)
+	if err != nil {
+		return nil, metadata, err
+	}
+	if value == nil{
+		log.Debugf("node=%v type=%s key=%v value=%x", id, method, key, v)
+	} else {
+		log.Debugf("node=%v type=%s key=%v value=%x", id, method, key, value)
+	}
+	return v, metadata, nil
+}
+
+// do sends request to server as command cid of client and returns the replied value and headers
+func (c *HTTPClient) do(req *http.Request, client ID, cid int) (Value, map[string]string, error) {
 	//log.Debugf("HTTPClientID=%v",HTTPClientID)
 	//log.Debugf("HTTPCommandID=%v",HTTPCommandID)
 	//log.Debugf("c.ID=%v",c.ID)
 	//log.Debugf("CID=%v",count)
 
-	req.Header.Set(HTTPClientID, string(id))
-	req.Header.Set(HTTPCommandID, strconv.Itoa(c.CID))
+	req.Header.Set(HTTPClientID, string(client))
+	req.Header.Set(HTTPCommandID, strconv.Itoa(cid))
 	// r.Header.Set(HTTPTimestamp, strconv.FormatInt(time.Now().UnixNano(), 10))
 
 	rep, err := c.Client.Do(req)
@@ -164,37 +279,162 @@ func (c *HTTPClient) rest(id ID, key Key, value Value,count int) (Value, map[str
 	//log.Debugf("metadata=%v",metadata)
 	//log.Debugf("rep.StatusCode=%v",rep.StatusCode)
 
-	if rep.StatusCode == http.StatusOK {
+	if rep.StatusCode == http.StatusOK || rep.StatusCode == http.StatusPreconditionFailed {
 		b, err := ioutil.ReadAll(rep.Body)
 		if err != nil {
 			log.Error(err)
 			return nil, metadata, err
 		}
-
-		if value == nil{
-			log.Debugf("node=%v type=%s key=%v value=%x", id, method, key, Value(b))
-		} else {
-			log.Debugf("node=%v type=%s key=%v value=%x", id, method, key, value)
+		if rep.StatusCode == http.StatusPreconditionFailed {
+			return Value(b), metadata, ErrMismatch
 		}
 		return Value(b), metadata, nil
 	}
+	if rep.StatusCode == http.StatusConflict {
+		return nil, metadata, ErrAborted
+	}
+	if rep.StatusCode == http.StatusLocked {
+		return nil, metadata, ErrLocked
+	}
 
 	// http call failed
 	dump, _ := httputil.DumpResponse(rep, true)
 	log.Debugf("%q", dump)
 	return nil, metadata, errors.New(rep.Status)
 }
-// RESTPut puts new value as http.request body and return previous value
+
+// Delete removes key and returns its previous value
+func (c *HTTPClient) Delete(key Key) (Value, error) {
+	req, err := http.NewRequest(http.MethodDelete, c.GetURL(c.ID, key), nil)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return c.send(req)
+}
+
+// CAS puts value to key if its current value is expect, or if key is missing when expect is nil,
+// otherwise returns the current value and ErrMismatch. The server pads expect like put values,
+// so expect is the value as put by client.
+func (c *HTTPClient) CAS(key Key, expect, value Value) (Value, error) {
+	req, err := http.NewRequest(http.MethodPut, c.GetURL(c.ID, key), bytes.NewBuffer(value))
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if expect == nil {
+		req.Header.Set(HTTPIfNoneMatch, "*")
+	} else {
+		req.Header.Set(HTTPIfMatch, string(expect))
+	}
+	return c.send(req)
+}
+
+// Increment adds delta to the decimal integer of key and returns its previous value,
+// or the current value and ErrNotInteger if it is not an integer
+func (c *HTTPClient) Increment(key Key, delta int64) (Value, error) {
+	body := bytes.NewBufferString(strconv.FormatInt(delta, 10))
+	req, err := http.NewRequest(http.MethodPatch, c.GetURL(c.ID, key), body)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	v, err := c.send(req)
+	if err == ErrMismatch {
+		err = ErrNotInteger
+	}
+	return v, err
+}
+
+// send sends request as the next command of a client session
+func (c *HTTPClient) send(req *http.Request) (Value, error) {
+	s := c.session()
+	defer c.release(s)
+	s.cid++
+	v, _, err := c.do(req, s.id, s.cid)
+	return v, err
+}
+
+// Txn executes commands atomically as one command through consensus and replies the commands
+// with their values, which are previous values for writes. A transaction over shards commits
+// with two-phase commit and returns ErrAborted if it does not.
+func (c *HTTPClient) Txn(commands []Command) (TransactionReply, error) {
+	tr := TransactionReply{}
+	id := c.ID
+	if len(commands) > 0 {
+		// replicas of the first key coordinate a transaction over shards
+		id = c.route(id, commands[0].Key)
+	}
+	b, err := json.Marshal(Transaction{Commands: commands})
+	if err != nil {
+		return tr, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.GetURL(id, "") + "txn", bytes.NewBuffer(b))
+	if err != nil {
+		log.Error(err)
+		return tr, err
+	}
+	v, err := c.send(req)
+	if err != nil {
+		return tr, err
+	}
+	err = json.Unmarshal(v, &tr)
+	return tr, err
+}
+
+// Scan reads at most limit key-value pairs in range [from, to) in key order through consensus,
+// to and limit are unbounded if empty and zero. Sharded keys are scanned on every shard and merged.
+func (c *HTTPClient) Scan(from, to Key, limit int) ([]KV, error) {
+	if c.Router == nil {
+		return c.scan(c.ID, from, to, limit)
+	}
+	kvs := make([]KV, 0)
+	for _, shard := range c.Router.Shards() {
+		s, err := c.scan(c.pick(c.ID, c.Router.shards[shard]), from, to, limit)
+		if err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, s...)
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	if limit > 0 && len(kvs) > limit {
+		kvs = kvs[:limit]
+	}
+	return kvs, nil
+}
+
+// scan reads key range of the shard node id replicates
+func (c *HTTPClient) scan(id ID, from, to Key, limit int) ([]KV, error) {
+	q := url.Values{}
+	q.Set("from", string(from))
+	q.Set("to", string(to))
+	q.Set("limit", strconv.Itoa(limit))
+	req, err := http.NewRequest(http.MethodGet, c.GetURL(id, "") + "scan?" + q.Encode(), nil)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	v, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	kvs := make([]KV, 0)
+	err = json.Unmarshal(v, &kvs)
+	return kvs, err
+}
+
+// RESTPut puts new value as http.request body and return previous value,
+// the request is command CID of the client, which bypasses client sessions if CID is zero
 func (c *HTTPClient) RESTPut(id ID, key Key, value Value) (Value, map[string]string, error) {
-	return c.rest(id, key, value,c.CID)
+	return c.rest(id, key, value, c.Session, c.CID)
 }
 
 func (c *HTTPClient) json(id ID, key Key, value Value) (Value, error) {
-	url := c.HTTP[id]
+	url := c.HTTP[id] + c.path("/")
 	cmd := Command{
 		Key:       key,
 		Value:     value,
-		ClientID:  c.ID,
+		ClientID:  c.Session,
 		CommandID: c.CID,
 	}
 	data, err := json.Marshal(cmd)
@@ -233,10 +473,13 @@ func (c *HTTPClient) QuorumGet(key Key) ([]Value, []map[string]string) {
 func (c *HTTPClient) MultiGet(n int, key Key) ([]Value, []map[string]string) {
 	valueC := make(chan Value)
 	metaC := make(chan map[string]string)
+	s := c.session()
+	defer c.release(s)
+	s.cid++
 	i := 0
 	for id := range c.HTTP {
 		go func(id ID) {
-			v, meta, err := c.rest(id, key, nil,c.CID)
+			v, meta, err := c.rest(id, key, nil, s.id, s.cid)
 			if err != nil {
 				log.Error(err)
 				return
@@ -262,6 +505,9 @@ func (c *HTTPClient) MultiGet(n int, key Key) ([]Value, []map[string]string) {
 func (c *HTTPClient) LocalQuorumGet(key Key) ([]Value, []map[string]string) {
 	valueC := make(chan Value)
 	metaC := make(chan map[string]string)
+	s := c.session()
+	defer c.release(s)
+	s.cid++
 	i := 0
 	for id := range c.HTTP {
 		if c.ID.Zone() != id.Zone() {
@@ -272,7 +518,7 @@ func (c *HTTPClient) LocalQuorumGet(key Key) ([]Value, []map[string]string) {
 			break
 		}
 		go func(id ID) {
-			v, meta, err := c.rest(id, key, nil,c.CID)
+			v, meta, err := c.rest(id, key, nil, s.id, s.cid)
 			if err != nil {
 				log.Error(err)
 				return
@@ -295,6 +541,9 @@ func (c *HTTPClient) LocalQuorumGet(key Key) ([]Value, []map[string]string) {
 // TODO get headers
 func (c *HTTPClient) QuorumPut(key Key, value Value) {
 	var wait sync.WaitGroup
+	s := c.session()
+	defer c.release(s)
+	s.cid++
 	i := 0
 	for id := range c.HTTP {
 		i++
@@ -303,7 +552,7 @@ func (c *HTTPClient) QuorumPut(key Key, value Value) {
 		}
 		wait.Add(1)
 		go func(id ID) {
-			c.rest(id, key, value,c.CID)
+			c.rest(id, key, value, s.id, s.cid)
 			wait.Done()
 		}(id)
 	}
@@ -313,9 +562,9 @@ func (c *HTTPClient) QuorumPut(key Key, value Value) {
 // Consensus collects /history/key from every node and compare their values
 func (c *HTTPClient) Consensus(k Key) bool {
 	h := make(map[ID][]Value)
-	for id, url := range c.HTTP {
+	for id, addr := range c.HTTP {
 		h[id] = make([]Value, 0)
-		r, err := c.Client.Get(url + "/history?key=" + strconv.Itoa(int(k)))
+		r, err := c.Client.Get(addr + "/history?key=" + url.QueryEscape(string(k)))
 		if err != nil {
 			log.Error(err)
 			continue
@@ -366,6 +615,42 @@ func (c *HTTPClient) Crash(id ID, t int) {
 	r.Body.Close()
 }
 
+// Load returns number of messages sent and received by node id
+func (c *HTTPClient) Load(id ID) (Load, error) {
+	var load Load
+	r, err := c.Client.Get(c.HTTP[id] + "/load")
+	if err != nil {
+		return load, err
+	}
+	defer r.Body.Close()
+	err = json.NewDecoder(r.Body).Decode(&load)
+	return load, err
+}
+
+// Traffic returns messages and bytes node id sent to every peer and received by message type
+func (c *HTTPClient) Traffic(id ID) (Traffic, error) {
+	var traffic Traffic
+	r, err := c.Client.Get(c.HTTP[id] + "/traffic")
+	if err != nil {
+		return traffic, err
+	}
+	defer r.Body.Close()
+	err = json.NewDecoder(r.Body).Decode(&traffic)
+	return traffic, err
+}
+
+// Peers returns state of the link from node id to every peer it sent to
+func (c *HTTPClient) Peers(id ID) (map[ID]PeerState, error) {
+	peers := make(map[ID]PeerState)
+	r, err := c.Client.Get(c.HTTP[id] + "/peers")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	err = json.NewDecoder(r.Body).Decode(&peers)
+	return peers, err
+}
+
 // Drop drops every message send for t seconds
 func (c *HTTPClient) Drop(from, to ID, t int) {
 	url := c.HTTP[from] + "/drop?id=" + string(to) + "&t=" + strconv.Itoa(t)
@@ -3,7 +3,10 @@ package main
 import (
 	//"encoding/binary"
 	"flag"
+	"strconv"
+	"github.com/salemmohammed/PaxiBFT/bullshark"
 	"github.com/salemmohammed/PaxiBFT/log"
+	"github.com/salemmohammed/PaxiBFT/mirbft"
 	"github.com/salemmohammed/PaxiBFT/paxos"
 
 	"github.com/salemmohammed/PaxiBFT"
@@ -30,7 +33,7 @@ func (d *db) Stop() error {
 
 
 func (d *db) Write(k int, v []byte) error {
-	key := PaxiBFT.Key(k)
+	key := PaxiBFT.Key(strconv.Itoa(k))
 	//value := make([]byte, binary.MaxVarintLen64)
 	//value := make([]byte, 10000)
 	//binary.ByteOrder(v)
@@ -40,6 +43,16 @@ func (d *db) Write(k int, v []byte) error {
 	return err
 }
 
+// Txn runs keys and values as one transaction, nil values are reads
+func (d *db) Txn(keys []int, values [][]byte) error {
+	cmds := make([]PaxiBFT.Command, len(keys))
+	for i := range keys {
+		cmds[i] = PaxiBFT.Command{Key: PaxiBFT.Key(strconv.Itoa(keys[i])), Value: values[i]}
+	}
+	_, err := d.Client.Txn(cmds)
+	return err
+}
+
 func main() {
 	PaxiBFT.Init()
 
@@ -59,6 +72,10 @@ func main() {
 		d.Client = PaxiBFT.NewHTTPClient(PaxiBFT.ID(*id))
 	case "HotStuff_SL":
 		d.Client = PaxiBFT.NewHTTPClient(PaxiBFT.ID(*id))
+	case "hotstuff2":
+		d.Client = PaxiBFT.NewHTTPClient(PaxiBFT.ID(*id))
+	case "mirbft":
+		d.Client = mirbft.NewClient(PaxiBFT.ID(*id))
 	case "hotstuffBFT":
 		d.Client = PaxiBFT.NewHTTPClient(PaxiBFT.ID(*id))
 	case "pbft":
@@ -71,6 +88,8 @@ func main() {
 		d.Client = PaxiBFT.NewHTTPClient(PaxiBFT.ID(*id))
 	case "paxos":
 		d.Client = paxos.NewClient(PaxiBFT.ID(*id))
+	case "bullshark":
+		d.Client = bullshark.NewClient(PaxiBFT.ID(*id))
 	default:
 		d.Client = PaxiBFT.NewHTTPClient(PaxiBFT.ID(*id))
 	}
@@ -43,8 +43,7 @@ func run(cmd string, args []string) {
 			fmt.Println("consensus KEY")
 			return
 		}
-		k, _ := strconv.Atoi(args[0])
-		v := admin.Consensus(PaxiBFT.Key(k))
+		v := admin.Consensus(PaxiBFT.Key(args[0]))
 		fmt.Println(v)
 
 	case "crash":
@@ -3,7 +3,10 @@ package PaxiBFT
 import (
 	"encoding/gob"
 	"encoding/json"
+	"fmt"
 	"io"
+	"reflect"
+	"sync"
 
 	"github.com/salemmohammed/PaxiBFT/log"
 )
@@ -12,11 +15,11 @@ import (
 // combines json and gob encoder decoder interface
 type Codec interface {
 	Scheme() string
-	Encode(interface{})
-	Decode(interface{})
+	Encode(interface{}) error
+	Decode(interface{}) error
 }
 
-// NewCodec creates new codec object based on scheme, i.e. json and gob
+// NewCodec creates new codec object based on scheme, i.e. json, gob and binary, gob if empty
 func NewCodec(scheme string, rw io.ReadWriter) Codec {
 	switch scheme {
 	case "json":
@@ -24,12 +27,172 @@ func NewCodec(scheme string, rw io.ReadWriter) Codec {
 			encoder: json.NewEncoder(rw),
 			decoder: json.NewDecoder(rw),
 		}
-	case "gob":
+	case "gob", "":
 		return &codecGOB{
 			encoder: gob.NewEncoder(rw),
 			decoder: gob.NewDecoder(rw),
 		}
+	case "binary":
+		return &codecBinary{rw: rw}
 	}
+	log.Fatalf("unknown codec %s", scheme)
+	return nil
+}
+
+// messages are the message types codecs decode, by name in json and by id in binary codec
+var messages = struct {
+	sync.RWMutex
+	names map[string]reflect.Type
+	ids   map[uint16]reflect.Type
+	types map[reflect.Type]uint16
+}{
+	names: make(map[string]reflect.Type),
+	ids:   make(map[uint16]reflect.Type),
+	types: make(map[reflect.Type]uint16),
+}
+
+// RegisterMessage makes type of message m known to codecs by its name,
+// node registers the type of every message it has a handle function for
+func RegisterMessage(m interface{}) {
+	t := reflect.TypeOf(m)
+	messages.Lock()
+	messages.names[t.String()] = t
+	messages.Unlock()
+}
+
+// BinaryMessage is a message that writes itself in the binary codec,
+// and whose pointer reads it back in the same order
+type BinaryMessage interface {
+	WriteBinary(w *BinaryWriter)
+}
+
+type binaryReader interface {
+	ReadBinary(r *BinaryReader)
+}
+
+// RegisterBinary gives type of message m an id in the binary codec. Every node has to register
+// the same ids, ids below 16 are taken by this package. Messages of other types are encoded with gob.
+func RegisterBinary(id uint16, m BinaryMessage) {
+	t := reflect.TypeOf(m)
+	if _, ok := reflect.New(t).Interface().(binaryReader); !ok {
+		panic(fmt.Sprintf("message type %v has no ReadBinary method", t))
+	}
+	messages.Lock()
+	defer messages.Unlock()
+	if r, exists := messages.ids[id]; exists && r != t || id == 0 {
+		panic(fmt.Sprintf("binary codec id %d of %v is taken by %v", id, t, r))
+	}
+	messages.names[t.String()] = t
+	messages.ids[id] = t
+	messages.types[t] = id
+}
+
+// message returns the message an interface pointer points to
+func message(m interface{}) interface{} {
+	if p, ok := m.(*interface{}); ok {
+		return *p
+	}
+	return m
+}
+
+// decoded stores message v into m, a pointer to interface or to the type of v
+func decoded(m interface{}, v interface{}) error {
+	p := reflect.ValueOf(m)
+	if p.Kind() != reflect.Ptr || p.IsNil() || !reflect.TypeOf(v).AssignableTo(p.Elem().Type()) {
+		return fmt.Errorf("cannot decode %T into %T", v, m)
+	}
+	p.Elem().Set(reflect.ValueOf(v))
+	return nil
+}
+
+// typed is a json message together with the name of its type
+type typed struct {
+	Type    string          `json:"type"`
+	Message json.RawMessage `json:"message"`
+}
+
+// marshalJSON encodes message with its type name
+func marshalJSON(m interface{}) ([]byte, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(typed{Type: reflect.TypeOf(m).String(), Message: b})
+}
+
+// unmarshalJSON decodes message of a registered type, or into generic values otherwise
+func unmarshalJSON(b []byte) (interface{}, error) {
+	var t typed
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, err
+	}
+	messages.RLock()
+	r, exists := messages.names[t.Type]
+	messages.RUnlock()
+	if !exists {
+		var m interface{}
+		err := json.Unmarshal(t.Message, &m)
+		return m, err
+	}
+	v := reflect.New(r)
+	if err := json.Unmarshal(t.Message, v.Interface()); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
+
+// MarshalJSON encodes message of envelope with its type name, so that it decodes to the same type
+func (e Envelope) MarshalJSON() ([]byte, error) {
+	m, err := marshalJSON(e.Message)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Namespace string
+		From      ID
+		Message   json.RawMessage
+	}{e.Namespace, e.From, m})
+}
+
+func (e *Envelope) UnmarshalJSON(b []byte) error {
+	var v struct {
+		Namespace string
+		From      ID
+		Message   json.RawMessage
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	m, err := unmarshalJSON(v.Message)
+	e.Namespace = v.Namespace
+	e.From = v.From
+	e.Message = m
+	return err
+}
+
+// MarshalJSON encodes error of reply by its message
+func (r Reply) MarshalJSON() ([]byte, error) {
+	type reply Reply
+	v := struct {
+		reply
+		Err string
+	}{reply: reply(r)}
+	if r.Err != nil {
+		v.Err = r.Err.Error()
+	}
+	return json.Marshal(v)
+}
+
+func (r *Reply) UnmarshalJSON(b []byte) error {
+	type reply Reply
+	v := struct {
+		*reply
+		Err string
+	}{reply: (*reply)(r)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	r.Err = replyError(v.Err)
 	return nil
 }
 
@@ -42,18 +205,24 @@ func (j *codecJSON) Scheme() string {
 	return "json"
 }
 
-func (j *codecJSON) Encode(m interface{}) {
-	err := j.encoder.Encode(m)
+func (j *codecJSON) Encode(m interface{}) error {
+	b, err := marshalJSON(message(m))
 	if err != nil {
-		log.Error(err)
+		return err
 	}
+	return j.encoder.Encode(json.RawMessage(b))
 }
 
-func (j *codecJSON) Decode(m interface{}) {
-	err := j.decoder.Decode(m)
+func (j *codecJSON) Decode(m interface{}) error {
+	var b json.RawMessage
+	if err := j.decoder.Decode(&b); err != nil {
+		return err
+	}
+	v, err := unmarshalJSON(b)
 	if err != nil {
-		log.Error(err)
+		return err
 	}
+	return decoded(m, v)
 }
 
 type codecGOB struct {
@@ -65,16 +234,10 @@ func (g *codecGOB) Scheme() string {
 	return "gob"
 }
 
-func (g *codecGOB) Encode(m interface{}) {
-	err := g.encoder.Encode(m)
-	if err != nil {
-		log.Error(err)
-	}
+func (g *codecGOB) Encode(m interface{}) error {
+	return g.encoder.Encode(m)
 }
 
-func (g *codecGOB) Decode(m interface{}) {
-	err := g.decoder.Decode(m)
-	if err != nil {
-		log.Error(err)
-	}
+func (g *codecGOB) Decode(m interface{}) error {
+	return g.decoder.Decode(m)
 }
@@ -0,0 +1,171 @@
+package PaxiBFT
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CompressionConfig is which messages transports compress on the wire
+type CompressionConfig struct {
+	Level     int `json:"level"`     // flate level from 1 (fastest) to 9 (smallest), 0 disables compression
+	Threshold int `json:"threshold"` // encoded messages shorter than so many bytes are sent as they are
+}
+
+// every frame on the wire starts with a flag and the length of its payload, receivers decompress
+// the frames flagged compressed whatever their own configuration is
+const (
+	framePlain      = 0
+	frameCompressed = 1
+	frameHeader     = 5
+	frameMaxLength  = 1 << 28
+)
+
+// ErrFrameTooLarge is returned reading a frame longer than any message, taken as a corrupted stream
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// compressors are flate writers reused by level, since every one allocates large tables
+var compressors [flate.BestCompression + 1]sync.Pool
+
+// wireStats counts bytes of messages through a transport before and after compression
+type wireStats struct {
+	bytes int64 // encoded messages with frame headers
+	wire  int64 // frames on the wire
+	nanos int64 // time spent compressing and decompressing
+
+	sent     typeCounter // bytes on the wire of messages sent by type
+	received peerCounter // bytes on the wire of messages received by sender and type
+}
+
+func (s *wireStats) add(bytes, wire int, d time.Duration) {
+	atomic.AddInt64(&s.bytes, int64(bytes))
+	atomic.AddInt64(&s.wire, int64(wire))
+	atomic.AddInt64(&s.nanos, int64(d))
+}
+
+// frameBuffers are where the codec of a wire encodes to and decodes from
+type frameBuffers struct {
+	in, out bytes.Buffer
+}
+
+func (b *frameBuffers) Read(p []byte) (int, error)  { return b.in.Read(p) }
+func (b *frameBuffers) Write(p []byte) (int, error) { return b.out.Write(p) }
+
+// wire frames every message encoded by a codec and compresses the large ones
+type wire struct {
+	Codec
+	buffers   *frameBuffers
+	rw        io.ReadWriter
+	c         CompressionConfig
+	stats     *wireStats
+	compacted bytes.Buffer
+}
+
+// newWire creates the codec of scheme transports read and write frames with through rw
+func newWire(scheme string, c CompressionConfig, rw io.ReadWriter, stats *wireStats) Codec {
+	if c.Level > flate.BestCompression {
+		c.Level = flate.BestCompression
+	}
+	b := new(frameBuffers)
+	return &wire{
+		Codec:   NewCodec(scheme, b),
+		buffers: b,
+		rw:      rw,
+		c:       c,
+		stats:   stats,
+	}
+}
+
+func (w *wire) Encode(m interface{}) error {
+	w.buffers.out.Reset()
+	if err := w.Codec.Encode(m); err != nil {
+		return err
+	}
+	p := w.buffers.out.Bytes()
+	n := len(p)
+	flag := byte(framePlain)
+	var d time.Duration
+	if w.c.Level > 0 && n >= w.c.Threshold {
+		start := time.Now()
+		if b, err := w.compress(p); err == nil && len(b) < n {
+			p, flag = b, frameCompressed
+		}
+		d = time.Since(start)
+	}
+
+	// one write of header and payload, so that a frame is never split by another
+	f := make([]byte, frameHeader+len(p))
+	f[0] = flag
+	binary.BigEndian.PutUint32(f[1:], uint32(len(p)))
+	copy(f[frameHeader:], p)
+	_, err := w.rw.Write(f)
+	w.stats.add(frameHeader+n, len(f), d)
+	w.stats.sent.add(message(m), int64(len(f)))
+	return err
+}
+
+// compress returns p compressed at the level of wire
+func (w *wire) compress(p []byte) ([]byte, error) {
+	w.compacted.Reset()
+	z, _ := compressors[w.c.Level].Get().(*flate.Writer)
+	if z == nil {
+		var err error
+		if z, err = flate.NewWriter(&w.compacted, w.c.Level); err != nil {
+			return nil, err
+		}
+	} else {
+		z.Reset(&w.compacted)
+	}
+	defer compressors[w.c.Level].Put(z)
+	if _, err := z.Write(p); err != nil {
+		return nil, err
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return w.compacted.Bytes(), nil
+}
+
+func (w *wire) Decode(m interface{}) error {
+	var h [frameHeader]byte
+	if _, err := io.ReadFull(w.rw, h[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(h[1:])
+	if n > frameMaxLength {
+		return ErrFrameTooLarge
+	}
+	p := make([]byte, n)
+	if _, err := io.ReadFull(w.rw, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	size := len(p)
+	var d time.Duration
+	if h[0] == frameCompressed {
+		start := time.Now()
+		z := flate.NewReader(bytes.NewReader(p))
+		var err error
+		p, err = io.ReadAll(io.LimitReader(z, frameMaxLength))
+		z.Close()
+		if err != nil {
+			return err
+		}
+		d = time.Since(start)
+	}
+	w.stats.add(frameHeader+len(p), frameHeader+size, d)
+	w.buffers.in.Write(p)
+	if err := w.Codec.Decode(m); err != nil {
+		return err
+	}
+	w.stats.received.add(sender(message(m)), message(m), int64(frameHeader+size))
+	return nil
+}
@@ -0,0 +1,72 @@
+package PaxiBFT
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// paddedRequest is a request with a value padded with zeros to 10 KB like the http api does
+func paddedRequest() Request {
+	return Request{
+		Command:    Command{Key: "1", Value: expand([]byte("abcdefghij")), ClientID: "1.1/1", CommandID: 1},
+		Properties: map[string]string{},
+		Timestamp:  1,
+		NodeID:     "1.1",
+	}
+}
+
+func TestCompression(t *testing.T) {
+	small := Request{Command: Command{Key: "1", Value: Value("v")}, Properties: map[string]string{}}
+	for _, scheme := range []string{"gob", "binary"} {
+		buf := new(bytes.Buffer)
+		var sent, received wireStats
+		w := newWire(scheme, CompressionConfig{Level: 1, Threshold: 1024}, buf, &sent)
+		// compression is flagged in every frame, so receivers decompress without being configured to
+		r := newWire(scheme, CompressionConfig{}, buf, &received)
+
+		for _, c := range []struct {
+			m          interface{}
+			compressed bool
+		}{{paddedRequest(), true}, {small, false}, {paddedRequest(), true}} {
+			m := c.m
+			if err := w.Encode(&m); err != nil {
+				t.Fatal(err)
+			}
+			if compressed := buf.Bytes()[0] == frameCompressed; compressed != c.compressed {
+				t.Errorf("%s frame of %v compressed %v", scheme, m, compressed)
+			}
+			var recv interface{}
+			if err := r.Decode(&recv); err != nil || !reflect.DeepEqual(m, recv) {
+				t.Fatalf("%s sent %v and received %v, %v", scheme, m, recv, err)
+			}
+		}
+		if sent.wire*10 > sent.bytes || sent.nanos == 0 {
+			t.Errorf("%s sent %d bytes on the wire for %d", scheme, sent.wire, sent.bytes)
+		}
+		if received.bytes != sent.bytes || received.wire != sent.wire {
+			t.Errorf("%s received %+v of sent %+v", scheme, &received, &sent)
+		}
+	}
+}
+
+// BenchmarkCompression compares bytes on the wire and time of framing a padded request
+// through gob and flate levels
+func BenchmarkCompression(b *testing.B) {
+	for _, level := range []int{0, 1, 6, 9} {
+		b.Run("level"+strconv.Itoa(level), func(b *testing.B) {
+			buf := new(bytes.Buffer)
+			var stats wireStats
+			w := newWire("gob", CompressionConfig{Level: level, Threshold: 1024}, buf, &stats)
+			var send interface{} = paddedRequest()
+			var recv interface{}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w.Encode(&send)
+				w.Decode(&recv)
+			}
+			b.ReportMetric(float64(stats.wire)/float64(stats.bytes), "wire/bytes")
+		})
+	}
+}
@@ -3,7 +3,10 @@ package PaxiBFT
 import (
 	"encoding/json"
 	"flag"
+	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/salemmohammed/PaxiBFT/log"
 )
@@ -18,16 +21,36 @@ type Config struct {
 	Policy    string  `json:"policy"`    // leader change policy {consecutive, majority}
 	Threshold float64 `json:"threshold"` // threshold for policy in WPaxos {n consecutive or time interval in ms}
 
+	Quorum QuorumConfig `json:"quorum"` // phase-1 and phase-2 quorum systems of flexible paxos
+
+	Lease    int `json:"lease"`     // leader lease in ms for local reads, 0 disables leases
+	MaxDrift int `json:"max_drift"` // maximum clock drift between nodes in ms during one lease
+
+	Fanout    int  `json:"fanout"`     // fanout of dissemination tree in BFT protocols, 0 for star
+	HashFirst bool `json:"hash_first"` // BFT protocols order digests and fetch payloads on demand
+
+	Storage StorageConfig `json:"storage"` // storage engine of key-value database
+	WAL     StorageConfig `json:"wal"`     // write-ahead log of protocol state, disabled with memory engine
+
+	Checkpoint int `json:"checkpoint"` // database checkpoint every so many executed slots, 0 disables checkpoints
+
+	Codec       string            `json:"codec"`       // codec for message serialization between nodes {gob, json, binary}
+	Compression CompressionConfig `json:"compression"` // compressing large messages on the wire
+	Reconnect   ReconnectConfig   `json:"reconnect"`   // redialing broken tcp connections to peers
+	UDP         UDPConfig         `json:"udp"`         // reassembling fragmented messages of udp transport
+
+	Shards map[string][]ID `json:"shards"` // replica groups by shard name owning keys by consistent hashing, one group of all nodes if empty
+
 	Thrifty        bool    `json:"thrifty"`          // only send messages to a quorum
 	BufferSize     int     `json:"buffer_size"`      // buffer size for maps
 	ChanBufferSize int     `json:"chan_buffer_size"` // buffer size for channels
 	MultiVersion   bool    `json:"multiversion"`     // create multi-version database
+	StateMachine   string  `json:"state_machine"`    // replicated state machine {kv, counter} or registered by application
 	Benchmark      Bconfig `json:"benchmark"`        // benchmark configuration
 
 	// for future implementation
 	// Batching bool `json:"batching"`
 	// Consistency string `json:"consistency"`
-	// Codec string `json:"codec"` // codec for message serialization between nodes
 
 	n   int         // total number of nodes
 	z   int         // total number of zones
@@ -46,20 +69,67 @@ func GetConfig() Config {
 	return config
 }
 
+// SetConfig replaces paxi package configuration, used to run simulated clusters in tests
+func SetConfig(c Config) {
+	c.count()
+	config = c
+}
+
 // Simulation enable go channel transportation to simulate distributed environment
 func Simulation() {
 	*scheme = "chan"
 }
 
+// Cluster simulates zones * nodes replicas with configuration c on consecutive ports after port,
+// start is called with every id once addresses are configured and runs its replica in background;
+// Cluster returns when every http server has read its address, so the next cluster may change configuration
+func Cluster(c Config, port, zones, nodes int, start func(ID)) error {
+	Simulation()
+	c.Addrs = make(map[ID]string)
+	c.HTTPAddrs = make(map[ID]string)
+	for z := 1; z <= zones; z++ {
+		for n := 1; n <= nodes; n++ {
+			id := NewID(z, n)
+			p := strconv.Itoa(port + (z-1)*nodes + n)
+			c.Addrs[id] = "chan://127.0.0.1:" + p
+			c.HTTPAddrs[id] = "http://127.0.0.1:" + p
+		}
+	}
+	SetConfig(c)
+
+	for id := range c.Addrs {
+		start(id)
+		err := Retry(func() error {
+			r, err := http.Get(c.HTTPAddrs[id] + "/history?key=0")
+			if err == nil {
+				r.Body.Close()
+			}
+			return err
+		}, 50, 10*time.Millisecond)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // MakeDefaultConfig returns Config object with few default values
 // only used by init() and master
 func MakeDefaultConfig() Config {
 	return Config{
 		Policy:         "consecutive",
 		Threshold:      3,
+		Quorum:         QuorumConfig{Type: "majority"},
 		BufferSize:     1024,
 		ChanBufferSize: 1024,
 		MultiVersion:   false,
+		StateMachine:   "kv",
+		Storage:        StorageConfig{Engine: "memory", Sync: "none", Interval: 10},
+		WAL:            StorageConfig{Engine: "memory", Sync: "always"},
+		Codec:          "gob",
+		Compression:    CompressionConfig{Level: 0, Threshold: 1024},
+		Reconnect:      ReconnectConfig{Backoff: 50, MaxBackoff: 5000, Policy: "buffer"},
+		UDP:            UDPConfig{Timeout: 1000},
 		Benchmark:      DefaultBConfig(),
 	}
 }
@@ -104,9 +174,19 @@ func (c *Config) Load() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	c.count()
+}
 
+// count computes number of nodes and zones from addresses
+func (c *Config) count() {
+	c.n = 0
 	c.npz = make(map[int]int)
-	for id := range c.Addrs {
+	ids := c.IDs()
+	if r := NewRouter(c.Shards); r != nil {
+		// quorums are formed within a group, and every group has the same layout
+		ids = c.Shards[r.Shards()[0]]
+	}
+	for _, id := range ids {
 		c.n++
 		c.npz[id.Zone()]++
 	}
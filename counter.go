@@ -0,0 +1,85 @@
+package PaxiBFT
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/salemmohammed/PaxiBFT/log"
+)
+
+// counter is a sample StateMachine of named counters,
+// a command adds the decimal delta in its value to counter of its key,
+// and a command without value reads the counter
+type counter struct {
+	sync.RWMutex
+	counts map[Key]int64
+}
+
+// NewCounter returns a counter state machine
+func NewCounter() StateMachine {
+	return &counter{
+		counts: make(map[Key]int64),
+	}
+}
+
+// Execute implements StateMachine interface, returns previous count in decimal
+func (c *counter) Execute(cmd Command) Value {
+	c.Lock()
+	defer c.Unlock()
+	v := c.counts[cmd.Key]
+	if cmd.Value != nil {
+		// http requests pad value with zeros
+		delta, err := strconv.ParseInt(string(bytes.TrimRight(cmd.Value, "\x00")), 10, 64)
+		if err != nil {
+			log.Errorf("counter cannot add %v: %v", cmd, err)
+			return Value(strconv.FormatInt(v, 10))
+		}
+		c.counts[cmd.Key] = v + delta
+	}
+	return Value(strconv.FormatInt(v, 10))
+}
+
+// Snapshot implements StateMachine interface
+func (c *counter) Snapshot() ([]byte, error) {
+	c.RLock()
+	defer c.RUnlock()
+	return json.Marshal(c.counts)
+}
+
+// Restore implements StateMachine interface
+func (c *counter) Restore(b []byte) error {
+	counts := make(map[Key]int64)
+	if err := json.Unmarshal(b, &counts); err != nil {
+		return err
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.counts = counts
+	return nil
+}
+
+// Hash implements State interface over counters in key order
+func (c *counter) Hash() uint64 {
+	c.RLock()
+	defer c.RUnlock()
+	keys := make([]string, 0, len(c.counts))
+	for k := range c.counts {
+		keys = append(keys, string(k))
+	}
+	sort.Strings(keys)
+	h := fnv.New64a()
+	b := make([]byte, 8)
+	for _, k := range keys {
+		binary.BigEndian.PutUint64(b, uint64(len(k)))
+		h.Write(b)
+		h.Write([]byte(k))
+		binary.BigEndian.PutUint64(b, uint64(c.counts[Key(k)]))
+		h.Write(b)
+	}
+	return h.Sum64()
+}
@@ -3,67 +3,280 @@ package PaxiBFT
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"sync"
+
+	"github.com/salemmohammed/PaxiBFT/log"
 )
 
-// Key type of the key-value database
-// TODO key should be general too
-type Key int
+// Key type of the key-value database, ordered byte-wise
+type Key string
 
 // Value type of key-value database
 type Value []byte
 
+// Op is the operation of a command
+type Op uint8
+
+const (
+	// OpGetPut gets the key if command value is nil, otherwise puts the value
+	OpGetPut Op = iota
+	// OpScan reads keys in range [Key, To) in order, To is unbounded if empty
+	OpScan
+	// OpTxn executes Commands atomically as one command
+	OpTxn
+	// OpDelete removes the key
+	OpDelete
+	// OpCAS puts the value only if the current value equals Expect
+	OpCAS
+	// OpIncrement adds the decimal integer in value to the decimal integer of the key
+	OpIncrement
+	// OpPrepare locks the keys of Commands for the cross-shard transaction Key and votes on it
+	OpPrepare
+	// OpCommit executes the commands prepared for transaction Key without checking their preconditions again,
+	// which held when prepared, and releases its locks
+	OpCommit
+	// OpAbort drops the commands prepared for transaction Key and releases its locks
+	OpAbort
+	// OpDecide records Value as the outcome of transaction Key unless one is recorded already
+	OpDecide
+)
+
+// votes and outcomes of cross-shard transactions
+var (
+	Prepared  = Value("prepared")
+	Committed = Value("commit")
+	Aborted   = Value("abort")
+)
+
+// Locked is replied instead of executing a command that writes a key locked by a prepared cross-shard
+// transaction, to be sent again once the transaction finished. Values put through the REST api are
+// padded to 10 KB and never equal it.
+var Locked = Value("\x00locked")
+
+// ErrMismatch is returned when the current value of compare-and-swap is not the expected one
+var ErrMismatch = errors.New("current value does not match expected value")
+
+// ErrNotInteger is returned when increment finds a value or delta that is not a decimal integer
+var ErrNotInteger = errors.New("value is not a decimal integer")
+
 // Command of key-value database
 type Command struct {
 	Key       Key
 	Value     Value
 	ClientID  ID
 	CommandID int
+	Op        Op
+	To        Key       // end of scan range
+	Limit     int       // maximum number of pairs returned by scan, no limit if 0
+	Commands  []Command // commands of transaction
+	Expect    Value     // expected value of compare-and-swap
+}
+
+// KV is a key-value pair returned by scan
+type KV struct {
+	Key   Key   `json:"key"`
+	Value Value `json:"value"`
+}
+
+// Scan creates a command reading at most limit pairs in key range [from, to)
+func Scan(from, to Key, limit int) Command {
+	return Command{Key: from, To: to, Limit: limit, Op: OpScan}
+}
+
+// Delete creates a command removing key
+func Delete(k Key) Command {
+	return Command{Key: k, Op: OpDelete}
+}
+
+// CAS creates a command putting value to key if its current value equals expect,
+// a nil expect only matches a missing key
+func CAS(k Key, expect, value Value) Command {
+	return Command{Key: k, Value: value, Expect: expect, Op: OpCAS}
+}
+
+// Increment creates a command adding delta to the integer of key
+func Increment(k Key, delta int64) Command {
+	return Command{Key: k, Value: Value(strconv.FormatInt(delta, 10)), Op: OpIncrement}
+}
+
+// Txn creates a command executing commands atomically in one log entry
+func Txn(commands []Command) Command {
+	return Command{Commands: commands, Op: OpTxn}
+}
+
+// Prepare creates a command preparing the commands of one shard for cross-shard transaction id
+func Prepare(id Key, commands []Command) Command {
+	return Command{Key: id, Commands: commands, Op: OpPrepare}
+}
+
+// Decide creates a command recording the outcome of transaction id, Committed or Aborted
+func Decide(id Key, outcome Value) Command {
+	return Command{Key: id, Value: outcome, Op: OpDecide}
 }
 
 func (c Command) Empty() bool {
-	if c.Key == 0 && c.Value == nil && c.ClientID == "" && c.CommandID == 0 {
+	if c.Key == "" && c.Value == nil && c.ClientID == "" && c.CommandID == 0 && c.Op == OpGetPut && len(c.Commands) == 0 {
 		return true
 	}
 	return false
 }
 
 func (c Command) IsRead() bool {
-	return c.Value == nil
+	if c.IsTxn() {
+		for _, cmd := range c.Commands {
+			if !cmd.IsRead() {
+				return false
+			}
+		}
+		return true
+	}
+	return c.Op == OpScan || c.Op == OpGetPut && c.Value == nil
 }
 
 func (c Command) IsWrite() bool {
-	return c.Value != nil
+	return !c.IsRead()
+}
+
+// IsTxn returns true if command is a transaction of commands
+func (c Command) IsTxn() bool {
+	return c.Op == OpTxn
+}
+
+// IsScan returns true if command reads a range of keys
+func (c Command) IsScan() bool {
+	return c.Op == OpScan
+}
+
+// Covers returns true if key is in the range of command, which is the key itself except for scans
+// and transactions
+func (c Command) Covers(k Key) bool {
+	if c.IsTxn() {
+		for _, cmd := range c.Commands {
+			if cmd.Covers(k) {
+				return true
+			}
+		}
+		return false
+	}
+	if c.IsScan() {
+		return k >= c.Key && (c.To == "" || k < c.To)
+	}
+	return k == c.Key
 }
 
 func (c Command) Equal(a Command) bool {
-	return c.Key == a.Key && bytes.Equal(c.Value, a.Value) && c.ClientID == a.ClientID && c.CommandID == a.CommandID
+	if len(c.Commands) != len(a.Commands) {
+		return false
+	}
+	for i := range c.Commands {
+		if !c.Commands[i].Equal(a.Commands[i]) {
+			return false
+		}
+	}
+	return c.Key == a.Key && bytes.Equal(c.Value, a.Value) && c.ClientID == a.ClientID && c.CommandID == a.CommandID &&
+		c.Op == a.Op && c.To == a.To && c.Limit == a.Limit && bytes.Equal(c.Expect, a.Expect)
 }
 
 func (c Command) String() string {
+	switch c.Op {
+	case OpTxn:
+		return fmt.Sprintf("Txn{cmds=%v id=%s cid=%d}", c.Commands, c.ClientID, c.CommandID)
+	case OpScan:
+		return fmt.Sprintf("Scan{from=%v to=%v limit=%d id=%s cid=%d}", c.Key, c.To, c.Limit, c.ClientID, c.CommandID)
+	case OpDelete:
+		return fmt.Sprintf("Delete{key=%v id=%s cid=%d}", c.Key, c.ClientID, c.CommandID)
+	case OpCAS:
+		return fmt.Sprintf("CAS{key=%v expect=%x value=%x id=%s cid=%d}", c.Key, short(c.Expect), short(c.Value), c.ClientID, c.CommandID)
+	case OpIncrement:
+		return fmt.Sprintf("Increment{key=%v delta=%s id=%s cid=%d}", c.Key, c.Value, c.ClientID, c.CommandID)
+	case OpPrepare:
+		return fmt.Sprintf("Prepare{txn=%v cmds=%v id=%s cid=%d}", c.Key, c.Commands, c.ClientID, c.CommandID)
+	case OpCommit:
+		return fmt.Sprintf("Commit{txn=%v id=%s cid=%d}", c.Key, c.ClientID, c.CommandID)
+	case OpAbort:
+		return fmt.Sprintf("Abort{txn=%v id=%s cid=%d}", c.Key, c.ClientID, c.CommandID)
+	case OpDecide:
+		return fmt.Sprintf("Decide{txn=%v outcome=%s id=%s cid=%d}", c.Key, c.Value, c.ClientID, c.CommandID)
+	}
 	if c.Value == nil {
 		return fmt.Sprintf("Get{key=%v id=%s cid=%d}", c.Key, c.ClientID, c.CommandID)
 	}
-	return fmt.Sprintf("Put{key=%v value=%x id=%s cid=%d", c.Key, c.Value[0:100], c.ClientID, c.CommandID)
+	return fmt.Sprintf("Put{key=%v value=%x id=%s cid=%d}", c.Key, short(c.Value), c.ClientID, c.CommandID)
 }
 
-// Database defines a database interface
-// TODO replace with more general StateMachine interface
+// short cuts value to the first 100 bytes for printing
+func short(v Value) Value {
+	if len(v) > 100 {
+		return v[:100]
+	}
+	return v
+}
+
+// Database defines a key-value database interface, the default StateMachine
 type Database interface {
-	Execute(Command) Value
+	StateMachine
 	History(Key) []Value
 	Get(Key) Value
 	Put(Key, Value)
+	Scan(from, to Key, limit int) []KV
+	Checkpoint(slot int, sessions map[ID]Session) <-chan *Checkpoint
+	LastCheckpoint() *Checkpoint
+	Pending() []Key
+}
+
+// ErrHashMismatch is returned restoring a snapshot whose data, transactions and sessions do not match its hash
+var ErrHashMismatch = errors.New("snapshot hash mismatch")
+
+// Checkpoint is a point-in-time snapshot of database and client sessions after executing up to Slot,
+// Hash is the SHA-256 of the database hash at that point together with transactions and sessions
+type Checkpoint struct {
+	Slot     int               `json:"slot"`
+	Version  int               `json:"version"`
+	Hash     []byte            `json:"hash"`
+	Data     map[Key]Value     `json:"data"`
+	History  map[Key][]Value   `json:"history,omitempty"`
+	Prepared map[Key][]Command `json:"prepared,omitempty"`
+	Outcomes map[Key]Value     `json:"outcomes,omitempty"`
+	Sessions map[ID]Session    `json:"sessions,omitempty"`
+}
+
+// prior is the value of a key before it changed during a checkpoint
+type prior struct {
+	value  Value
+	exists bool
 }
 
+// building is a checkpoint being copied in background,
+// writes meanwhile save the value they overwrite in undo first
+type building struct {
+	*Checkpoint
+	undo map[Key]prior
+	done chan *Checkpoint
+}
+
+// number of keys copied for a checkpoint each time database is locked
+const checkpointBatch = 1024
+
 // Database implements a multi-version key-value datastore as the StateMachine
 type database struct {
 	sync.RWMutex
 	data         map[Key]Value
+	keys         []Key // keys of data in order
 	version      int
 	multiversion bool
 	history      map[Key][]Value
+	engine       Engine    // persists writes
+	hash         stateHash // multiset hash of all pairs
+	building     []*building
+	checkpoint   *Checkpoint // last checkpoint taken
+
+	prepared map[Key][]Command // commands of prepared cross-shard transactions
+	locks    map[Key]Key       // transaction holding lock of key
+	outcomes map[Key]Value     // outcomes of cross-shard transactions decided or finished here
 }
 
 // NewDatabase returns database that impelements Database interface
@@ -73,9 +286,36 @@ func NewDatabase() Database {
 		version:      0,
 		multiversion: config.MultiVersion,
 		history:      make(map[Key][]Value),
+		engine:       memory{},
+		prepared:     make(map[Key][]Command),
+		locks:        make(map[Key]Key),
+		outcomes:     make(map[Key]Value),
 	}
 }
 
+// OpenDatabase returns database of node id on the storage engine in configuration,
+// recovered from the writes the engine persisted before
+func OpenDatabase(id ID) (Database, error) {
+	engine, err := NewEngine(id, config.Storage)
+	if err != nil {
+		return nil, err
+	}
+	d := NewDatabase().(*database)
+	err = engine.Replay(func(k Key, v Value, deleted bool) {
+		if deleted {
+			d.delete(k)
+		} else {
+			d.put(k, v)
+		}
+	})
+	if err != nil {
+		engine.Close()
+		return nil, err
+	}
+	d.engine = engine
+	return d, nil
+}
+
 /*
 // Execute implements StateMachine interface
 func (d *database) Execute(c interface{}) interface{} {
@@ -103,16 +343,380 @@ func (d *database) Execute(c interface{}) interface{} {
 func (d *database) Execute(c Command) Value {
 	d.Lock()
 	defer d.Unlock()
+	return d.execute(c)
+}
+
+// execute executes command with database locked,
+// a transaction returns the values of its commands encoded in json
+func (d *database) execute(c Command) Value {
+	switch c.Op {
+	case OpScan:
+		b, err := json.Marshal(d.scan(c.Key, c.To, c.Limit))
+		if err != nil {
+			log.Error(err)
+		}
+		return b
+
+	case OpTxn:
+		if d.locked(c) {
+			return Locked
+		}
+		values := make([]Value, len(c.Commands))
+		for i, cmd := range c.Commands {
+			values[i] = d.execute(cmd)
+		}
+		b, err := json.Marshal(values)
+		if err != nil {
+			log.Error(err)
+		}
+		return b
+
+	case OpPrepare:
+		return d.prepare(c.Key, c.Commands)
+
+	case OpCommit:
+		commands, ok := d.prepared[c.Key]
+		if !ok {
+			log.Errorf("commit of transaction %v that is not prepared", c.Key)
+			return nil
+		}
+		d.release(c.Key)
+		d.outcomes[c.Key] = Committed
+		return d.execute(Txn(applied(commands)))
+
+	case OpAbort:
+		d.release(c.Key)
+		d.outcomes[c.Key] = Aborted
+		return Aborted
+
+	case OpDecide:
+		if outcome, ok := d.outcomes[c.Key]; ok {
+			return outcome
+		}
+		d.outcomes[c.Key] = c.Value
+		return c.Value
+	}
+
+	if d.locked(c) {
+		return Locked
+	}
 
 	// get previous value
-	v := d.data[c.Key]
+	v, exists := d.data[c.Key]
+
+	switch c.Op {
+	case OpDelete:
+		d.delete(c.Key)
 
-	// writes new value
-	d.put(c.Key, c.Value)
+	case OpCAS:
+		if exists && c.Expect != nil && bytes.Equal(v, c.Expect) || !exists && c.Expect == nil {
+			d.put(c.Key, c.Value)
+		}
+
+	case OpIncrement:
+		if !Incremented(c, v) {
+			log.Warningf("cannot execute %v on value %x", c, short(v))
+			break
+		}
+		n, _ := parseInt(v)
+		delta, _ := parseInt(c.Value)
+		d.put(c.Key, Value(strconv.FormatInt(n+delta, 10)))
+
+	default:
+		// writes new value
+		d.put(c.Key, c.Value)
+	}
 
 	return v
 }
 
+// prepare locks keys of commands for transaction id and votes Prepared, or votes Aborted if a key is locked
+// by another transaction, a compare-and-swap or an increment would fail, or the transaction was aborted before.
+// Locks order cross-shard transactions, and single-shard writes to locked keys are refused with Locked,
+// so preconditions that held when prepared still hold when committed.
+func (d *database) prepare(id Key, commands []Command) Value {
+	if outcome, ok := d.outcomes[id]; ok {
+		return outcome
+	}
+	if _, ok := d.prepared[id]; ok {
+		return Prepared
+	}
+	for _, c := range commands {
+		if t, locked := d.locks[c.Key]; locked && t != id {
+			return Aborted
+		}
+		if v, exists := d.data[c.Key]; c.Op == OpCAS && !(exists && c.Expect != nil && bytes.Equal(v, c.Expect) || !exists && c.Expect == nil) {
+			return Aborted
+		}
+		if c.Op == OpIncrement && !Incremented(c, d.data[c.Key]) {
+			return Aborted
+		}
+	}
+	for _, c := range commands {
+		d.locks[c.Key] = id
+	}
+	d.prepared[id] = commands
+	return Prepared
+}
+
+// applied returns prepared commands as they apply at commit, where compare-and-swaps are puts
+func applied(commands []Command) []Command {
+	a := make([]Command, len(commands))
+	for i, c := range commands {
+		if c.Op == OpCAS {
+			c.Op, c.Expect = OpGetPut, nil
+		}
+		a[i] = c
+	}
+	return a
+}
+
+// locked returns true if command writes a key locked by a prepared cross-shard transaction
+func (d *database) locked(c Command) bool {
+	if c.IsTxn() {
+		for _, cmd := range c.Commands {
+			if d.locked(cmd) {
+				return true
+			}
+		}
+		return false
+	}
+	if c.IsRead() {
+		return false
+	}
+	_, locked := d.locks[c.Key]
+	return locked
+}
+
+// Pending returns ids of cross-shard transactions prepared and not committed or aborted yet
+func (d *database) Pending() []Key {
+	d.RLock()
+	defer d.RUnlock()
+	ids := make([]Key, 0, len(d.prepared))
+	for id := range d.prepared {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// release drops prepared commands of transaction id and unlocks its keys
+func (d *database) release(id Key) {
+	for _, c := range d.prepared[id] {
+		if d.locks[c.Key] == id {
+			delete(d.locks, c.Key)
+		}
+	}
+	delete(d.prepared, id)
+}
+
+// parseInt parses decimal integer of value, where a missing value is zero,
+// http requests pad values with zeros
+// Incremented tells if increment c applied to key of previous value v, which it leaves as it is
+// unless both are decimal integers
+func Incremented(c Command, v Value) bool {
+	_, err := parseInt(v)
+	_, err2 := parseInt(c.Value)
+	return err == nil && err2 == nil
+}
+
+func parseInt(v Value) (int64, error) {
+	v = bytes.TrimRight(v, "\x00")
+	if len(v) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseInt(string(v), 10, 64)
+}
+
+// Snapshot implements StateMachine interface, encodes current state with history as a checkpoint
+// without slot and sessions
+func (d *database) Snapshot() ([]byte, error) {
+	d.RLock()
+	defer d.RUnlock()
+	return json.Marshal(Checkpoint{
+		Slot:     -1,
+		Version:  d.version,
+		Hash:     d.hash.digest(d.prepared, d.outcomes, nil),
+		Data:     d.data,
+		History:  d.history,
+		Prepared: d.prepared,
+		Outcomes: d.outcomes,
+	})
+}
+
+// Restore implements StateMachine interface, restores a snapshot or checkpoint
+// and returns ErrHashMismatch if its data, transactions and sessions do not match its hash.
+// Sessions of a checkpoint are only verified, the client session table is kept by the node.
+func (d *database) Restore(b []byte) error {
+	s := Checkpoint{}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if s.Data == nil {
+		s.Data = make(map[Key]Value)
+	}
+	if s.History == nil {
+		s.History = make(map[Key][]Value)
+	}
+	if s.Prepared == nil {
+		s.Prepared = make(map[Key][]Command)
+	}
+	if s.Outcomes == nil {
+		s.Outcomes = make(map[Key]Value)
+	}
+	var hash stateHash
+	for k, v := range s.Data {
+		hash.add(k, v)
+	}
+	if !bytes.Equal(s.Hash, hash.digest(s.Prepared, s.Outcomes, s.Sessions)) {
+		return ErrHashMismatch
+	}
+	d.Lock()
+	defer d.Unlock()
+	if err := d.engine.Reset(s.Data); err != nil {
+		return err
+	}
+	// checkpoints being built keep every key as before restore
+	for k := range d.data {
+		d.preserve(k)
+	}
+	for k := range s.Data {
+		d.preserve(k)
+	}
+	d.data = s.Data
+	d.keys = make([]Key, 0, len(s.Data))
+	for k := range s.Data {
+		d.keys = append(d.keys, k)
+	}
+	sort.Slice(d.keys, func(i, j int) bool { return d.keys[i] < d.keys[j] })
+	d.version = s.Version
+	d.history = s.History
+	d.hash = hash
+	d.prepared = s.Prepared
+	d.outcomes = s.Outcomes
+	d.locks = make(map[Key]Key)
+	for id, commands := range s.Prepared {
+		for _, c := range commands {
+			d.locks[c.Key] = id
+		}
+	}
+	return nil
+}
+
+// Hash implements State interface over keys and current values
+func (d *database) Hash() uint64 {
+	d.RLock()
+	defer d.RUnlock()
+	return d.hash.sum64()
+}
+
+// Checkpoint starts a snapshot of current state labeled with execution slot together with the client
+// sessions at that point, and delivers it once copied. The copy is made in the background a batch
+// of keys at a time, so commands keep executing meanwhile.
+func (d *database) Checkpoint(slot int, sessions map[ID]Session) <-chan *Checkpoint {
+	d.Lock()
+	b := &building{
+		Checkpoint: &Checkpoint{
+			Slot:     slot,
+			Version:  d.version,
+			Hash:     d.hash.digest(d.prepared, d.outcomes, sessions),
+			Sessions: sessions,
+			Prepared: make(map[Key][]Command, len(d.prepared)),
+			Outcomes: make(map[Key]Value, len(d.outcomes)),
+		},
+		undo: make(map[Key]prior),
+		done: make(chan *Checkpoint, 1),
+	}
+	// transaction state is small and copied right away
+	for id, commands := range d.prepared {
+		b.Prepared[id] = commands
+	}
+	for id, outcome := range d.outcomes {
+		b.Outcomes[id] = outcome
+	}
+	d.building = append(d.building, b)
+	d.Unlock()
+	go d.build(b)
+	return b.done
+}
+
+func (d *database) build(b *building) {
+	data := make(map[Key]Value)
+	var last Key
+	for i, end := 0, false; !end; {
+		d.RLock()
+		if i > 0 {
+			// continue after the last key copied, keys may have been inserted or deleted since
+			i = sort.Search(len(d.keys), func(i int) bool { return d.keys[i] > last })
+		}
+		for n := 0; i < len(d.keys) && n < checkpointBatch; i, n = i+1, n+1 {
+			data[d.keys[i]] = d.data[d.keys[i]]
+			last = d.keys[i]
+		}
+		end = i >= len(d.keys)
+		d.RUnlock()
+	}
+
+	d.Lock()
+	// keys written after checkpoint started go back to their prior values
+	for k, p := range b.undo {
+		if p.exists {
+			data[k] = p.value
+		} else {
+			delete(data, k)
+		}
+	}
+	for i := range d.building {
+		if d.building[i] == b {
+			d.building = append(d.building[:i], d.building[i+1:]...)
+			break
+		}
+	}
+	b.Data = data
+	if d.checkpoint == nil || b.Slot >= d.checkpoint.Slot {
+		d.checkpoint = b.Checkpoint
+	}
+	d.Unlock()
+	b.done <- b.Checkpoint
+}
+
+// LastCheckpoint returns the checkpoint of the highest slot, nil if there is none
+func (d *database) LastCheckpoint() *Checkpoint {
+	d.RLock()
+	defer d.RUnlock()
+	return d.checkpoint
+}
+
+// preserve saves current value of key for checkpoints being built before it changes
+func (d *database) preserve(k Key) {
+	for _, b := range d.building {
+		if _, exists := b.undo[k]; !exists {
+			v, exists := d.data[k]
+			b.undo[k] = prior{value: v, exists: exists}
+		}
+	}
+}
+
+// Scan returns at most limit pairs in key range [from, to) in key order,
+// to is unbounded if empty and limit is unbounded if not positive
+func (d *database) Scan(from, to Key, limit int) []KV {
+	d.RLock()
+	defer d.RUnlock()
+	return d.scan(from, to, limit)
+}
+
+func (d *database) scan(from, to Key, limit int) []KV {
+	kvs := make([]KV, 0)
+	i := sort.Search(len(d.keys), func(i int) bool { return d.keys[i] >= from })
+	for ; i < len(d.keys); i++ {
+		if to != "" && d.keys[i] >= to || limit > 0 && len(kvs) >= limit {
+			break
+		}
+		kvs = append(kvs, KV{Key: d.keys[i], Value: d.data[d.keys[i]]})
+	}
+	return kvs
+}
+
 // Get gets the current value and version of given key
 func (d *database) Get(k Key) Value {
 	d.RLock()
@@ -122,7 +726,20 @@ func (d *database) Get(k Key) Value {
 
 func (d *database) put(k Key, v Value) {
 	if v != nil {
+		d.preserve(k)
+		if old, exists := d.data[k]; !exists {
+			i := sort.Search(len(d.keys), func(i int) bool { return d.keys[i] >= k })
+			d.keys = append(d.keys, "")
+			copy(d.keys[i+1:], d.keys[i:])
+			d.keys[i] = k
+		} else {
+			d.hash.remove(k, old)
+		}
 		d.data[k] = v
+		d.hash.add(k, v)
+		if err := d.engine.Put(k, v); err != nil {
+			log.Errorf("cannot persist key %v: %v", k, err)
+		}
 		d.version++
 		if d.multiversion {
 			if d.history[k] == nil {
@@ -133,6 +750,22 @@ func (d *database) put(k Key, v Value) {
 	}
 }
 
+func (d *database) delete(k Key) {
+	old, exists := d.data[k]
+	if !exists {
+		return
+	}
+	d.preserve(k)
+	d.hash.remove(k, old)
+	delete(d.data, k)
+	if err := d.engine.Delete(k); err != nil {
+		log.Errorf("cannot persist deletion of key %v: %v", k, err)
+	}
+	i := sort.Search(len(d.keys), func(i int) bool { return d.keys[i] >= k })
+	d.keys = append(d.keys[:i], d.keys[i+1:]...)
+	d.version++
+}
+
 // Put puts a new value of given key
 func (d *database) Put(k Key, v Value) {
 	d.Lock()
@@ -163,7 +796,13 @@ func (d *database) String() string {
 
 // Conflict checks if two commands are conflicting as reorder them will end in different states
 func Conflict(gamma *Command, delta *Command) bool {
-	if gamma.Key == delta.Key {
+	if gamma.IsTxn() {
+		return ConflictBatch(gamma.Commands, []Command{*delta})
+	}
+	if delta.IsTxn() {
+		return ConflictBatch([]Command{*gamma}, delta.Commands)
+	}
+	if gamma.Covers(delta.Key) || delta.Covers(gamma.Key) {
 		if !gamma.IsRead() || !delta.IsRead() {
 			return true
 		}
@@ -0,0 +1,216 @@
+package PaxiBFT
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestDatabaseScan(t *testing.T) {
+	db := NewDatabase()
+	for _, k := range []Key{"b", "d", "a", "c", "e"} {
+		db.Put(k, Value(k))
+	}
+
+	keys := func(kvs []KV) string {
+		s := ""
+		for _, kv := range kvs {
+			s += string(kv.Key)
+		}
+		return s
+	}
+	if s := keys(db.Scan("b", "e", 0)); s != "bcd" {
+		t.Errorf("scan [b, e) = %s, expected bcd", s)
+	}
+	if s := keys(db.Scan("", "", 2)); s != "ab" {
+		t.Errorf("scan limit 2 = %s, expected ab", s)
+	}
+	if s := keys(db.Scan("c", "", 0)); s != "cde" {
+		t.Errorf("scan [c, ) = %s, expected cde", s)
+	}
+
+	kvs := make([]KV, 0)
+	if err := json.Unmarshal(db.Execute(Scan("a", "c", 0)), &kvs); err != nil {
+		t.Fatal(err)
+	}
+	if s := keys(kvs); s != "ab" {
+		t.Errorf("executed scan [a, c) = %s, expected ab", s)
+	}
+}
+
+func TestConflictScan(t *testing.T) {
+	scan := Scan("b", "d", 0)
+	in := Command{Key: "c", Value: Value("v")}
+	out := Command{Key: "d", Value: Value("v")}
+	read := Command{Key: "c"}
+	if !Conflict(&scan, &in) || !Conflict(&in, &scan) {
+		t.Errorf("scan does not conflict with write in range")
+	}
+	if Conflict(&scan, &out) {
+		t.Errorf("scan conflicts with write out of range")
+	}
+	if Conflict(&scan, &read) {
+		t.Errorf("scan conflicts with read")
+	}
+}
+
+func TestDatabaseTxn(t *testing.T) {
+	db := NewDatabase()
+	db.Put("a", Value("0"))
+	txn := Txn([]Command{
+		{Key: "a", Value: Value("1")},
+		{Key: "a"},
+		Scan("a", "", 0),
+	})
+	if txn.IsRead() || !Conflict(&txn, &Command{Key: "a"}) {
+		t.Errorf("transaction with write is not a conflicting write")
+	}
+
+	values := make([]Value, 0)
+	if err := json.Unmarshal(db.Execute(txn), &values); err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 || string(values[0]) != "0" || string(values[1]) != "1" {
+		t.Errorf("transaction values = %q, expected previous value 0 and read 1", values)
+	}
+}
+
+func TestDatabaseLocks(t *testing.T) {
+	db := NewDatabase()
+	db.Put("a", Value("0"))
+	db.Put("b", Value("x"))
+	if v := db.Execute(Prepare("s/t/1", []Command{CAS("a", Value("0"), Value("1"))})); !bytes.Equal(v, Prepared) {
+		t.Fatalf("prepare voted %s", v)
+	}
+	if !reflect.DeepEqual(db.Pending(), []Key{"s/t/1"}) {
+		t.Errorf("pending transactions %v", db.Pending())
+	}
+
+	// single-shard writes to a locked key are refused, reads are not
+	for _, c := range []Command{{Key: "a", Value: Value("2")}, Delete("a"), Increment("a", 1), Txn([]Command{{Key: "a", Value: Value("2")}})} {
+		if v := db.Execute(c); !bytes.Equal(v, Locked) {
+			t.Errorf("%v of locked key returned %q", c, v)
+		}
+	}
+	if v := db.Execute(Command{Key: "a"}); string(v) != "0" {
+		t.Errorf("read of locked key returned %q", v)
+	}
+
+	// commit applies the prepared compare-and-swap and unlocks the key
+	db.Execute(Command{Key: "s/t/1", Op: OpCommit})
+	if v := db.Get("a"); string(v) != "1" || len(db.Pending()) != 0 {
+		t.Errorf("committed a = %s, pending %v", v, db.Pending())
+	}
+	if v := db.Execute(Command{Key: "a", Value: Value("2")}); string(v) != "1" {
+		t.Errorf("write after commit returned %q", v)
+	}
+
+	// an increment of a value that is not an integer votes abort
+	if v := db.Execute(Prepare("s/t/2", []Command{Increment("b", 1)})); !bytes.Equal(v, Aborted) {
+		t.Errorf("prepare of increment on %q voted %s", db.Get("b"), v)
+	}
+}
+
+func TestDatabaseConditional(t *testing.T) {
+	db := NewDatabase()
+
+	db.Execute(CAS("lock", nil, Value("a")))
+	if v := db.Execute(CAS("lock", nil, Value("b"))); string(v) != "a" || string(db.Get("lock")) != "a" {
+		t.Errorf("create-only put overwrote existing key")
+	}
+	db.Execute(CAS("lock", Value("a"), Value("c")))
+	if v := db.Get("lock"); string(v) != "c" {
+		t.Errorf("compare-and-swap with expected value = %s, expected c", v)
+	}
+	if v := db.Execute(CAS("lock", Value("a"), Value("d"))); string(v) != "c" || string(db.Get("lock")) != "c" {
+		t.Errorf("compare-and-swap with stale value swapped")
+	}
+
+	db.Execute(Increment("n", 5))
+	db.Execute(Increment("n", -2))
+	if v := db.Get("n"); string(v) != "3" {
+		t.Errorf("increment = %s, expected 3", v)
+	}
+	if v := db.Execute(Increment("lock", 1)); string(v) != "c" || string(db.Get("lock")) != "c" {
+		t.Errorf("increment changed non-integer value")
+	}
+
+	if v := db.Execute(Delete("n")); string(v) != "3" {
+		t.Errorf("delete returned %s, expected previous value 3", v)
+	}
+	if db.Get("n") != nil || len(db.Scan("", "", 0)) != 1 {
+		t.Errorf("deleted key is still present")
+	}
+	if Delete("n").IsRead() {
+		t.Errorf("delete is a read")
+	}
+}
+
+func TestDatabaseCheckpoint(t *testing.T) {
+	db := NewDatabase()
+	n := 3 * checkpointBatch
+	for i := 0; i < n; i++ {
+		db.Put(Key(strconv.Itoa(i)), Value(strconv.Itoa(i)))
+	}
+	hash := db.Hash()
+	// transaction state of a shard is part of the checkpoint
+	db.Execute(Prepare("s/t/1", []Command{{Key: "x", Value: Value("1")}}))
+	db.Execute(Decide("s/t/0", Committed))
+	sessions := map[ID]Session{"c": {CommandID: 1, Reply: Value("0")}}
+	c := db.Checkpoint(n-1, sessions)
+
+	// writes while checkpoint is copied do not show in it
+	for i := 0; i < n; i += 3 {
+		db.Execute(Command{Key: Key(strconv.Itoa(i)), Value: Value("x")})
+		db.Execute(Delete(Key(strconv.Itoa(i + 1))))
+		db.Execute(Command{Key: Key("new" + strconv.Itoa(i)), Value: Value("y")})
+	}
+	cp := <-c
+	if cp.Slot != n-1 || len(cp.Data) != n || cp.Sessions["c"].CommandID != 1 {
+		t.Fatalf("checkpoint at slot %d has %d keys and sessions %v, expected %d keys", cp.Slot, len(cp.Data), cp.Sessions, n)
+	}
+	if len(cp.Prepared["s/t/1"]) != 1 || !bytes.Equal(cp.Outcomes["s/t/0"], Committed) {
+		t.Fatalf("checkpoint has prepared %v and outcomes %v", cp.Prepared, cp.Outcomes)
+	}
+	for i := 0; i < n; i++ {
+		if v := cp.Data[Key(strconv.Itoa(i))]; string(v) != strconv.Itoa(i) {
+			t.Fatalf("checkpoint has %d = %s", i, v)
+		}
+	}
+	if db.LastCheckpoint() != cp {
+		t.Error("last checkpoint is not the one taken")
+	}
+
+	// restored checkpoint has the same hash, which does not depend on write order
+	b, _ := json.Marshal(cp)
+	restored := NewDatabase()
+	if err := restored.Restore(b); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Hash() != hash {
+		t.Errorf("restored hash %x, expected %x", restored.Hash(), hash)
+	}
+	reversed := NewDatabase()
+	for i := n - 1; i >= 0; i-- {
+		reversed.Put(Key(strconv.Itoa(i)), Value(strconv.Itoa(i)))
+	}
+	if reversed.Hash() != hash {
+		t.Errorf("hash %x of same data written in reverse, expected %x", reversed.Hash(), hash)
+	}
+
+	// every checkpoint is verified, also one without hash or with other transactions or sessions
+	for name, change := range map[string]func(cp Checkpoint) Checkpoint{
+		"data":     func(cp Checkpoint) Checkpoint { cp.Data = map[Key]Value{"0": Value("diverged")}; return cp },
+		"prepared": func(cp Checkpoint) Checkpoint { cp.Prepared = map[Key][]Command{"s/t/1": nil}; return cp },
+		"outcomes": func(cp Checkpoint) Checkpoint { cp.Outcomes = map[Key]Value{"s/t/0": Aborted}; return cp },
+		"sessions": func(cp Checkpoint) Checkpoint { cp.Sessions = map[ID]Session{"c": {CommandID: 2}}; return cp },
+		"hash":     func(cp Checkpoint) Checkpoint { cp.Hash = nil; return cp },
+	} {
+		b, _ = json.Marshal(change(*cp))
+		if err := NewDatabase().Restore(b); err != ErrHashMismatch {
+			t.Errorf("restore of checkpoint with changed %s returned %v", name, err)
+		}
+	}
+}
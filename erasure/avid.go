@@ -0,0 +1,180 @@
+package erasure
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"github.com/salemmohammed/PaxiBFT"
+	"github.com/salemmohammed/PaxiBFT/log"
+)
+
+// window is the number of dispersed payloads kept
+const window = 1000
+
+// errInconsistent is the error of chunks that do not encode the same data
+var errInconsistent = errors.New("chunks do not match root")
+
+// state of one dispersed payload at a replica
+type state struct {
+	chunks   [][]byte // verified chunks by replica index
+	count    int
+	echoed   bool
+	done     bool   // reconstructed or rejected
+	data     []byte // nil if dealer encoded chunks inconsistently
+	retrieve []func([]byte)
+}
+
+// Dispersal spreads payloads with verifiable information dispersal (AVID). Dealer encodes the
+// payload into n chunks, any f+1 of which reconstruct it, and sends every replica only its own
+// chunk with a merkle proof under the root of all chunks; replicas echo their chunk to each
+// other. So dealer sends about (n-1)/(f+1) payloads instead of n-1, in exchange for every
+// replica sending its chunk to the others.
+//
+// A replica reconstructs the payload from the first f+1 chunks that verify under the root and
+// re-encodes it; if the root of the re-encoded chunks differs, the dealer encoded inconsistent
+// chunks and every correct replica rejects the payload alike.
+type Dispersal struct {
+	PaxiBFT.Node
+
+	ids    []PaxiBFT.ID // sorted node ids, index of node is the chunk it keeps
+	index  map[PaxiBFT.ID]int
+	coder  *Coder
+	states map[string]*state
+	order  []string // roots in order of arrival, oldest are dropped beyond window
+}
+
+// NewDispersal creates dispersal over node, with code of f+1 out of n chunks
+func NewDispersal(n PaxiBFT.Node) *Dispersal {
+	ids := PaxiBFT.GetConfig().IDs()
+	sort.Sort(PaxiBFT.IDs(ids))
+	coder, err := NewCoder((len(ids)-1)/3+1, len(ids))
+	if err != nil {
+		log.Fatal(err)
+	}
+	d := &Dispersal{
+		Node:   n,
+		ids:    ids,
+		index:  make(map[PaxiBFT.ID]int),
+		coder:  coder,
+		states: make(map[string]*state),
+	}
+	for i, id := range ids {
+		d.index[id] = i
+	}
+	n.Register(Disperse{}, d.handleDisperse)
+	n.Register(Echo{}, d.handleEcho)
+	return d
+}
+
+// Disperse sends every other replica its chunk of data and returns the root identifying data
+func (d *Dispersal) Disperse(data []byte) []byte {
+	chunks := d.coder.Encode(data)
+	root := MerkleRoot(chunks)
+	s := d.get(root)
+	for i, id := range d.ids {
+		if id == d.ID() {
+			continue
+		}
+		d.Send(id, Disperse{
+			Dealer: d.ID(),
+			Root:   root,
+			Index:  i,
+			Chunk:  chunks[i],
+			Proof:  MerkleProof(chunks, i),
+		})
+	}
+	s.echoed = true
+	d.deliver(s, append([]byte(nil), data...))
+	return root
+}
+
+// Retrieve calls f with the payload of root once reconstructed, or with nil if dealer of root
+// encoded inconsistent chunks
+func (d *Dispersal) Retrieve(root []byte, f func([]byte)) {
+	s := d.get(root)
+	if s.done {
+		f(s.data)
+		return
+	}
+	s.retrieve = append(s.retrieve, f)
+}
+
+// get returns state of root, creating it on first access
+func (d *Dispersal) get(root []byte) *state {
+	r := string(root)
+	s, ok := d.states[r]
+	if !ok {
+		s = &state{chunks: make([][]byte, len(d.ids))}
+		d.states[r] = s
+		d.order = append(d.order, r)
+		if len(d.order) > window {
+			delete(d.states, d.order[0])
+			d.order = d.order[1:]
+		}
+	}
+	return s
+}
+
+func (d *Dispersal) handleDisperse(m Disperse) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.Dealer, m, d.ID())
+	if m.Index != d.index[d.ID()] || !VerifyProof(m.Root, m.Chunk, m.Index, len(d.ids), m.Proof) {
+		log.Errorf("invalid chunk %v from dealer %v", m, m.Dealer)
+		return
+	}
+	s := d.get(m.Root)
+	if s.echoed {
+		return
+	}
+	s.echoed = true
+	d.Broadcast(Echo{
+		ID:    d.ID(),
+		Root:  m.Root,
+		Index: m.Index,
+		Chunk: m.Chunk,
+		Proof: m.Proof,
+	})
+	d.add(m.Root, s, m.Index, m.Chunk)
+}
+
+func (d *Dispersal) handleEcho(m Echo) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, d.ID())
+	i, ok := d.index[m.ID]
+	if !ok || m.Index != i || !VerifyProof(m.Root, m.Chunk, m.Index, len(d.ids), m.Proof) {
+		log.Errorf("invalid chunk %v from %v", m, m.ID)
+		return
+	}
+	d.add(m.Root, d.get(m.Root), m.Index, m.Chunk)
+}
+
+// add verified chunk i of root and reconstruct payload once there are enough chunks
+func (d *Dispersal) add(root []byte, s *state, i int, chunk []byte) {
+	if s.done || s.chunks[i] != nil {
+		return
+	}
+	s.chunks[i] = chunk
+	s.count++
+	if s.count < d.coder.K() {
+		return
+	}
+	data, err := d.coder.Decode(s.chunks)
+	if err == nil && !bytes.Equal(MerkleRoot(d.coder.Encode(data)), root) {
+		err = errInconsistent
+	}
+	if err != nil {
+		log.Errorf("cannot reconstruct payload %x: %v", root, err)
+		data = nil
+	}
+	d.deliver(s, data)
+}
+
+// deliver completes state with data and hands data to retrievers
+func (d *Dispersal) deliver(s *state, data []byte) {
+	s.done = true
+	s.data = data
+	s.chunks = nil
+	for _, f := range s.retrieve {
+		f(data)
+	}
+	s.retrieve = nil
+}
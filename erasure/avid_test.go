@@ -0,0 +1,228 @@
+package erasure
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/salemmohammed/PaxiBFT"
+)
+
+func init() {
+	gob.Register(plain{})
+}
+
+// plain carries the whole payload to compare dispersal with broadcast
+type plain struct {
+	Data []byte
+}
+
+// do runs f on the goroutine of replica
+type do struct {
+	f func()
+}
+
+// counter is a node that counts bytes it sends in gob encoding
+type counter struct {
+	PaxiBFT.Node
+	bytes int64
+}
+
+func (c *counter) count(m interface{}) int64 {
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(&m)
+	return int64(buf.Len())
+}
+
+func (c *counter) Send(to PaxiBFT.ID, m interface{}) {
+	if to != c.ID() {
+		atomic.AddInt64(&c.bytes, c.count(m))
+	}
+	c.Node.Send(to, m)
+}
+
+func (c *counter) Broadcast(m interface{}) {
+	atomic.AddInt64(&c.bytes, c.count(m)*int64(len(PaxiBFT.GetConfig().Addrs)-1))
+	c.Node.Broadcast(m)
+}
+
+func (c *counter) sent() int64 {
+	return atomic.LoadInt64(&c.bytes)
+}
+
+type replica struct {
+	*counter
+	dispersal *Dispersal
+	delivered chan []byte
+	silent    bool
+}
+
+func newReplica(id PaxiBFT.ID) *replica {
+	r := &replica{
+		counter:   &counter{Node: PaxiBFT.NewNode(id)},
+		delivered: make(chan []byte, 100),
+	}
+	r.dispersal = NewDispersal(r.counter)
+	r.Register(do{}, func(m do) { m.f() })
+	r.Register(plain{}, func(m plain) { r.delivered <- m.Data })
+	// replica retrieves payload it received a chunk of, crashed replica ignores dispersal
+	r.Register(Disperse{}, func(m Disperse) {
+		if r.silent {
+			return
+		}
+		r.dispersal.handleDisperse(m)
+		r.dispersal.Retrieve(m.Root, func(data []byte) { r.delivered <- data })
+	})
+	r.Register(Echo{}, func(m Echo) {
+		if !r.silent {
+			r.dispersal.handleEcho(m)
+		}
+	})
+	return r
+}
+
+// run executes f on replica and waits for it
+func (r *replica) run(f func()) {
+	done := make(chan struct{})
+	r.Send(r.ID(), do{f: func() {
+		f()
+		close(done)
+	}})
+	<-done
+}
+
+// cluster starts n replicas in simulation
+func cluster(t testing.TB, port, n int) map[PaxiBFT.ID]*replica {
+	PaxiBFT.Simulation()
+	c := PaxiBFT.MakeDefaultConfig()
+	c.Addrs = make(map[PaxiBFT.ID]string)
+	c.HTTPAddrs = make(map[PaxiBFT.ID]string)
+	for i := 1; i <= n; i++ {
+		id := PaxiBFT.NewID(1, i)
+		p := strconv.Itoa(port + i)
+		c.Addrs[id] = "chan://127.0.0.1:" + p
+		c.HTTPAddrs[id] = "http://127.0.0.1:" + p
+	}
+	PaxiBFT.SetConfig(c)
+
+	replicas := make(map[PaxiBFT.ID]*replica)
+	for id := range c.Addrs {
+		replicas[id] = newReplica(id)
+		go replicas[id].Run()
+		err := PaxiBFT.Retry(func() error {
+			r, err := http.Get(c.HTTPAddrs[id] + "/load")
+			if err == nil {
+				r.Body.Close()
+			}
+			return err
+		}, 50, 10*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return replicas
+}
+
+// expect waits for data delivered to every replica in ids once
+func expect(t *testing.T, replicas map[PaxiBFT.ID]*replica, data []byte, ids ...PaxiBFT.ID) {
+	for _, id := range ids {
+		select {
+		case got := <-replicas[id].delivered:
+			if !bytes.Equal(got, data) {
+				t.Errorf("replica %v reconstructed %d different bytes", id, len(got))
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("replica %v did not reconstruct payload", id)
+		}
+	}
+}
+
+func TestDispersal(t *testing.T) {
+	replicas := cluster(t, 20900, 4)
+	r := rand.New(rand.NewSource(1))
+	data := make([]byte, 10*1024)
+	r.Read(data)
+
+	// every replica reconstructs from chunks of f+1 = 2 out of 4, dealer sends half of broadcast
+	dealer := replicas["1.1"]
+	before := dealer.sent()
+	dealer.run(func() { dealer.dispersal.Disperse(data) })
+	expect(t, replicas, data, "1.2", "1.3", "1.4")
+	if sent, limit := dealer.sent()-before, int64(3*len(data)*6/10); sent > limit {
+		t.Errorf("dealer sent %d bytes to disperse %d bytes, more than %d", sent, len(data), limit)
+	}
+
+	// payload survives crash of f replicas
+	replicas["1.4"].run(func() { replicas["1.4"].silent = true })
+	r.Read(data)
+	dealer = replicas["1.2"]
+	dealer.run(func() { dealer.dispersal.Disperse(data) })
+	expect(t, replicas, data, "1.1", "1.3")
+}
+
+func TestDispersalInconsistent(t *testing.T) {
+	d := &Dispersal{states: make(map[string]*state), ids: make([]PaxiBFT.ID, 4)}
+	d.coder, _ = NewCoder(2, 4)
+	a := d.coder.Encode([]byte("payload a"))
+	b := d.coder.Encode([]byte("payload b"))
+
+	// faulty dealer commits to chunks of different payloads, every pair of chunks is rejected
+	mixed := [][]byte{a[0], b[1], a[2], b[3]}
+	root := MerkleRoot(mixed)
+	subsets(4, 2, func(keep []int) {
+		d.states = make(map[string]*state)
+		s := d.get(root)
+		for _, i := range keep {
+			d.add(root, s, i, mixed[i])
+		}
+		d.Retrieve(root, func(data []byte) {
+			if data != nil {
+				t.Errorf("chunks %v of inconsistent dealer reconstructed %q", keep, data)
+			}
+		})
+		if !s.done {
+			t.Errorf("chunks %v of inconsistent dealer not rejected", keep)
+		}
+	})
+}
+
+var bench struct {
+	sync.Once
+	replicas map[PaxiBFT.ID]*replica
+}
+
+// benchmark sends payloads of 10 KB from 1.1 to 3 replicas and reports bytes dealer sent per payload
+func benchmark(b *testing.B, send func(dealer *replica, data []byte)) {
+	bench.Do(func() { bench.replicas = cluster(b, 20910, 4) })
+	dealer := bench.replicas["1.1"]
+	others := []PaxiBFT.ID{"1.2", "1.3", "1.4"}
+	data := make([]byte, 10*1024)
+	before := dealer.sent()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data[0], data[1], data[2] = byte(i), byte(i>>8), byte(i>>16)
+		dealer.run(func() { send(dealer, data) })
+		for _, id := range others {
+			<-bench.replicas[id].delivered
+		}
+	}
+	b.ReportMetric(float64(dealer.sent()-before)/float64(b.N), "leader-B/op")
+}
+
+func BenchmarkBroadcast(b *testing.B) {
+	benchmark(b, func(dealer *replica, data []byte) {
+		dealer.Broadcast(plain{Data: data})
+	})
+}
+
+func BenchmarkDisperse(b *testing.B) {
+	benchmark(b, func(dealer *replica, data []byte) {
+		dealer.dispersal.Disperse(data)
+	})
+}
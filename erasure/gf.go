@@ -0,0 +1,83 @@
+package erasure
+
+// arithmetic in GF(2^8) with reducing polynomial x^8+x^4+x^3+x^2+1
+
+var (
+	gfExp [510]byte
+	gfLog [256]int
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfExp[i+255] = byte(x)
+		gfLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+gfLog[b]]
+}
+
+func inv(a byte) byte {
+	return gfExp[255-gfLog[a]]
+}
+
+// mulAdd adds c times src to dst
+func mulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	lc := gfLog[c]
+	for i, s := range src {
+		if s != 0 {
+			dst[i] ^= gfExp[lc+gfLog[s]]
+		}
+	}
+}
+
+// invert returns inverse of square matrix m, or false if m is singular
+func invert(m [][]byte) ([][]byte, bool) {
+	n := len(m)
+	a := make([][]byte, n)
+	r := make([][]byte, n)
+	for i := range m {
+		a[i] = append([]byte(nil), m[i]...)
+		r[i] = make([]byte, n)
+		r[i][i] = 1
+	}
+	for c := 0; c < n; c++ {
+		p := c
+		for p < n && a[p][c] == 0 {
+			p++
+		}
+		if p == n {
+			return nil, false
+		}
+		a[c], a[p] = a[p], a[c]
+		r[c], r[p] = r[p], r[c]
+		if v := a[c][c]; v != 1 {
+			iv := inv(v)
+			for j := 0; j < n; j++ {
+				a[c][j] = mul(a[c][j], iv)
+				r[c][j] = mul(r[c][j], iv)
+			}
+		}
+		for i := 0; i < n; i++ {
+			if i != c && a[i][c] != 0 {
+				f := a[i][c]
+				mulAdd(a[i], a[c], f)
+				mulAdd(r[i], r[c], f)
+			}
+		}
+	}
+	return r, true
+}
@@ -0,0 +1,90 @@
+package erasure
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// leaf and inner node hashes are domain separated, so that a leaf cannot pose as inner node
+func leafHash(leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+func nodeHash(l, r []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(l)
+	h.Write(r)
+	return h.Sum(nil)
+}
+
+// levels returns every level of merkle tree over leaves from leaf hashes up to root,
+// a node without sibling is carried up to next level unchanged
+func levels(leaves [][]byte) [][][]byte {
+	level := make([][]byte, len(leaves))
+	for i, l := range leaves {
+		level[i] = leafHash(l)
+	}
+	tree := [][][]byte{level}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+			} else {
+				next = append(next, nodeHash(level[i], level[i+1]))
+			}
+		}
+		tree = append(tree, next)
+		level = next
+	}
+	return tree
+}
+
+// MerkleRoot returns root of merkle tree over leaves
+func MerkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return nil
+	}
+	tree := levels(leaves)
+	return tree[len(tree)-1][0]
+}
+
+// MerkleProof returns sibling hashes on path from leaf i to root
+func MerkleProof(leaves [][]byte, i int) [][]byte {
+	proof := make([][]byte, 0)
+	tree := levels(leaves)
+	for _, level := range tree[:len(tree)-1] {
+		if s := i ^ 1; s < len(level) {
+			proof = append(proof, level[s])
+		}
+		i /= 2
+	}
+	return proof
+}
+
+// VerifyProof checks that leaf is leaf i of n in merkle tree of root
+func VerifyProof(root, leaf []byte, i, n int, proof [][]byte) bool {
+	if i < 0 || i >= n {
+		return false
+	}
+	h := leafHash(leaf)
+	for width := n; width > 1; width = (width + 1) / 2 {
+		if s := i ^ 1; s < width {
+			if len(proof) == 0 {
+				return false
+			}
+			if i%2 == 0 {
+				h = nodeHash(h, proof[0])
+			} else {
+				h = nodeHash(proof[0], h)
+			}
+			proof = proof[1:]
+		}
+		i /= 2
+	}
+	return len(proof) == 0 && bytes.Equal(h, root)
+}
@@ -0,0 +1,42 @@
+package erasure
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestMerkle(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		leaves := make([][]byte, n)
+		for i := range leaves {
+			leaves[i] = []byte("chunk" + strconv.Itoa(i))
+		}
+		root := MerkleRoot(leaves)
+		for i := range leaves {
+			proof := MerkleProof(leaves, i)
+			if !VerifyProof(root, leaves[i], i, n, proof) {
+				t.Fatalf("proof of leaf %d of %d does not verify", i, n)
+			}
+			if VerifyProof(root, []byte("forged"), i, n, proof) {
+				t.Errorf("forged leaf %d of %d verifies", i, n)
+			}
+			if n > 1 && VerifyProof(root, leaves[i], (i+1)%n, n, proof) {
+				t.Errorf("leaf %d of %d verifies at index %d", i, n, (i+1)%n)
+			}
+			if len(proof) > 0 && VerifyProof(root, leaves[i], i, n, proof[:len(proof)-1]) {
+				t.Errorf("truncated proof of leaf %d of %d verifies", i, n)
+			}
+		}
+		if VerifyProof(root, leaves[0], n, n, MerkleProof(leaves, 0)) {
+			t.Errorf("leaf verifies out of range of %d", n)
+		}
+	}
+
+	// inner node cannot pose as leaf
+	leaves := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}
+	root := MerkleRoot(leaves)
+	inner := append(leafHash(leaves[0]), leafHash(leaves[1])...)
+	if VerifyProof(root, inner, 0, 2, [][]byte{nodeHash(leafHash(leaves[2]), leafHash(leaves[3]))}) {
+		t.Error("inner node verifies as leaf")
+	}
+}
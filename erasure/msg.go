@@ -0,0 +1,39 @@
+package erasure
+
+import (
+	"encoding/gob"
+	"fmt"
+
+	"github.com/salemmohammed/PaxiBFT"
+)
+
+func init() {
+	gob.Register(Disperse{})
+	gob.Register(Echo{})
+}
+
+// Disperse carries the chunk of one replica from dealer
+type Disperse struct {
+	Dealer PaxiBFT.ID
+	Root   []byte
+	Index  int
+	Chunk  []byte
+	Proof  [][]byte // merkle proof of chunk under root
+}
+
+func (m Disperse) String() string {
+	return fmt.Sprintf("Disperse {Dealer %v, Root %x, Index %d, Chunk %d bytes}", m.Dealer, m.Root, m.Index, len(m.Chunk))
+}
+
+// Echo forwards own chunk to every other replica
+type Echo struct {
+	ID    PaxiBFT.ID
+	Root  []byte
+	Index int
+	Chunk []byte
+	Proof [][]byte
+}
+
+func (m Echo) String() string {
+	return fmt.Sprintf("Echo {ID %v, Root %x, Index %d, Chunk %d bytes}", m.ID, m.Root, m.Index, len(m.Chunk))
+}
@@ -0,0 +1,111 @@
+package erasure
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrTooFewShards is returned when less than k shards are left to decode
+var ErrTooFewShards = errors.New("too few shards to reconstruct data")
+
+// Coder is a systematic Reed-Solomon code of k data shards and n-k parity shards, data is
+// reconstructed from any k of the n shards. Parity rows of the encoding matrix form a Cauchy
+// matrix, so that every k rows of the matrix are independent.
+type Coder struct {
+	k, n   int
+	matrix [][]byte // n x k encoding matrix, identity on top
+}
+
+// NewCoder creates coder of n shards any k of which reconstruct data
+func NewCoder(k, n int) (*Coder, error) {
+	if k <= 0 || n < k || n > 256 {
+		return nil, fmt.Errorf("invalid code of %d out of %d shards", k, n)
+	}
+	c := &Coder{k: k, n: n, matrix: make([][]byte, n)}
+	for i := 0; i < n; i++ {
+		c.matrix[i] = make([]byte, k)
+		if i < k {
+			c.matrix[i][i] = 1
+			continue
+		}
+		// x_i = i and y_j = j are distinct elements for parity row i >= k and column j < k
+		for j := 0; j < k; j++ {
+			c.matrix[i][j] = inv(byte(i) ^ byte(j))
+		}
+	}
+	return c, nil
+}
+
+// K returns number of shards needed to reconstruct data
+func (c *Coder) K() int { return c.k }
+
+// N returns number of shards
+func (c *Coder) N() int { return c.n }
+
+// Encode splits data prefixed by its length into k equal data shards and computes n-k parity shards
+func (c *Coder) Encode(data []byte) [][]byte {
+	size := (len(data) + 4 + c.k - 1) / c.k
+	buf := make([]byte, size*c.k)
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], data)
+
+	shards := make([][]byte, c.n)
+	for i := 0; i < c.k; i++ {
+		shards[i] = buf[i*size : (i+1)*size : (i+1)*size]
+	}
+	for i := c.k; i < c.n; i++ {
+		shards[i] = make([]byte, size)
+		for j := 0; j < c.k; j++ {
+			mulAdd(shards[i], shards[j], c.matrix[i][j])
+		}
+	}
+	return shards
+}
+
+// Decode reconstructs data from n shards where missing shards are nil
+func (c *Coder) Decode(shards [][]byte) ([]byte, error) {
+	if len(shards) != c.n {
+		return nil, fmt.Errorf("%d shards given to code of %d", len(shards), c.n)
+	}
+	rows := make([]int, 0, c.k)
+	size := -1
+	for i, s := range shards {
+		if s == nil || len(rows) == c.k {
+			continue
+		}
+		if size >= 0 && len(s) != size {
+			return nil, errors.New("shards differ in size")
+		}
+		size = len(s)
+		rows = append(rows, i)
+	}
+	if len(rows) < c.k {
+		return nil, ErrTooFewShards
+	}
+
+	m := make([][]byte, c.k)
+	for i, r := range rows {
+		m[i] = c.matrix[r]
+	}
+	im, ok := invert(m)
+	if !ok {
+		return nil, errors.New("singular decoding matrix")
+	}
+	buf := make([]byte, size*c.k)
+	for i := 0; i < c.k; i++ {
+		out := buf[i*size : (i+1)*size]
+		for j, r := range rows {
+			mulAdd(out, shards[r], im[i][j])
+		}
+	}
+
+	if len(buf) < 4 {
+		return nil, errors.New("shards too short")
+	}
+	l := binary.BigEndian.Uint32(buf)
+	if int64(l) > int64(len(buf)-4) {
+		return nil, errors.New("invalid data length")
+	}
+	return buf[4 : 4+l], nil
+}
@@ -0,0 +1,131 @@
+package erasure
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestGF(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if mul(byte(a), inv(byte(a))) != 1 {
+			t.Fatalf("%d * inv(%d) != 1", a, a)
+		}
+		for b := 0; b < 256; b += 17 {
+			c := byte(b + 3)
+			if mul(byte(a), byte(b)^c) != mul(byte(a), byte(b))^mul(byte(a), c) {
+				t.Fatalf("multiplication of %d does not distribute over %d + %d", a, b, c)
+			}
+		}
+	}
+}
+
+func TestNewCoder(t *testing.T) {
+	for _, c := range [][2]int{{0, 4}, {5, 4}, {2, 257}} {
+		if _, err := NewCoder(c[0], c[1]); err == nil {
+			t.Errorf("code of %d out of %d created", c[0], c[1])
+		}
+	}
+}
+
+// subsets calls f with every subset of k out of n indices
+func subsets(n, k int, f func([]int)) {
+	var rec func(start int, s []int)
+	rec = func(start int, s []int) {
+		if len(s) == k {
+			f(s)
+			return
+		}
+		for i := start; i < n; i++ {
+			rec(i+1, append(s, i))
+		}
+	}
+	rec(0, nil)
+}
+
+func TestCoder(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, code := range [][2]int{{1, 1}, {1, 4}, {2, 4}, {3, 7}, {4, 10}} {
+		k, n := code[0], code[1]
+		c, err := NewCoder(k, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, size := range []int{0, 1, 7, 100, 10 * 1024} {
+			data := make([]byte, size)
+			r.Read(data)
+			shards := c.Encode(data)
+			if len(shards) != n {
+				t.Fatalf("%d shards of code %d out of %d", len(shards), k, n)
+			}
+			for _, s := range shards {
+				if len(s) != len(shards[0]) || len(s)*k < size {
+					t.Fatalf("shard of %d bytes for %d bytes in code %d out of %d", len(s), size, k, n)
+				}
+			}
+
+			// data is reconstructed from any k shards
+			subsets(n, k, func(keep []int) {
+				given := make([][]byte, n)
+				for _, i := range keep {
+					given[i] = shards[i]
+				}
+				got, err := c.Decode(given)
+				if err != nil {
+					t.Fatalf("decode %d bytes from shards %v of code %d out of %d: %v", size, keep, k, n, err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Fatalf("shards %v of code %d out of %d decoded to different %d bytes", keep, k, n, size)
+				}
+			})
+
+			// but not from k-1
+			given := make([][]byte, n)
+			copy(given, shards[:k-1])
+			if _, err := c.Decode(given); err != ErrTooFewShards {
+				t.Errorf("decode from %d shards of code %d out of %d: %v", k-1, k, n, err)
+			}
+		}
+	}
+}
+
+func TestCoderLarge(t *testing.T) {
+	c, err := NewCoder(86, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 100*1024)
+	rand.New(rand.NewSource(2)).Read(data)
+	shards := c.Encode(data)
+	r := rand.New(rand.NewSource(3))
+	for round := 0; round < 5; round++ {
+		given := make([][]byte, c.N())
+		for _, i := range r.Perm(c.N())[:c.K()] {
+			given[i] = shards[i]
+		}
+		got, err := c.Decode(given)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("decode from random %d of %d shards failed: %v", c.K(), c.N(), err)
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	c, _ := NewCoder(2, 4)
+	data := make([]byte, 10*1024)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		c.Encode(data)
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	c, _ := NewCoder(2, 4)
+	data := make([]byte, 10*1024)
+	shards := c.Encode(data)
+	shards[0] = nil
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		c.Decode(shards)
+	}
+}
@@ -0,0 +1,134 @@
+package PaxiBFT
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"sort"
+)
+
+// stateLanes is the number of 16-bit lanes of a state hash
+const stateLanes = 1024
+
+// stateHash is an incremental multiset hash (LtHash) of key-value pairs. Every pair is expanded
+// into 1024 lanes of 16 bits by AES-CTR keyed with its SHA-256, and the hash is the lane-wise sum
+// of all pairs, so a write updates it in constant time and the order of writes does not matter,
+// while finding two different sets of pairs with the same hash is as hard as a lattice problem.
+type stateHash [stateLanes]uint16
+
+// pairLanes returns the lanes of pair k, v
+func pairLanes(k Key, v Value) *stateHash {
+	h := sha256.New()
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(len(k)))
+	h.Write(b)
+	h.Write([]byte(k))
+	binary.BigEndian.PutUint64(b, uint64(len(v)))
+	h.Write(b)
+	h.Write(v)
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		panic(err)
+	}
+	stream := make([]byte, 2*stateLanes)
+	cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(stream, stream)
+	lanes := new(stateHash)
+	for i := range lanes {
+		lanes[i] = binary.BigEndian.Uint16(stream[2*i:])
+	}
+	return lanes
+}
+
+// add adds pair k, v to the set
+func (s *stateHash) add(k Key, v Value) {
+	lanes := pairLanes(k, v)
+	for i := range s {
+		s[i] += lanes[i]
+	}
+}
+
+// remove removes pair k, v from the set
+func (s *stateHash) remove(k Key, v Value) {
+	lanes := pairLanes(k, v)
+	for i := range s {
+		s[i] -= lanes[i]
+	}
+}
+
+// digest is the SHA-256 of the lanes together with the prepared commands and outcomes
+// of cross-shard transactions and the client session table
+func (s *stateHash) digest(prepared map[Key][]Command, outcomes map[Key]Value, sessions sessions) []byte {
+	h := sha256.New()
+	b := make([]byte, 2*stateLanes)
+	for i, lane := range s {
+		binary.BigEndian.PutUint16(b[2*i:], lane)
+	}
+	h.Write(b)
+	hashTxns(h, prepared, outcomes)
+	sessions.hash(h)
+	return h.Sum(nil)
+}
+
+// sum64 is the state hash of the pairs that replicas compare, the first 8 bytes of their digest
+func (s *stateHash) sum64() uint64 {
+	return binary.BigEndian.Uint64(s.digest(nil, nil, nil))
+}
+
+// hashTxns writes prepared commands and outcomes of transactions to h in transaction order
+func hashTxns(h hash.Hash, prepared map[Key][]Command, outcomes map[Key]Value) {
+	ids := make([]string, 0, len(prepared))
+	for id := range prepared {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+	hashInt(h, len(ids))
+	for _, id := range ids {
+		hashBytes(h, []byte(id))
+		hashInt(h, len(prepared[Key(id)]))
+		for _, c := range prepared[Key(id)] {
+			hashCommand(h, c)
+		}
+	}
+
+	ids = ids[:0]
+	for id := range outcomes {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+	hashInt(h, len(ids))
+	for _, id := range ids {
+		hashBytes(h, []byte(id))
+		hashBytes(h, outcomes[Key(id)])
+	}
+}
+
+// hashCommand writes every field of command c to h
+func hashCommand(h hash.Hash, c Command) {
+	hashInt(h, int(c.Op))
+	hashBytes(h, []byte(c.Key))
+	hashBytes(h, c.Value)
+	hashBytes(h, c.Expect)
+	hashBytes(h, []byte(c.ClientID))
+	hashInt(h, c.CommandID)
+	hashBytes(h, []byte(c.To))
+	hashInt(h, c.Limit)
+	hashInt(h, len(c.Commands))
+	for _, sub := range c.Commands {
+		hashCommand(h, sub)
+	}
+}
+
+// hashInt writes i to h in 8 bytes
+func hashInt(h hash.Hash, i int) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(i))
+	h.Write(b)
+}
+
+// hashBytes writes b to h prefixed by its length
+func hashBytes(h hash.Hash, b []byte) {
+	hashInt(h, len(b))
+	h.Write(b)
+}
@@ -1,6 +1,7 @@
 package PaxiBFT
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -16,73 +17,84 @@ const (
 	HTTPCommandID = "Cid"
 	HTTPTimestamp = "Timestamp"
 	HTTPNodeID    = "Id"
+
+	// HTTPIfMatch makes a put compare-and-swap the value in header for the body
+	HTTPIfMatch = "If-Match"
+	// HTTPIfNoneMatch of "*" makes a put only create a missing key
+	HTTPIfNoneMatch = "If-None-Match"
 )
 
-// serve serves the http REST API request from clients
-func (n *node) http() {
+// mux routes the http REST API of node or consensus instance
+func (n *node) mux() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", n.handleRoot)
+	mux.HandleFunc("/scan", n.handleScan)
+	mux.HandleFunc("/txn", n.handleTxn)
 	mux.HandleFunc("/history", n.handleHistory)
+	mux.HandleFunc("/snapshot", n.handleSnapshot)
 	mux.HandleFunc("/crash", n.handleCrash)
 	mux.HandleFunc("/drop", n.handleDrop)
+	mux.HandleFunc("/load", n.handleLoad)
+	mux.HandleFunc("/traffic", n.handleTraffic)
+	mux.HandleFunc("/peers", n.handlePeers)
+	return mux
+}
+
+// serve serves the http REST API request from clients
+func (n *node) http() {
+	mux := n.mux()
+	mux.HandleFunc("/ns/", n.handleNamespace)
 	// http string should be in form of ":8080"
 	url, err := url.Parse(config.HTTPAddrs[n.id])
 	if err != nil {
 		log.Fatal("http url parse error: ", err)
 	}
 	port := ":" + url.Port()
-	n.server = &http.Server{
+	server := &http.Server{
 		Addr:    port,
 		Handler: mux,
 	}
+	n.Lock()
+	n.server = server
+	n.Unlock()
 	log.Info("http server starting on ", port)
-	log.Fatal(n.server.ListenAndServe())
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
 }
 
 func (n *node) handleRoot(w http.ResponseWriter, r *http.Request) {
-	var req Request
-	var cmd Command
-	var err error
-
-	// get all http headers
-	req.Properties = make(map[string]string)
-	for k := range r.Header {
-		if k == HTTPClientID {
-			cmd.ClientID = ID(r.Header.Get(HTTPClientID))
-			continue
-		}
-		if k == HTTPCommandID {
-			cmd.CommandID, err = strconv.Atoi(r.Header.Get(HTTPCommandID))
-			if err != nil {
-				log.Error(err)
-			}
-			continue
-		}
-		req.Properties[k] = r.Header.Get(k)
-	}
+	req := n.request(r)
+	cmd := req.Command
 
 	// get command key and value
 	if len(r.URL.Path) > 1 {
-		i, err := strconv.Atoi(r.URL.Path[1:])
-		if err != nil {
-			http.Error(w, "invalid path", http.StatusBadRequest)
-			log.Error(err)
-			return
-		}
-		cmd.Key = Key(i)
-		if r.Method == http.MethodPut || r.Method == http.MethodPost {
+		cmd.Key = Key(r.URL.Path[1:])
+		switch r.Method {
+		case http.MethodPut, http.MethodPost, http.MethodPatch:
 			body, err := io.ReadAll(r.Body)
 			if err != nil {
 				log.Error("error reading body: ", err)
 				http.Error(w, "cannot read body", http.StatusBadRequest)
 				return
 			}
-			// Expand the body to 10 KB
-			expandedBody := make([]byte, 10*1024) // 10 KB
-			copy(expandedBody, body)
-			cmd.Value = Value(expandedBody)
-
+			if r.Method == http.MethodPatch {
+				cmd.Op = OpIncrement
+				cmd.Value = Value(body)
+				break
+			}
+			cmd.Value = expand(body)
 			//cmd.Value = Value(body)
+
+			// conditional put compares to value expanded the same way
+			if r.Header.Get(HTTPIfNoneMatch) == "*" {
+				cmd.Op = OpCAS
+			} else if _, ok := r.Header[HTTPIfMatch]; ok {
+				cmd.Op = OpCAS
+				cmd.Expect = expand([]byte(r.Header.Get(HTTPIfMatch)))
+			}
+		case http.MethodDelete:
+			cmd.Op = OpDelete
 		}
 	} else {
 		body, err := io.ReadAll(r.Body)
@@ -94,50 +106,273 @@ func (n *node) handleRoot(w http.ResponseWriter, r *http.Request) {
 		json.Unmarshal(body, &cmd)
 	}
 
+	if cmd.Op == OpCommit || cmd.Op == OpAbort || cmd.Op == OpDecide {
+		if err := n.verify(&cmd); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
 	req.Command = cmd
+	log.Debugf("I am in http file %v ",req.Command)
+	reply := n.submit(req)
+	if reply.Err == nil && bytes.Equal(reply.Value, Locked) {
+		http.Error(w, ErrLocked.Error(), http.StatusLocked)
+		return
+	}
+	if cmd.Op == OpCAS && reply.Err == nil && !bytes.Equal(reply.Value, cmd.Expect) {
+		// current value is replied with the failed precondition
+		n.header(w, reply)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		w.Write(reply.Value)
+		return
+	}
+	if cmd.Op == OpIncrement && reply.Err == nil && !Incremented(cmd, reply.Value) {
+		n.header(w, reply)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		w.Write(reply.Value)
+		return
+	}
+	n.respond(w, reply)
+}
+
+// expand pads value of http request with zeros to 10 KB
+func expand(body []byte) Value {
+	// Expand the body to 10 KB
+	expandedBody := make([]byte, 10*1024) // 10 KB
+	copy(expandedBody, body)
+	return Value(expandedBody)
+}
+
+// handleScan orders a range scan /scan?from=&to=&limit= through consensus like other commands
+func (n *node) handleScan(w http.ResponseWriter, r *http.Request) {
+	req := n.request(r)
+	q := r.URL.Query()
+	limit := 0
+	if l := q.Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil {
+			log.Error(err)
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	cmd := Scan(Key(q.Get("from")), Key(q.Get("to")), limit)
+	cmd.ClientID = req.Command.ClientID
+	cmd.CommandID = req.Command.CommandID
+	req.Command = cmd
+	n.respond(w, n.submit(req))
+}
+
+// handleTxn orders a transaction of commands in json as one command through consensus
+// and replies the commands with their values, previous values for writes
+func (n *node) handleTxn(w http.ResponseWriter, r *http.Request) {
+	req := n.request(r)
+	var t Transaction
+	err := json.NewDecoder(r.Body).Decode(&t)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, "invalid transaction", http.StatusBadRequest)
+		return
+	}
+	if n.crossShard(t) {
+		tr := n.coordinate(t, req.Command.ClientID, req.Command.CommandID)
+		if tr.Err == ErrAborted {
+			w.WriteHeader(http.StatusConflict)
+		} else if tr.Err != nil {
+			http.Error(w, tr.Err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// errors do not encode, the status code tells an aborted transaction
+		tr.Err = nil
+		if err := json.NewEncoder(w).Encode(tr); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+	cmd := Txn(t.Commands)
+	cmd.ClientID = req.Command.ClientID
+	cmd.CommandID = req.Command.CommandID
+	req.Command = cmd
+
+	reply := n.submit(req)
+	// the commands submitted stay in the log of protocol, replies are filled in a copy
+	tr := TransactionReply{
+		Commands:  append([]Command(nil), t.Commands...),
+		Timestamp: reply.Timestamp,
+	}
+	values := make([]Value, 0)
+	if reply.Err == nil {
+		err = json.Unmarshal(reply.Value, &values)
+	}
+	if reply.Err == nil && bytes.Equal(reply.Value, Locked) {
+		http.Error(w, ErrLocked.Error(), http.StatusLocked)
+		return
+	}
+	if reply.Err != nil || err != nil || len(values) != len(t.Commands) {
+		http.Error(w, "transaction failed", http.StatusInternalServerError)
+		return
+	}
+	for i := range tr.Commands {
+		tr.Commands[i].Value = values[i]
+	}
+	tr.OK = true
+	n.header(w, reply)
+	err = json.NewEncoder(w).Encode(tr)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// request creates a request of client and command ids from http headers
+func (n *node) request(r *http.Request) Request {
+	var req Request
+	var err error
+
+	// get all http headers
+	req.Properties = make(map[string]string)
+	for k := range r.Header {
+		if k == HTTPClientID {
+			req.Command.ClientID = ID(r.Header.Get(HTTPClientID))
+			continue
+		}
+		if k == HTTPCommandID {
+			req.Command.CommandID, err = strconv.Atoi(r.Header.Get(HTTPCommandID))
+			if err != nil {
+				log.Error(err)
+			}
+			continue
+		}
+		req.Properties[k] = r.Header.Get(k)
+	}
+	return req
+}
+
+// submit sends request to protocol and waits for its reply
+func (n *node) submit(req Request) Reply {
 	req.Timestamp = time.Now().UnixNano()
 	req.NodeID = n.id // TODO does this work when forward twice
 	req.c = make(chan Reply, 1)
 
-	log.Debugf("I am in http file %v ",req.Command)
-	n.MessageChan <- req
+	n.MessageChan <- n.wrap(req)
+
+	return <-req.c
+}
 
-	reply := <-req.c
+// respond writes reply of request to client
+func (n *node) respond(w http.ResponseWriter, reply Reply) {
 	if reply.Err != nil {
 		http.Error(w, reply.Err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// set all http headers
+	n.header(w, reply)
+	_, err := io.WriteString(w, string(reply.Value))
+	if err != nil {
+		log.Error(err)
+	}
+	log.Debugf("<------- done from http -------> ")
+}
+
+// header sets all http headers of reply
+func (n *node) header(w http.ResponseWriter, reply Reply) {
 	w.Header().Set(HTTPClientID, string(reply.Command.ClientID))
 	w.Header().Set(HTTPCommandID, strconv.Itoa(reply.Command.CommandID))
 	for k, v := range reply.Properties {
 		w.Header().Set(k, v)
 	}
+}
 
-	_, err = io.WriteString(w, string(reply.Value))
+func (n *node) handleHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(HTTPNodeID, string(n.id))
+	k := Key(r.URL.Query().Get("key"))
+	db, ok := n.executor.StateMachine.(Database)
+	if !ok {
+		http.Error(w, "state machine has no history", http.StatusNotImplemented)
+		return
+	}
+	h := db.History(k)
+	b, _ := json.Marshal(h)
+	_, err := w.Write(b)
 	if err != nil {
 		log.Error(err)
 	}
-	log.Debugf("<------- done from http -------> ")
 }
 
-func (n *node) handleHistory(w http.ResponseWriter, r *http.Request) {
+// handleSnapshot serves the last database checkpoint on GET, which must be taken at slot if given,
+// and restores database and client sessions from a checkpoint in request body on PUT
+func (n *node) handleSnapshot(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set(HTTPNodeID, string(n.id))
-	k, err := strconv.Atoi(r.URL.Query().Get("key"))
+	db, ok := n.executor.StateMachine.(Database)
+	if !ok {
+		http.Error(w, "state machine has no checkpoints", http.StatusNotImplemented)
+		return
+	}
+
+	if r.Method == http.MethodPut || r.Method == http.MethodPost {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = n.executor.restoreCheckpoint(db, b)
+		if err == ErrHashMismatch {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	cp := db.LastCheckpoint()
+	if s := r.URL.Query().Get("slot"); s != "" && cp != nil && s != strconv.Itoa(cp.Slot) {
+		cp = nil
+	}
+	if cp == nil {
+		http.Error(w, "no checkpoint", http.StatusNotFound)
+		return
+	}
+	b, err := json.Marshal(cp)
 	if err != nil {
-		log.Error(err)
-		http.Error(w, "invalide key", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	h := n.Database.History(Key(k))
-	b, _ := json.Marshal(h)
-	_, err = w.Write(b)
+	if _, err := w.Write(b); err != nil {
+		log.Error(err)
+	}
+}
+
+func (n *node) handleLoad(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(HTTPNodeID, string(n.id))
+	b, _ := json.Marshal(n.Socket.Load())
+	_, err := w.Write(b)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// handleTraffic serves messages and bytes sent to every peer and received by message type
+func (n *node) handleTraffic(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(HTTPNodeID, string(n.id))
+	b, _ := json.Marshal(n.Socket.Traffic())
+	_, err := w.Write(b)
 	if err != nil {
 		log.Error(err)
 	}
 }
 
+// handlePeers serves state of the link to every peer
+func (n *node) handlePeers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(HTTPNodeID, string(n.id))
+	b, _ := json.Marshal(n.Socket.Peers())
+	if _, err := w.Write(b); err != nil {
+		log.Error(err)
+	}
+}
+
 func (n *node) handleCrash(w http.ResponseWriter, r *http.Request) {
 	t, err := strconv.Atoi(r.URL.Query().Get("t"))
 	if err != nil {
@@ -5,6 +5,7 @@ import (
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"net/http"
 	"strconv"
 	"testing"
@@ -28,10 +29,14 @@ func RunServer(t *testing.T, port string) *http.Server {
 		Addr:    ":" + port,
 		Handler: mux,
 	}
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
 	go func() {
-		err := server.ListenAndServe()
+		err := server.Serve(listener)
 		if err != http.ErrServerClosed {
-			t.Fatal(err)
+			t.Error(err)
 		}
 	}()
 	return server
@@ -0,0 +1,129 @@
+package PaxiBFT
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/salemmohammed/PaxiBFT/log"
+)
+
+// Envelope carries message of the consensus instance in namespace between nodes together with
+// its sender, set by the socket of the sender; messages of the default instance have no namespace
+// and are taken out of their envelope by the socket of the receiver
+type Envelope struct {
+	Namespace string
+	From      ID
+	Message   interface{}
+}
+
+// instanceSocket tags every message sent by a consensus instance with its namespace,
+// so that instances of one node share its socket and connections
+type instanceSocket struct {
+	Socket
+	ns string
+}
+
+func (s instanceSocket) Send(to ID, m interface{}) {
+	s.Socket.Send(to, Envelope{Namespace: s.ns, Message: m})
+}
+
+func (s instanceSocket) MulticastZone(zone int, m interface{}) {
+	s.Socket.MulticastZone(zone, Envelope{Namespace: s.ns, Message: m})
+}
+
+func (s instanceSocket) MulticastQuorum(quorum int, m interface{}) {
+	s.Socket.MulticastQuorum(quorum, Envelope{Namespace: s.ns, Message: m})
+}
+
+func (s instanceSocket) Broadcast(m interface{}) {
+	s.Socket.Broadcast(Envelope{Namespace: s.ns, Message: m})
+}
+
+// Instance returns the consensus instance of node in namespace ns, created on first use with
+// its own handlers, state machine and write-ahead log. Instances share the socket, message loop
+// and http server of node, which serves instance ns under /ns/{ns}/. The empty namespace is node itself.
+func (n *node) Instance(ns string) Node {
+	if ns == "" || ns == n.ns {
+		return n
+	}
+	h := n.host
+	h.Lock()
+	defer h.Unlock()
+	if i, exists := h.instances[ns]; exists {
+		return i
+	}
+	// the write-ahead log and database of instance are stored apart from those of other instances
+	store := ID(string(h.id) + "-" + ns)
+	wal, err := OpenWAL(store)
+	if err != nil {
+		log.Fatalf("cannot open write-ahead log of node %v instance %s: %v", h.id, ns, err)
+	}
+	i := &node{
+		id:          h.id,
+		ns:          ns,
+		host:        h,
+		wal:         wal,
+		Socket:      instanceSocket{Socket: h.Socket, ns: ns},
+		executor:    newExecutor(NewStateMachine(config.StateMachine, store)),
+		MessageChan: h.MessageChan,
+		handles:     make(map[string]reflect.Value),
+	}
+	i.api = i.mux()
+	h.instances[ns] = i
+	return i
+}
+
+// Namespace returns namespace of the consensus instance, empty for the default one
+func (n *node) Namespace() string {
+	return n.ns
+}
+
+// wrap puts message of instance into envelope of its namespace
+func (n *node) wrap(m interface{}) interface{} {
+	if n.ns == "" {
+		return m
+	}
+	return Envelope{Namespace: n.ns, Message: m}
+}
+
+// open takes message out of its envelope and returns the instance it belongs to, nil if unknown
+func (n *node) open(m interface{}) (*node, interface{}) {
+	e, ok := m.(Envelope)
+	if !ok {
+		return n, m
+	}
+	n.RLock()
+	i := n.instances[e.Namespace]
+	n.RUnlock()
+	if i == nil {
+		log.Warningf("node %v received message %v of unknown instance %s", n.id, e.Message, e.Namespace)
+	}
+	return i, e.Message
+}
+
+// path returns http path of instance for the api path p
+func (n *node) path(p string) string {
+	if n.ns == "" {
+		return p
+	}
+	return "/ns/" + n.ns + p
+}
+
+// handleNamespace serves /ns/{ns}/... by the api of consensus instance ns
+func (n *node) handleNamespace(w http.ResponseWriter, r *http.Request) {
+	p := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/ns/"), "/", 2)
+	n.RLock()
+	i := n.instances[p[0]]
+	n.RUnlock()
+	if i == nil {
+		http.Error(w, "unknown namespace", http.StatusNotFound)
+		return
+	}
+	r.URL.Path = "/"
+	if len(p) > 1 {
+		r.URL.Path += p[1]
+	}
+	r.URL.RawPath = ""
+	i.api.ServeHTTP(w, r)
+}
@@ -51,12 +51,14 @@ func (h *HashRing) Insert(v interface{}, b []byte) {
 }
 
 func (h *HashRing) search(hash []byte) *hashRingNode {
-	for i := h.head; i.next != h.head; i = i.next {
+	for i := h.head; ; i = i.next {
 		if bytes.Compare(hash, i.hash) < 0 {
 			return i
 		}
+		if i.next == h.head {
+			return h.head
+		}
 	}
-	return h.head
 }
 
 // Get returns the node value that given k belongs to
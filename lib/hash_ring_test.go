@@ -24,3 +24,19 @@ func TestHashRing(t *testing.T) {
 		t.Error()
 	}
 }
+
+func TestHashRingCoverage(t *testing.T) {
+	ring := new(HashRing)
+	for _, v := range []string{"a", "b", "c", "d"} {
+		ring.Insert(v, []byte(v))
+	}
+
+	// every node including the last one on the ring owns some keys
+	owned := make(map[interface{}]bool)
+	for i := 0; i < 1000; i++ {
+		owned[ring.Get([]byte{byte(i), byte(i >> 8)})] = true
+	}
+	if len(owned) != 4 {
+		t.Errorf("keys are owned by %d of 4 nodes", len(owned))
+	}
+}
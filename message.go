@@ -14,6 +14,11 @@ func init() {
 	gob.Register(TransactionReply{})
 	gob.Register(Register{})
 	gob.Register(Config{})
+	gob.Register(Envelope{})
+
+	RegisterBinary(1, Request{})
+	RegisterBinary(2, Reply{})
+	RegisterBinary(3, Envelope{})
 }
 
 /***************************
@@ -58,7 +63,7 @@ type Read struct {
 }
 
 func (r Read) String() string {
-	return fmt.Sprintf("Read {cid=%d, key=%d}", r.CommandID, r.Key)
+	return fmt.Sprintf("Read {cid=%d, key=%v}", r.CommandID, r.Key)
 }
 
 // ReadReply cid and value of reading key
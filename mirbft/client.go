@@ -0,0 +1,38 @@
+package mirbft
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/salemmohammed/PaxiBFT"
+)
+
+// Client sends every request to one replica only, since any replica routes it to the leader
+// of its bucket; replicas are taken in turn so requests spread over buckets
+type Client struct {
+	*PaxiBFT.HTTPClient
+	ids  []PaxiBFT.ID
+	mu   sync.Mutex
+	next int // replica of the next request
+}
+
+// NewClient creates new mir client
+func NewClient(id PaxiBFT.ID) *Client {
+	c := &Client{HTTPClient: PaxiBFT.NewHTTPClient(id)}
+	for id := range c.HTTP {
+		c.ids = append(c.ids, id)
+	}
+	sort.Sort(PaxiBFT.IDs(c.ids))
+	return c
+}
+
+// PutMUL puts value to the next replica as the next request of a client session,
+// concurrent calls take turns on replicas and never share a session
+func (c *Client) PutMUL(key PaxiBFT.Key, value PaxiBFT.Value) error {
+	c.mu.Lock()
+	id := c.ids[c.next%len(c.ids)]
+	c.next++
+	c.mu.Unlock()
+	_, _, err := c.PutTo(id, key, value)
+	return err
+}
@@ -0,0 +1,703 @@
+package mirbft
+
+import (
+	"bytes"
+	"crypto/md5"
+	"flag"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/salemmohammed/PaxiBFT"
+	"github.com/salemmohammed/PaxiBFT/lib"
+	"github.com/salemmohammed/PaxiBFT/log"
+)
+
+var buckets = flag.Int("buckets", 16, "number of request buckets partitioned among leaders")
+var length = flag.Int("epoch_length", 64, "number of sequence numbers in one epoch, buckets rotate among leaders every epoch")
+var batch = flag.Int("max_batch", 100, "maximum number of requests proposed in one sequence number")
+var timeout = flag.Int("epoch_timeout", 1000, "time in ms without executing a sequence number before replicas remove the leader they wait for from the next epoch")
+
+const walVote = "vote/"
+const walCommit = "commit/"
+const walRemoved = "removed/"
+
+// vote is the batch this replica proposed or voted for in a sequence number, persisted before the vote is sent
+type vote struct {
+	Requests  []PaxiBFT.Request
+	Digest    []byte
+	Committed bool
+}
+
+// entry is the state of one sequence number
+type entry struct {
+	requests  []PaxiBFT.Request // nil until pre-prepared
+	digest    []byte
+	prepares  map[PaxiBFT.ID][]byte
+	commits   map[PaxiBFT.ID][]byte
+	prepared  bool
+	committed bool
+}
+
+// Mir orders requests with every replica leading in parallel. Sequence numbers are assigned
+// round robin to leaders and each leader proposes batches only from the buckets it owns, so
+// streams do not overlap and are interleaved by sequence number for execution. Requests fall
+// into buckets by hash of client ID, and the bucket owners rotate every epoch; the replica a
+// client sent a request to routes it to the owner again after every epoch change until it
+// is executed, so a leader censoring a bucket delays its requests by one epoch at most.
+//
+// A crashed leader stops execution at its next sequence number. Replicas that execute nothing
+// for epoch_timeout suspect that leader, stop voting for its proposals and send their prepared
+// batches of it to the primary of the next epoch; a batch of the suspect that 2f+1 others committed
+// is still executed, so a replica that only lags behind keeps up. With 2f+1 of them the primary announces the new epoch,
+// the suspect's sequence numbers left in the current epoch are filled with the batches prepared
+// by any of the 2f+1 replicas, or empty batches otherwise, and from the next epoch on the
+// suspect leads no sequence numbers and owns no buckets.
+type Mir struct {
+	PaxiBFT.Node
+
+	ids      []PaxiBFT.ID                // sorted replica ids
+	ring     *lib.HashRing               // buckets on hash ring
+	log      map[int]*entry              // log ordered by sequence number
+	next     int                         // next own sequence number
+	last     int                         // last own sequence number proposed
+	highest  int                         // highest sequence number pre-prepared
+	execute  int                         // next sequence number to execute
+	queues   [][]PaxiBFT.Request         // requests to propose by bucket
+	included map[string]bool             // requests proposed by self and not executed
+	requests map[string]*PaxiBFT.Request // requests of own clients waiting for reply
+	early    []PrePrepare                // pre-prepares of later epochs, checked once there
+	executed map[int]*entry              // executed sequence numbers of the current and previous epoch, sent again on resend
+
+	removed map[PaxiBFT.ID]int         // epoch from which removed leaders lead nothing
+	ticked  int                        // next sequence number to execute at the last tick
+	change  *EpochChange               // epoch change sent by self and not completed
+	changes map[PaxiBFT.ID]EpochChange // epoch changes received by primary of next epoch
+
+	censor bool // faulty leader that never proposes requests, for testing
+}
+
+// NewMir creates new mir instance
+func NewMir(n PaxiBFT.Node, options ...func(*Mir)) *Mir {
+	ids := PaxiBFT.GetConfig().IDs()
+	sort.Sort(PaxiBFT.IDs(ids))
+	ring := new(lib.HashRing)
+	for b := 0; b < *buckets; b++ {
+		ring.Insert(b, []byte("bucket"+strconv.Itoa(b)))
+	}
+	p := &Mir{
+		Node:     n,
+		ids:      ids,
+		ring:     ring,
+		log:      make(map[int]*entry, PaxiBFT.GetConfig().BufferSize),
+		last:     -1,
+		highest:  -1,
+		queues:   make([][]PaxiBFT.Request, *buckets),
+		included: make(map[string]bool),
+		requests: make(map[string]*PaxiBFT.Request),
+		executed: make(map[int]*entry),
+		removed:  make(map[PaxiBFT.ID]int),
+		changes:  make(map[PaxiBFT.ID]EpochChange),
+	}
+	p.next = p.following(-1)
+	for _, opt := range options {
+		opt(p)
+	}
+	if n.WAL().Recovering() {
+		p.recover()
+	}
+	p.schedule()
+	return p
+}
+
+// recover restores removed leaders from write-ahead log, executes the committed prefix again
+// to rebuild the state machine, and restores the batches voted for after it
+func (p *Mir) recover() {
+	w := p.WAL()
+	for _, k := range w.Keys(walRemoved) {
+		var e int
+		if w.Get(k, &e) {
+			p.removed[PaxiBFT.ID(strings.TrimPrefix(k, walRemoved))] = e
+		}
+	}
+	var requests []PaxiBFT.Request
+	for w.Get(walCommit+strconv.Itoa(p.execute), &requests) {
+		for _, r := range requests {
+			if !p.done(&r) {
+				p.Execute(r.Command)
+			}
+		}
+		p.execute++
+		requests = nil
+	}
+	for _, k := range w.Keys(walVote) {
+		s, err := strconv.Atoi(strings.TrimPrefix(k, walVote))
+		if err != nil || s < p.execute {
+			continue
+		}
+		var v vote
+		if !w.Get(k, &v) {
+			continue
+		}
+		e := p.get(s)
+		e.requests = v.Requests
+		if e.requests == nil {
+			e.requests = make([]PaxiBFT.Request, 0)
+		}
+		e.digest = v.Digest
+		e.prepares[p.ID()] = v.Digest
+		if v.Committed {
+			e.prepared = true
+			e.commits[p.ID()] = v.Digest
+		}
+		if s > p.highest {
+			p.highest = s
+		}
+		if p.leader(s) == p.ID() && s > p.last {
+			p.last = s
+		}
+	}
+	// own sequence numbers proposed before are never proposed again
+	p.next = p.following(PaxiBFT.Max(p.last, p.execute-1))
+	p.ticked = p.execute
+	log.Infof("Replica %s recovered %d removed leaders, %d votes and executed up to %d", p.ID(), len(p.removed), len(p.log), p.execute)
+	// exchanged with the other replicas once the message loop runs
+	p.Send(p.ID(), Resend{ID: p.ID(), Seq: p.execute})
+}
+
+// HandleResend sends own proposals and votes from sequence number m.Seq on again to recovered replica m.ID,
+// which sends its own to every replica first and then asks for theirs
+func (p *Mir) HandleResend(m Resend) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, p.ID())
+	if m.ID != p.ID() {
+		p.resend(m.ID, m.Seq)
+		return
+	}
+	for _, id := range p.ids {
+		if id != p.ID() {
+			p.resend(id, m.Seq)
+		}
+	}
+	p.Broadcast(m)
+}
+
+// resend sends own proposals and votes of sequence numbers from s on to replica id,
+// including those executed in the current and previous epoch
+func (p *Mir) resend(id PaxiBFT.ID, s int) {
+	entries := make(map[int]*entry, len(p.log)+len(p.executed))
+	for seq, e := range p.executed {
+		entries[seq] = e
+	}
+	for seq, e := range p.log {
+		entries[seq] = e
+	}
+	for seq, e := range entries {
+		if seq < s || e.requests == nil {
+			continue
+		}
+		if p.leader(seq) == p.ID() {
+			p.Send(id, PrePrepare{Seq: seq, ID: p.ID(), Requests: e.requests, Digest: e.digest})
+		}
+		if d := e.prepares[p.ID()]; d != nil {
+			p.Send(id, Prepare{Seq: seq, ID: p.ID(), Digest: d})
+		}
+		if d := e.commits[p.ID()]; d != nil {
+			p.Send(id, Commit{Seq: seq, ID: p.ID(), Digest: d})
+		}
+	}
+}
+
+// vote persists batch of sequence number s before the proposal or vote for it is sent
+func (p *Mir) vote(s int, requests []PaxiBFT.Request, digest []byte, committed bool) {
+	p.WAL().Put(walVote+strconv.Itoa(s), vote{Requests: requests, Digest: digest, Committed: committed})
+}
+
+// key identifies request of a client
+func key(r *PaxiBFT.Request) string {
+	return string(r.Command.ClientID) + "/" + strconv.Itoa(r.Command.CommandID)
+}
+
+// digest of batch of requests
+func digest(requests []PaxiBFT.Request) []byte {
+	hasher := md5.New()
+	for _, r := range requests {
+		hasher.Write([]byte(key(&r)))
+		hasher.Write(r.Command.Value)
+	}
+	return hasher.Sum(nil)
+}
+
+// epoch of sequence number s
+func epoch(s int) int {
+	return s / *length
+}
+
+// done returns true if request was executed before, by the client session table of node
+func (p *Mir) done(r *PaxiBFT.Request) bool {
+	if r.Command.ClientID == "" || r.Command.CommandID <= 0 {
+		return false
+	}
+	s, ok := p.Session(r.Command.ClientID)
+	return ok && r.Command.CommandID <= s.CommandID
+}
+
+// bucket of request
+func (p *Mir) bucket(r *PaxiBFT.Request) int {
+	return p.ring.Get([]byte(r.Command.ClientID)).(int)
+}
+
+// leaders returns the leaders of epoch e in order
+func (p *Mir) leaders(e int) []PaxiBFT.ID {
+	leaders := make([]PaxiBFT.ID, 0, len(p.ids))
+	for _, id := range p.ids {
+		if r, removed := p.removed[id]; !removed || e < r {
+			leaders = append(leaders, id)
+		}
+	}
+	return leaders
+}
+
+// owner returns leader of bucket b in epoch e
+func (p *Mir) owner(b, e int) PaxiBFT.ID {
+	leaders := p.leaders(e)
+	return leaders[(b+e)%len(leaders)]
+}
+
+// leader returns leader of sequence number s
+func (p *Mir) leader(s int) PaxiBFT.ID {
+	leaders := p.leaders(epoch(s))
+	return leaders[s%len(leaders)]
+}
+
+// following returns the first own sequence number after s, or -1 if this replica was removed
+func (p *Mir) following(s int) int {
+	if _, removed := p.removed[p.ID()]; removed {
+		return -1
+	}
+	for s++; p.leader(s) != p.ID(); s++ {
+	}
+	return s
+}
+
+// primary returns the replica announcing the epoch that removes leader
+func (p *Mir) primary(leader PaxiBFT.ID) PaxiBFT.ID {
+	for _, id := range p.leaders(epoch(p.execute) + 1) {
+		if id != leader {
+			return id
+		}
+	}
+	return ""
+}
+
+func (p *Mir) get(s int) *entry {
+	e, ok := p.log[s]
+	if !ok {
+		e = &entry{
+			prepares: make(map[PaxiBFT.ID][]byte),
+			commits:  make(map[PaxiBFT.ID][]byte),
+		}
+		p.log[s] = e
+	}
+	return e
+}
+
+// HandleRequest keeps request of own client until executed and routes it to the owner of its bucket
+func (p *Mir) HandleRequest(r PaxiBFT.Request) {
+	if p.done(&r) {
+		log.Warningf("Replica %s received executed request %v", p.ID(), r)
+		return
+	}
+	p.requests[key(&r)] = &r
+	p.route(r, epoch(p.execute))
+	p.propose()
+}
+
+// route sends request to the owner of its bucket in epoch e
+func (p *Mir) route(r PaxiBFT.Request, e int) {
+	b := p.bucket(&r)
+	to := p.owner(b, e)
+	if to == p.ID() {
+		p.queues[b] = append(p.queues[b], r)
+		return
+	}
+	p.Send(to, Forward{ID: p.ID(), Request: r})
+}
+
+// HandleForward queues request of bucket
+func (p *Mir) HandleForward(m Forward) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, p.ID())
+	if p.done(&m.Request) {
+		return
+	}
+	b := p.bucket(&m.Request)
+	p.queues[b] = append(p.queues[b], m.Request)
+	p.propose()
+}
+
+// batch takes up to max_batch requests from the buckets owned in epoch of sequence number s
+func (p *Mir) batch(s int) []PaxiBFT.Request {
+	requests := make([]PaxiBFT.Request, 0)
+	if p.censor {
+		return requests
+	}
+	for b := range p.queues {
+		if p.owner(b, epoch(s)) != p.ID() {
+			continue
+		}
+		q := p.queues[b][:0]
+		for _, r := range p.queues[b] {
+			k := key(&r)
+			if p.done(&r) || p.included[k] {
+				continue
+			}
+			if len(requests) < *batch {
+				p.included[k] = true
+				requests = append(requests, r)
+				continue
+			}
+			q = append(q, r)
+		}
+		p.queues[b] = q
+	}
+	return requests
+}
+
+// propose pre-prepares next own sequence number of the current epoch once the previous one committed,
+// if there are requests to order, others proposed in its epoch, or own clients wait for an epoch change
+func (p *Mir) propose() {
+	if p.last >= p.execute && !p.log[p.last].committed {
+		return
+	}
+	s := p.next
+	if s < 0 || epoch(s) > epoch(p.execute) {
+		return
+	}
+	requests := p.batch(s)
+	if len(requests) == 0 && len(p.requests) == 0 && (p.highest < 0 || epoch(s) > epoch(p.highest)) {
+		return
+	}
+	m := PrePrepare{
+		Seq:      s,
+		ID:       p.ID(),
+		Requests: requests,
+		Digest:   digest(requests),
+	}
+	log.Debugf("Replica %s proposes %v", p.ID(), m)
+	p.vote(s, requests, m.Digest, false)
+	p.last = s
+	p.next = p.following(s)
+	p.Broadcast(m)
+	p.HandlePrePrepare(m)
+}
+
+// HandlePrePrepare accepts batch of the leader of sequence number if every request is from its buckets
+func (p *Mir) HandlePrePrepare(m PrePrepare) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, p.ID())
+	if m.Seq < p.execute {
+		return
+	}
+	if epoch(m.Seq) > epoch(p.execute) {
+		// leaders of a later epoch are known once this replica gets there
+		p.early = append(p.early, m)
+		return
+	}
+	if p.leader(m.Seq) != m.ID {
+		log.Errorf("%v is not the leader of sequence number %d", m.ID, m.Seq)
+		return
+	}
+	for _, r := range m.Requests {
+		if p.owner(p.bucket(&r), epoch(m.Seq)) != m.ID {
+			log.Errorf("request %v of %v is not in buckets of %v in epoch %d", r, m, m.ID, epoch(m.Seq))
+			return
+		}
+	}
+	if !bytes.Equal(digest(m.Requests), m.Digest) {
+		log.Errorf("digest of %v does not match", m)
+		return
+	}
+	e := p.get(m.Seq)
+	if e.requests != nil {
+		return
+	}
+	e.requests = m.Requests
+	e.digest = m.Digest
+	if m.Seq > p.highest {
+		p.highest = m.Seq
+	}
+	if p.change != nil && p.change.Leader == m.ID {
+		// kept without vote until committed by others or the epoch change completes
+		log.Warningf("Replica %s does not vote for %v of suspected leader", p.ID(), m)
+		p.check(m.Seq)
+		return
+	}
+	p.prepare(m.Seq)
+	p.propose()
+}
+
+// prepare votes for the batch pre-prepared in sequence number s
+func (p *Mir) prepare(s int) {
+	e := p.log[s]
+	p.vote(s, e.requests, e.digest, false)
+	e.prepares[p.ID()] = e.digest
+	p.Broadcast(Prepare{
+		Seq:    s,
+		ID:     p.ID(),
+		Digest: e.digest,
+	})
+	p.check(s)
+}
+
+// HandlePrepare collects prepares
+func (p *Mir) HandlePrepare(m Prepare) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, p.ID())
+	if m.Seq < p.execute {
+		return
+	}
+	p.get(m.Seq).prepares[m.ID] = m.Digest
+	p.check(m.Seq)
+}
+
+// HandleCommit collects commits
+func (p *Mir) HandleCommit(m Commit) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, p.ID())
+	if m.Seq < p.execute {
+		return
+	}
+	p.get(m.Seq).commits[m.ID] = m.Digest
+	p.check(m.Seq)
+}
+
+// quorum returns true if 2f+1 votes match digest
+func quorum(votes map[PaxiBFT.ID][]byte, digest []byte) bool {
+	q := PaxiBFT.NewQuorum()
+	for id, d := range votes {
+		if bytes.Equal(d, digest) {
+			q.ACK(id)
+		}
+	}
+	return q.ByzantineMajority()
+}
+
+// check moves sequence number s to prepared and committed
+func (p *Mir) check(s int) {
+	e := p.log[s]
+	if e.requests == nil {
+		return
+	}
+	if !e.prepared && p.change != nil && p.change.Leader == p.leader(s) {
+		// epoch change carries only what was prepared before it was sent,
+		// a batch of the suspected leader committed by 2f+1 others is executed without vote
+		if !e.committed && quorum(e.commits, e.digest) {
+			e.committed = true
+			p.exec()
+		}
+		return
+	}
+	if !e.prepared && quorum(e.prepares, e.digest) {
+		e.prepared = true
+		p.vote(s, e.requests, e.digest, true)
+		e.commits[p.ID()] = e.digest
+		p.Broadcast(Commit{
+			Seq:    s,
+			ID:     p.ID(),
+			Digest: e.digest,
+		})
+	}
+	if e.prepared && !e.committed && quorum(e.commits, e.digest) {
+		e.committed = true
+		p.exec()
+	}
+}
+
+// exec executes committed sequence numbers in order, skipping requests executed before
+func (p *Mir) exec() {
+	current := epoch(p.execute)
+	for {
+		e, ok := p.log[p.execute]
+		if !ok || !e.committed {
+			break
+		}
+		p.WAL().Put(walCommit+strconv.Itoa(p.execute), e.requests)
+		p.WAL().Delete(walVote + strconv.Itoa(p.execute))
+		for _, r := range e.requests {
+			k := key(&r)
+			delete(p.included, k)
+			if p.done(&r) {
+				continue
+			}
+			value := p.Execute(r.Command)
+			if req, ok := p.requests[k]; ok {
+				req.Reply(PaxiBFT.Reply{
+					Command:    req.Command,
+					Value:      value,
+					Properties: make(map[string]string),
+					Timestamp:  req.Timestamp,
+				})
+				delete(p.requests, k)
+			}
+		}
+		p.executed[p.execute] = e
+		delete(p.log, p.execute)
+		p.execute++
+		if p.execute%*length == 0 {
+			log.Debugf("Replica %s enters epoch %d", p.ID(), epoch(p.execute))
+			for seq := range p.executed {
+				if epoch(seq) < epoch(p.execute)-1 {
+					delete(p.executed, seq)
+				}
+			}
+			for _, r := range p.requests {
+				p.route(*r, epoch(p.execute))
+			}
+		}
+	}
+	if epoch(p.execute) > current {
+		early := p.early
+		p.early = nil
+		for _, m := range early {
+			p.HandlePrePrepare(m)
+		}
+	}
+	p.propose()
+}
+
+// schedule checks execution progress after epoch_timeout
+func (p *Mir) schedule() {
+	time.AfterFunc(time.Duration(*timeout)*time.Millisecond, func() {
+		p.Send(p.ID(), tick{})
+	})
+}
+
+// HandleTick suspects the leader of the next sequence number to execute
+// if nothing was executed since the last tick while requests wait
+func (p *Mir) HandleTick(tick) {
+	defer p.schedule()
+	if p.execute > p.ticked || len(p.requests) == 0 && p.highest < p.execute {
+		p.ticked = p.execute
+		return
+	}
+	if p.change != nil {
+		p.Broadcast(*p.change)
+		return
+	}
+	if leader := p.leader(p.execute); leader != p.ID() {
+		p.suspect(leader)
+	}
+}
+
+// suspect refuses proposals of leader and asks the primary of next epoch to remove it
+func (p *Mir) suspect(leader PaxiBFT.ID) {
+	m := EpochChange{
+		Epoch:    epoch(p.execute) + 1,
+		ID:       p.ID(),
+		Leader:   leader,
+		Executed: p.execute,
+	}
+	for s, e := range p.log {
+		if epoch(s) == m.Epoch-1 && p.leader(s) == leader && e.prepared {
+			m.Prepared = append(m.Prepared, PrePrepare{Seq: s, ID: leader, Requests: e.requests, Digest: e.digest})
+		}
+	}
+	sort.Slice(m.Prepared, func(i, j int) bool { return m.Prepared[i].Seq < m.Prepared[j].Seq })
+	log.Infof("Replica %s suspects leader %v at sequence number %d", p.ID(), leader, p.execute)
+	p.change = &m
+	p.Broadcast(m)
+	p.HandleEpochChange(m)
+}
+
+// HandleEpochChange collects epoch changes at primary of the next epoch,
+// which announces it once 2f+1 replicas suspect the same leader
+func (p *Mir) HandleEpochChange(m EpochChange) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, p.ID())
+	if _, removed := p.removed[m.Leader]; removed || m.Epoch <= epoch(p.execute) || p.primary(m.Leader) != p.ID() {
+		return
+	}
+	p.changes[m.ID] = m
+	q := PaxiBFT.NewQuorum()
+	changes := make([]EpochChange, 0)
+	for id, c := range p.changes {
+		if c.Epoch == m.Epoch && c.Leader == m.Leader {
+			q.ACK(id)
+			changes = append(changes, c)
+		}
+	}
+	if !q.ByzantineMajority() {
+		return
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].ID < changes[j].ID })
+	n := NewEpoch{
+		Epoch:   m.Epoch,
+		ID:      p.ID(),
+		Leader:  m.Leader,
+		Changes: changes,
+	}
+	p.Broadcast(n)
+	p.HandleNewEpoch(n)
+}
+
+// HandleNewEpoch fills the sequence numbers of suspected leader left in the epoch before the new one
+// with the batches prepared by any of 2f+1 replicas, or empty batches, and removes the leader
+func (p *Mir) HandleNewEpoch(m NewEpoch) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, p.ID())
+	if _, removed := p.removed[m.Leader]; removed {
+		return
+	}
+	if m.ID != p.primary(m.Leader) {
+		log.Errorf("%v is not the primary to remove %v", m.ID, m.Leader)
+		return
+	}
+	q := PaxiBFT.NewQuorum()
+	start := (m.Epoch - 1) * *length
+	prepared := make(map[int]PrePrepare)
+	for _, c := range m.Changes {
+		if c.Epoch != m.Epoch || c.Leader != m.Leader {
+			log.Errorf("%v carries epoch change %v of another epoch", m, c)
+			return
+		}
+		q.ACK(c.ID)
+		if c.Executed > start {
+			start = c.Executed
+		}
+		for _, pp := range c.Prepared {
+			if _, ok := prepared[pp.Seq]; ok || epoch(pp.Seq) != m.Epoch-1 || p.leader(pp.Seq) != m.Leader || !bytes.Equal(digest(pp.Requests), pp.Digest) {
+				continue
+			}
+			prepared[pp.Seq] = pp
+		}
+	}
+	if !q.ByzantineMajority() {
+		log.Errorf("%v does not carry 2f+1 epoch changes", m)
+		return
+	}
+	// sequence numbers below start were executed by one of the 2f+1 and commit as usual
+	for s := start; epoch(s) < m.Epoch; s++ {
+		if s < p.execute || p.leader(s) != m.Leader {
+			continue
+		}
+		e := p.get(s)
+		if e.committed {
+			continue
+		}
+		e.requests = prepared[s].Requests
+		if e.requests == nil {
+			e.requests = make([]PaxiBFT.Request, 0)
+		}
+		e.digest = digest(e.requests)
+		e.prepared = true
+		e.committed = true
+	}
+	log.Infof("Replica %s removes leader %v from epoch %d", p.ID(), m.Leader, m.Epoch)
+	p.WAL().Put(walRemoved+string(m.Leader), m.Epoch)
+	p.removed[m.Leader] = m.Epoch
+	p.change = nil
+	p.changes = make(map[PaxiBFT.ID]EpochChange)
+	p.next = p.following(p.last)
+	// batches of the removed leader below start kept without vote commit as usual
+	for s, e := range p.log {
+		if e.requests != nil && !e.prepared && e.prepares[p.ID()] == nil {
+			p.prepare(s)
+		}
+	}
+	p.exec()
+}
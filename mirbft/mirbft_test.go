@@ -0,0 +1,280 @@
+package mirbft
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/salemmohammed/PaxiBFT"
+)
+
+var errIncomplete = errors.New("history incomplete")
+
+// cluster starts n replicas in simulation with configuration c, replica faulty censors every request,
+// they are closed at the end of test before the next cluster changes configuration
+func cluster(t *testing.T, c PaxiBFT.Config, port, n int, faulty PaxiBFT.ID) map[PaxiBFT.ID]*Replica {
+	PaxiBFT.Simulation()
+	c.Addrs = make(map[PaxiBFT.ID]string)
+	c.HTTPAddrs = make(map[PaxiBFT.ID]string)
+	for i := 1; i <= n; i++ {
+		id := PaxiBFT.NewID(1, i)
+		p := strconv.Itoa(port + i)
+		c.Addrs[id] = "chan://127.0.0.1:" + p
+		c.HTTPAddrs[id] = "http://127.0.0.1:" + p
+	}
+	PaxiBFT.SetConfig(c)
+
+	replicas := make(map[PaxiBFT.ID]*Replica)
+	t.Cleanup(func() {
+		for _, r := range replicas {
+			r.Close()
+		}
+	})
+	for id := range c.Addrs {
+		replicas[id] = NewReplica(id, func(p *Mir) { p.censor = p.ID() == faulty })
+		go replicas[id].Run()
+		err := PaxiBFT.Retry(func() error {
+			r, err := http.Get(c.HTTPAddrs[id] + "/history?key=0")
+			if err == nil {
+				r.Body.Close()
+			}
+			return err
+		}, 50, 10*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return replicas
+}
+
+// put writes value to key through replica id as client
+func put(client *http.Client, url string, id PaxiBFT.ID, key PaxiBFT.Key, value PaxiBFT.Value) error {
+	req, err := http.NewRequest(http.MethodPut, url+"/"+string(key), bytes.NewBuffer(value))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(PaxiBFT.HTTPClientID, string(id))
+	req.Header.Set(PaxiBFT.HTTPCommandID, "1")
+	rep, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	rep.Body.Close()
+	if rep.StatusCode != http.StatusOK {
+		return errors.New(rep.Status)
+	}
+	return nil
+}
+
+// clients finds three clients in every bucket
+func clients(p *Mir) map[int][]PaxiBFT.ID {
+	clients := make(map[int][]PaxiBFT.ID)
+	for k := 0; len(clients[0]) < 3 || len(clients[1]) < 3 || len(clients[2]) < 3 || len(clients[3]) < 3; k++ {
+		cid := PaxiBFT.ID("c" + strconv.Itoa(k))
+		b := p.bucket(&PaxiBFT.Request{Command: PaxiBFT.Command{ClientID: cid}})
+		clients[b] = append(clients[b], cid)
+	}
+	return clients
+}
+
+// puts writes one value to key 0 by three clients of every bucket, round robin through replicas ids
+func puts(t *testing.T, clients map[int][]PaxiBFT.ID, ids []PaxiBFT.ID) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	var wait sync.WaitGroup
+	i := 0
+	for b := 0; b < *buckets; b++ {
+		for _, cid := range clients[b][:3] {
+			to := ids[i%len(ids)]
+			wait.Add(1)
+			go func(cid PaxiBFT.ID, to PaxiBFT.ID, i int) {
+				defer wait.Done()
+				url := PaxiBFT.GetConfig().HTTPAddrs[to]
+				if err := put(client, url, cid, "0", PaxiBFT.Value("v"+strconv.Itoa(i))); err != nil {
+					t.Errorf("put of client %v to %v: %v", cid, to, err)
+				}
+			}(cid, to, i)
+			i++
+		}
+	}
+	wait.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+}
+
+// executed waits until every replica of ids executed n values of key 0 and checks they did in the same order
+func executed(t *testing.T, replicas map[PaxiBFT.ID]*Replica, ids []PaxiBFT.ID, n int) {
+	var history []PaxiBFT.Value
+	err := PaxiBFT.Retry(func() error {
+		for _, id := range ids {
+			if len(replicas[id].History("0")) < n {
+				return errIncomplete
+			}
+		}
+		return nil
+	}, 50, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		h := replicas[id].History("0")
+		if history == nil {
+			history = h
+		}
+		for i := range h {
+			if !bytes.Equal(h[i], history[i]) {
+				t.Fatalf("replica %v executed %.4s at %d, expected %.4s", id, h[i], i, history[i])
+			}
+		}
+	}
+}
+
+func TestMir(t *testing.T) {
+	*buckets = 4
+	*length = 8
+	c := PaxiBFT.MakeDefaultConfig()
+	c.MultiVersion = true
+	replicas := cluster(t, c, 20600, 4, "1.1")
+	ids := []PaxiBFT.ID{"1.1", "1.2", "1.3", "1.4"}
+
+	// three clients in every bucket, requests in bucket of faulty 1.1 wait for the epoch change
+	clients := clients(replicas["1.2"].Mir)
+	if owner := replicas["1.2"].owner(0, 0); owner != "1.1" {
+		t.Fatalf("bucket 0 is owned by %v in epoch 0", owner)
+	}
+	puts(t, clients, ids[1:])
+	executed(t, replicas, ids, 12)
+}
+
+func TestEpochChange(t *testing.T) {
+	*buckets = 4
+	*length = 8
+	// restored after the cluster is closed
+	t.Cleanup(func(t int) func() { return func() { *timeout = t } }(*timeout))
+	*timeout = 100
+	c := PaxiBFT.MakeDefaultConfig()
+	c.MultiVersion = true
+	replicas := cluster(t, c, 21200, 4, "")
+	ids := []PaxiBFT.ID{"1.2", "1.3", "1.4"}
+
+	// leader of sequence number 0 and bucket 0 crashes, replicas remove it from epoch 1
+	replicas["1.1"].Crash(0)
+	puts(t, clients(replicas["1.2"].Mir), ids)
+	executed(t, replicas, ids, 12)
+	for _, id := range ids {
+		// removed is read once no handle function runs
+		replicas[id].Close()
+		e, ok := replicas[id].removed["1.1"]
+		if !ok || e != 1 {
+			t.Errorf("replica %v removed 1.1 from epoch %d", id, e)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	*buckets = 4
+	*length = 8
+	c := PaxiBFT.MakeDefaultConfig()
+	c.MultiVersion = true
+	replicas := cluster(t, c, 21220, 4, "")
+	client := NewClient("")
+	client.Client.Timeout = 10 * time.Second
+
+	// concurrent puts take turns on replicas, each as the next request of its own session
+	var wait sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			if err := client.PutMUL("0", PaxiBFT.Value("v"+strconv.Itoa(i))); err != nil {
+				t.Errorf("put v%d: %v", i, err)
+			}
+		}(i)
+	}
+	wait.Wait()
+	if t.Failed() {
+		t.FailNow()
+	}
+	executed(t, replicas, []PaxiBFT.ID{"1.1", "1.2", "1.3", "1.4"}, 8)
+}
+
+func TestLagging(t *testing.T) {
+	*buckets = 4
+	*length = 8
+	// restored after the cluster is closed
+	t.Cleanup(func(t int) func() { return func() { *timeout = t } }(*timeout))
+	*timeout = 100
+	c := PaxiBFT.MakeDefaultConfig()
+	c.MultiVersion = true
+	replicas := cluster(t, c, 21240, 4, "")
+	ids := []PaxiBFT.ID{"1.1", "1.2", "1.3", "1.4"}
+
+	// 1.4 receives the batches of 1.1 late and suspects it alone, it executes them once the others committed them
+	slow := time.Now()
+	replicas["1.1"].Slow("1.4", 500, 1)
+	puts(t, clients(replicas["1.2"].Mir), ids[:3])
+	executed(t, replicas, ids, 12)
+	// messages delayed by the fault are delivered before the cluster closes
+	time.Sleep(time.Until(slow.Add(2 * time.Second)))
+	for _, id := range ids {
+		// removed is read once no handle function runs
+		replicas[id].Close()
+		if e, ok := replicas[id].removed["1.1"]; ok {
+			t.Errorf("replica %v removed 1.1 from epoch %d", id, e)
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	*buckets = 4
+	*length = 8
+	// restored after the cluster is closed
+	t.Cleanup(func(t int) func() { return func() { *timeout = t } }(*timeout))
+	*timeout = 100
+	c := PaxiBFT.MakeDefaultConfig()
+	c.WAL = PaxiBFT.StorageConfig{Engine: "file", Dir: t.TempDir(), Sync: "always"}
+	c.MultiVersion = true
+	replicas := cluster(t, c, 21260, 4, "")
+	ids := []PaxiBFT.ID{"1.2", "1.3", "1.4"}
+
+	// 1.1 crashes and is removed from epoch 1 before 1.4 restarts from its write-ahead log
+	replicas["1.1"].Crash(0)
+	puts(t, clients(replicas["1.2"].Mir), ids)
+	executed(t, replicas, ids, 12)
+	replicas["1.4"].Close()
+	flag.Set("recover", "true")
+	defer flag.Set("recover", "false")
+	r := NewReplica("1.4")
+	replicas["1.4"] = r
+	if e, ok := r.removed["1.1"]; !ok || e != 1 {
+		t.Errorf("recovered 1.4 removed 1.1 from epoch %d", e)
+	}
+	go r.Run()
+	err := PaxiBFT.Retry(func() error {
+		rep, err := http.Get(PaxiBFT.GetConfig().HTTPAddrs["1.4"] + "/history?key=0")
+		if err == nil {
+			rep.Body.Close()
+		}
+		return err
+	}, 50, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := r.History("0"); len(h) != 12 {
+		t.Fatalf("recovered history of 0 has %d values, expected 12", len(h))
+	}
+
+	// recovered replica keeps ordering requests of new clients
+	client := &http.Client{Timeout: 10 * time.Second}
+	for i, to := range ids {
+		if err := put(client, PaxiBFT.GetConfig().HTTPAddrs[to], PaxiBFT.ID("d"+strconv.Itoa(i)), "0", PaxiBFT.Value("w"+strconv.Itoa(i))); err != nil {
+			t.Errorf("put to %v: %v", to, err)
+		}
+	}
+	executed(t, replicas, ids, 15)
+}
@@ -0,0 +1,102 @@
+package mirbft
+
+import (
+	"encoding/gob"
+	"fmt"
+
+	"github.com/salemmohammed/PaxiBFT"
+)
+
+func init() {
+	gob.Register(Forward{})
+	gob.Register(PrePrepare{})
+	gob.Register(Prepare{})
+	gob.Register(Commit{})
+	gob.Register(EpochChange{})
+	gob.Register(NewEpoch{})
+	gob.Register(Resend{})
+}
+
+// Forward hands client request to the leader of its bucket
+type Forward struct {
+	ID      PaxiBFT.ID
+	Request PaxiBFT.Request
+}
+
+func (m Forward) String() string {
+	return fmt.Sprintf("Forward {ID %v, Request %v}", m.ID, m.Request)
+}
+
+// PrePrepare proposes a batch of requests from the buckets of leader at sequence number Seq
+type PrePrepare struct {
+	Seq      int
+	ID       PaxiBFT.ID
+	Requests []PaxiBFT.Request
+	Digest   []byte
+}
+
+func (m PrePrepare) String() string {
+	return fmt.Sprintf("PrePrepare {Seq %v, ID %v, Requests %d, Digest %x}", m.Seq, m.ID, len(m.Requests), m.Digest)
+}
+
+// Prepare message
+type Prepare struct {
+	Seq    int
+	ID     PaxiBFT.ID
+	Digest []byte
+}
+
+func (m Prepare) String() string {
+	return fmt.Sprintf("Prepare {Seq %v, ID %v, Digest %x}", m.Seq, m.ID, m.Digest)
+}
+
+// Commit message
+type Commit struct {
+	Seq    int
+	ID     PaxiBFT.ID
+	Digest []byte
+}
+
+func (m Commit) String() string {
+	return fmt.Sprintf("Commit {Seq %v, ID %v, Digest %x}", m.Seq, m.ID, m.Digest)
+}
+
+// EpochChange asks primary of Epoch to remove suspected Leader from it,
+// with the batches of Leader prepared by sender in the epoch before
+type EpochChange struct {
+	Epoch    int
+	ID       PaxiBFT.ID
+	Leader   PaxiBFT.ID
+	Executed int // next sequence number to execute at sender
+	Prepared []PrePrepare
+}
+
+func (m EpochChange) String() string {
+	return fmt.Sprintf("EpochChange {Epoch %v, ID %v, Leader %v, Executed %v, Prepared %d}", m.Epoch, m.ID, m.Leader, m.Executed, len(m.Prepared))
+}
+
+// NewEpoch removes Leader from Epoch on 2f+1 epoch changes
+type NewEpoch struct {
+	Epoch   int
+	ID      PaxiBFT.ID
+	Leader  PaxiBFT.ID
+	Changes []EpochChange
+}
+
+func (m NewEpoch) String() string {
+	return fmt.Sprintf("NewEpoch {Epoch %v, ID %v, Leader %v, Changes %d}", m.Epoch, m.ID, m.Leader, len(m.Changes))
+}
+
+// Resend asks replicas for their proposals and votes from sequence number Seq on again,
+// for replica ID that recovered and lost the ones sent to it before
+type Resend struct {
+	ID  PaxiBFT.ID
+	Seq int
+}
+
+func (m Resend) String() string {
+	return fmt.Sprintf("Resend {ID %v, Seq %v}", m.ID, m.Seq)
+}
+
+// tick is the local timeout checking execution progress
+type tick struct{}
@@ -0,0 +1,34 @@
+package mirbft
+
+import (
+	"github.com/salemmohammed/PaxiBFT"
+	"github.com/salemmohammed/PaxiBFT/log"
+)
+
+// Replica for one mir instance
+type Replica struct {
+	PaxiBFT.Node
+	*Mir
+}
+
+// NewReplica generates new mir replica
+func NewReplica(id PaxiBFT.ID, options ...func(*Mir)) *Replica {
+	r := new(Replica)
+	r.Node = PaxiBFT.NewNode(id)
+	r.Mir = NewMir(r, options...)
+	r.Register(PaxiBFT.Request{}, r.handleRequest)
+	r.Register(Forward{}, r.HandleForward)
+	r.Register(PrePrepare{}, r.HandlePrePrepare)
+	r.Register(Prepare{}, r.HandlePrepare)
+	r.Register(Commit{}, r.HandleCommit)
+	r.Register(EpochChange{}, r.HandleEpochChange)
+	r.Register(NewEpoch{}, r.HandleNewEpoch)
+	r.Register(Resend{}, r.HandleResend)
+	r.Register(tick{}, r.HandleTick)
+	return r
+}
+
+func (r *Replica) handleRequest(m PaxiBFT.Request) {
+	log.Debugf("Replica %s received %v\n", r.ID(), m)
+	r.Mir.HandleRequest(m)
+}
@@ -3,6 +3,7 @@ package PaxiBFT
 import (
 	"net/http"
 	"reflect"
+	"strconv"
 	"sync"
 
 	"github.com/salemmohammed/PaxiBFT/log"
@@ -12,7 +13,15 @@ import (
 // it includes networking, state machine and RESTful API server
 type Node interface {
 	Socket
-	Database
+	StateMachine
+	Read(Command) Value
+	Session(ID) (Session, bool)
+	History(Key) []Value
+	Checkpoint(slot int)
+	Recover() int
+	WAL() *WAL
+	Instance(ns string) Node
+	Namespace() string
 	ID() ID
 	Run()
 	Retry(r Request)
@@ -23,36 +32,142 @@ type Node interface {
 // node implements Node interface
 type node struct {
 	id ID
+	ns string // namespace of consensus instance, empty for the default one
+
+	host      *node            // node running the message loop and http server of instance, itself if default
+	instances map[string]*node // consensus instances hosted by namespace
+	api       http.Handler     // http api of instance served under its namespace
 
 	Socket
-	Database
+	*executor
 	MessageChan chan interface{}
 	handles     map[string]reflect.Value
 	server      *http.Server
+	wal         *WAL
+	done        chan struct{}  // closed to stop message loops
+	loops       sync.WaitGroup // running message loops
+	close       sync.Once
 
 	sync.RWMutex
-	forwards map[string]*Request
+	forwards map[string][]*Request // forwarded requests waiting for reply by client session
 }
 
 // NewNode creates a new Node object from configuration
 func NewNode(id ID) Node {
-	return &node{
+	return NewStateMachineNode(id, NewStateMachine(config.StateMachine, id))
+}
+
+// NewStateMachineNode creates a new Node object that replicates the given state machine
+func NewStateMachineNode(id ID, sm StateMachine) Node {
+	wal, err := OpenWAL(id)
+	if err != nil {
+		log.Fatalf("cannot open write-ahead log of node %v: %v", id, err)
+	}
+	if err := config.ValidateShards(); err != nil {
+		log.Fatal(err)
+	}
+	if wal.Recovering() && config.Storage.Engine == "file" {
+		// committed commands are executed again to rebuild the state machine
+		log.Fatal("recovery from write-ahead log needs the memory storage engine")
+	}
+	n := &node{
 		id:          id,
+		wal:         wal,
+		instances:   make(map[string]*node),
 		Socket:      NewSocket(id, config.Addrs),
-		Database:    NewDatabase(),
+		executor:    newExecutor(sm),
 		MessageChan: make(chan interface{}, config.ChanBufferSize),
 		handles:     make(map[string]reflect.Value),
-		forwards:    make(map[string]*Request),
+		forwards:    make(map[string][]*Request),
+		done:        make(chan struct{}),
 	}
+	n.host = n
+	return n
 }
 
 func (n *node) ID() ID {
 	return n.id
 }
 
+// WAL returns write-ahead log of node, nil if disabled
+func (n *node) WAL() *WAL {
+	return n.wal
+}
+
+// Close stops node as if its process crashed, releasing its address and http port for a restarted node.
+// Closing an instance only closes its write-ahead log.
+// Closing a node twice has no effect.
+func (n *node) Close() {
+	if n.host != n {
+		n.wal.Close()
+		return
+	}
+	n.close.Do(func() {
+		n.Socket.Crash(0)
+		n.RLock()
+		if n.server != nil {
+			n.server.Close()
+		}
+		n.RUnlock()
+		// no handle function runs once Close returns
+		close(n.done)
+		n.loops.Wait()
+		n.Socket.Close()
+		n.wal.Close()
+	})
+}
+
+// History returns value history of key if node replicates a Database
+func (n *node) History(k Key) []Value {
+	if db, ok := n.executor.StateMachine.(Database); ok {
+		return db.History(k)
+	}
+	return nil
+}
+
+// Checkpoint starts a checkpoint of database and client sessions after execution slot if node replicates a Database,
+// and persists it in write-ahead log once taken
+func (n *node) Checkpoint(slot int) {
+	db, ok := n.executor.StateMachine.(Database)
+	if !ok {
+		return
+	}
+	c := db.Checkpoint(slot, n.executor.copySessions())
+	go func() {
+		cp := <-c
+		log.Infof("node %v checkpoint at slot %d with %d keys and hash %x", n.id, cp.Slot, len(cp.Data), cp.Hash)
+		n.wal.PutCheckpoint(cp)
+	}()
+}
+
+// Recover restores database and client sessions from the checkpoint in write-ahead log of recovering node,
+// returns the slot of checkpoint, or -1 if there is none
+func (n *node) Recover() int {
+	db, ok := n.executor.StateMachine.(Database)
+	b := n.wal.checkpoint()
+	if !ok || !n.wal.Recovering() || b == nil {
+		return -1
+	}
+	if err := n.executor.restoreCheckpoint(db, b); err != nil {
+		// slots the checkpoint covers are gone from the log
+		log.Fatalf("cannot restore checkpoint of node %v from write-ahead log: %v", n.id, err)
+	}
+	return n.wal.Compacted()
+}
+
+// Send delivers message to self through message channel as if received from socket,
+// so that protocols can schedule local events such as timeouts
+func (n *node) Send(to ID, m interface{}) {
+	if to == n.id {
+		go func() { n.MessageChan <- n.wrap(m) }()
+		return
+	}
+	n.Socket.Send(to, m)
+}
+
 func (n *node) Retry(r Request) {
 	log.Debugf("node %v retry reqeust %v", n.id, r)
-	n.MessageChan <- r
+	n.MessageChan <- n.wrap(r)
 }
 
 // Register a handle function for each message type
@@ -63,15 +178,36 @@ func (n *node) Register(m interface{}, f interface{}) {
 		panic("register handle function error")
 	}
 	n.handles[t.String()] = fn
+	RegisterMessage(m)
 }
 
-// Run start and run the node
+// Run start and run the node, an instance runs with the node hosting it
 func (n *node) Run() {
+	if n.host != n {
+		log.Infof("node %v instance %s runs on its node", n.id, n.ns)
+		return
+	}
 	log.Infof("node %v start running", n.id)
-	if len(n.handles) > 0 {
+	if len(n.handles) > 0 || len(n.instances) > 0 {
+		n.loops.Add(1)
 		go n.handle()
 		go n.recv()
 	}
+	if len(config.Shards) > 0 {
+		// participants finish transactions of crashed coordinators
+		n.RLock()
+		nodes := []*node{n}
+		for _, i := range n.instances {
+			nodes = append(nodes, i)
+		}
+		n.RUnlock()
+		for _, i := range nodes {
+			if db, ok := i.executor.StateMachine.(Database); ok {
+				n.loops.Add(1)
+				go i.terminate(db)
+			}
+		}
+	}
 	n.http()
 }
 
@@ -79,34 +215,59 @@ func (n *node) Run() {
 func (n *node) recv() {
 	for {
 		m := n.Recv()
-		switch m := m.(type) {
+		i, msg := n.open(m)
+		if i == nil {
+			continue
+		}
+		switch msg := msg.(type) {
 		case Request:
-			m.c = make(chan Reply, 1)
+			msg.c = make(chan Reply, 1)
 			go func(r Request) {
-				n.Send(r.NodeID, <-r.c)
-			}(m)
-			n.MessageChan <- m
+				i.Send(r.NodeID, <-r.c)
+			}(msg)
+			n.MessageChan <- i.wrap(msg)
 			continue
 
 		case Reply:
-			n.RLock()
-			r := n.forwards[m.Command.String()]
-			log.Debugf("node %v received reply %v", n.id, m)
-			n.RUnlock()
-			r.Reply(m)
+			log.Debugf("node %v received reply %v", n.id, msg)
+			k := forwardKey(i.ns, msg.Command)
+			n.Lock()
+			requests := n.forwards[k]
+			delete(n.forwards, k)
+			n.Unlock()
+			if len(requests) == 0 {
+				log.Warningf("node %v received reply %v of no forwarded request", n.id, msg)
+			}
+			for _, r := range requests {
+				r.Reply(msg)
+			}
 			continue
 		}
 		n.MessageChan <- m
 	}
 }
 
-// handle receives messages from message channel and calls handle function using refection
+// handle receives messages from message channel and calls handle function
+// of their consensus instance using refection
 func (n *node) handle() {
+	defer n.loops.Done()
 	for {
-		msg := <-n.MessageChan
+		var m interface{}
+		select {
+		case m = <-n.MessageChan:
+		case <-n.done:
+			return
+		}
+		i, msg := n.open(m)
+		if i == nil {
+			continue
+		}
+		if r, ok := msg.(Request); ok && i.answer(r) {
+			continue
+		}
 		v := reflect.ValueOf(msg)
 		name := v.Type().String()
-		f, exists := n.handles[name]
+		f, exists := i.handles[name]
 		if !exists {
 			log.Fatalf("no registered handle function for message type %v", name)
 		}
@@ -114,6 +275,30 @@ func (n *node) handle() {
 	}
 }
 
+// answer replies to a request executed before from the client session table,
+// so retries and stale duplicates do not reach the protocol
+func (n *node) answer(r Request) bool {
+	if !tracked(r.Command) {
+		return false
+	}
+	s, ok := n.Session(r.Command.ClientID)
+	if !ok || r.Command.CommandID > s.CommandID {
+		return false
+	}
+	reply := Reply{
+		Command:    r.Command,
+		Properties: make(map[string]string),
+		Timestamp:  r.Timestamp,
+	}
+	if r.Command.CommandID == s.CommandID {
+		reply.Value = s.Reply
+	} else {
+		reply.Err = ErrStaleRequest
+	}
+	r.Reply(reply)
+	return true
+}
+
 /*
 func (n *node) Forward(id ID, m Request) {
 	key := m.Command.Key
@@ -165,8 +350,16 @@ func (n *node) Forward(id ID, m Request) {
 func (n *node) Forward(id ID, m Request) {
 	log.Debugf("Node %v forwarding %v to %s", n.ID(), m, id)
 	m.NodeID = n.id
-	n.Lock()
-	n.forwards[m.Command.String()] = &m
-	n.Unlock()
+	h := n.host
+	h.Lock()
+	k := forwardKey(n.ns, m.Command)
+	h.forwards[k] = append(h.forwards[k], &m)
+	h.Unlock()
 	n.Send(id, m)
 }
+
+// forwardKey identifies forwarded request by instance namespace and client session,
+// since commands of different clients may print the same
+func forwardKey(ns string, c Command) string {
+	return ns + "@" + string(c.ClientID) + "/" + strconv.Itoa(c.CommandID)
+}
@@ -3,6 +3,7 @@ import (
 	"encoding/gob"
 	"fmt"
 	"github.com/salemmohammed/PaxiBFT"
+	"time"
 )
 func init() {
 	gob.Register(P1a{})
@@ -10,6 +11,12 @@ func init() {
 	gob.Register(P2a{})
 	gob.Register(P2b{})
 	gob.Register(P3{})
+	// ids of paxos messages in the binary codec
+	PaxiBFT.RegisterBinary(16, P1a{})
+	PaxiBFT.RegisterBinary(17, P1b{})
+	PaxiBFT.RegisterBinary(18, P2a{})
+	PaxiBFT.RegisterBinary(19, P2b{})
+	PaxiBFT.RegisterBinary(20, P3{})
 }
 type P1a struct {
 	Ballot PaxiBFT.Ballot
@@ -28,9 +35,10 @@ type P1b struct {
 	Ballot PaxiBFT.Ballot
 	ID     PaxiBFT.ID               // from node id
 	Log    map[int]CommandBallot // uncommitted logs
+	Lease  time.Duration         // remaining lease granted to previous leader
 }
 func (m P1b) String() string {
-	return fmt.Sprintf("P1b {b=%v id=%s log=%v}", m.Ballot, m.ID, m.Log)
+	return fmt.Sprintf("P1b {b=%v id=%s log=%v lease=%v}", m.Ballot, m.ID, m.Log, m.Lease)
 }
 type P2a struct {
 	Ballot  PaxiBFT.Ballot
@@ -56,3 +64,68 @@ type P3 struct {
 func (m P3) String() string {
 	return fmt.Sprintf("P3 {b=%v s=%d cmd=%v}", m.Ballot, m.Slot, m.Command)
 }
+// Activate is sent by a leader to itself once leases granted to previous leaders expired
+type Activate struct {
+	Ballot PaxiBFT.Ballot
+}
+func (m Activate) String() string {
+	return fmt.Sprintf("Activate {b=%v}", m.Ballot)
+}
+func (m P1a) WriteBinary(w *PaxiBFT.BinaryWriter) {
+	w.Uint(uint64(m.Ballot))
+}
+func (m *P1a) ReadBinary(r *PaxiBFT.BinaryReader) {
+	m.Ballot = PaxiBFT.Ballot(r.Uint())
+}
+func (m P1b) WriteBinary(w *PaxiBFT.BinaryWriter) {
+	w.Uint(uint64(m.Ballot))
+	w.String(string(m.ID))
+	w.Uint(uint64(len(m.Log)))
+	for s, cb := range m.Log {
+		w.Int(int64(s))
+		w.Command(cb.Command)
+		w.Uint(uint64(cb.Ballot))
+	}
+	w.Int(int64(m.Lease))
+}
+func (m *P1b) ReadBinary(r *PaxiBFT.BinaryReader) {
+	m.Ballot = PaxiBFT.Ballot(r.Uint())
+	m.ID = PaxiBFT.ID(r.String())
+	n := r.Len()
+	m.Log = make(map[int]CommandBallot, n)
+	for i := 0; i < n && r.Err() == nil; i++ {
+		s := int(r.Int())
+		m.Log[s] = CommandBallot{Command: r.Command(), Ballot: PaxiBFT.Ballot(r.Uint())}
+	}
+	m.Lease = time.Duration(r.Int())
+}
+func (m P2a) WriteBinary(w *PaxiBFT.BinaryWriter) {
+	w.Uint(uint64(m.Ballot))
+	w.Int(int64(m.Slot))
+	w.Command(m.Command)
+}
+func (m *P2a) ReadBinary(r *PaxiBFT.BinaryReader) {
+	m.Ballot = PaxiBFT.Ballot(r.Uint())
+	m.Slot = int(r.Int())
+	m.Command = r.Command()
+}
+func (m P2b) WriteBinary(w *PaxiBFT.BinaryWriter) {
+	w.Uint(uint64(m.Ballot))
+	w.String(string(m.ID))
+	w.Int(int64(m.Slot))
+}
+func (m *P2b) ReadBinary(r *PaxiBFT.BinaryReader) {
+	m.Ballot = PaxiBFT.Ballot(r.Uint())
+	m.ID = PaxiBFT.ID(r.String())
+	m.Slot = int(r.Int())
+}
+func (m P3) WriteBinary(w *PaxiBFT.BinaryWriter) {
+	w.Uint(uint64(m.Ballot))
+	w.Int(int64(m.Slot))
+	w.Command(m.Command)
+}
+func (m *P3) ReadBinary(r *PaxiBFT.BinaryReader) {
+	m.Ballot = PaxiBFT.Ballot(r.Uint())
+	m.Slot = int(r.Int())
+	m.Command = r.Command()
+}
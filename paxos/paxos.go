@@ -2,9 +2,22 @@ package paxos
 
 import (
 	"github.com/salemmohammed/PaxiBFT"
+	"github.com/salemmohammed/PaxiBFT/log"
 	"strconv"
+	"strings"
 	"time"
 )
+
+const walBallot = "ballot"
+const walSlot = "slot/"
+
+// record is the accepted proposal of a slot kept in write-ahead log
+type record struct {
+	Ballot  PaxiBFT.Ballot
+	Command PaxiBFT.Command
+	Commit  bool
+}
+
 type entry struct {
 	ballot    PaxiBFT.Ballot
 	command   PaxiBFT.Command
@@ -27,21 +40,39 @@ type Paxos struct {
 	quorum   *PaxiBFT.Quorum    // phase 1 quorum
 	requests []*PaxiBFT.Request // phase 1 pending requests
 
+	lease      time.Duration              // leader lease granted with every P2b
+	drift      time.Duration              // maximum clock drift
+	leases     map[PaxiBFT.ID]time.Time   // lease expiry granted by each acceptor to this leader
+	leaseSlot  int                        // slots up to leaseSlot must be executed before reading with lease
+	leaseWait  time.Time                  // leases granted to previous leader expire by this time
+	waiting    PaxiBFT.Ballot             // ballot of phase 1 quorum waiting for leases to expire
+	grant      PaxiBFT.Ballot             // ballot this acceptor granted its lease to
+	grantUntil time.Time                  // lease granted by this acceptor expires at
+
 	Q1              func(*PaxiBFT.Quorum) bool
 	Q2              func(*PaxiBFT.Quorum) bool
 	ReplyWhenCommit bool
 }
 
 // NewPaxos creates new paxos instance
+// Q1 and Q2 are resolved from the quorum systems in config
 func NewPaxos(n PaxiBFT.Node, options ...func(*Paxos)) *Paxos {
+	q := PaxiBFT.GetConfig().Quorum
+	if err := q.Validate(); err != nil {
+		log.Fatal(err)
+	}
 	p := &Paxos{
 		Node:            n,
 		log:             make(map[int]*entry, PaxiBFT.GetConfig().BufferSize),
 		slot:            -1,
 		quorum:          PaxiBFT.NewQuorum(),
 		requests:        make([]*PaxiBFT.Request, 0),
-		Q1:              func(q *PaxiBFT.Quorum) bool { return q.Majority() },
-		Q2:              func(q *PaxiBFT.Quorum) bool { return q.Majority() },
+		lease:           time.Duration(PaxiBFT.GetConfig().Lease) * time.Millisecond,
+		drift:           time.Duration(PaxiBFT.GetConfig().MaxDrift) * time.Millisecond,
+		leases:          make(map[PaxiBFT.ID]time.Time),
+		leaseSlot:       -1,
+		Q1:              q.Phase1(),
+		Q2:              q.Phase2(),
 		ReplyWhenCommit: false,
 	}
 
@@ -49,9 +80,50 @@ func NewPaxos(n PaxiBFT.Node, options ...func(*Paxos)) *Paxos {
 		opt(p)
 	}
 
+	// accepted proposals of slots a checkpoint covers are deleted
+	n.WAL().Compact(walSlot)
+	if n.WAL().Recovering() {
+		p.recover()
+	}
+
 	return p
 }
 
+// recover restores promised ballot and accepted proposals from write-ahead log,
+// restores the last checkpoint and executes the committed slots after it again to rebuild the state machine
+func (p *Paxos) recover() {
+	w := p.WAL()
+	w.Get(walBallot, &p.ballot)
+	if s := p.Recover(); s >= 0 {
+		p.execute = s + 1
+		p.slot = s
+	}
+	for _, k := range w.Keys(walSlot) {
+		s, err := strconv.Atoi(strings.TrimPrefix(k, walSlot))
+		if err != nil || s < p.execute {
+			continue
+		}
+		var r record
+		if !w.Get(k, &r) {
+			continue
+		}
+		p.log[s] = &entry{
+			ballot:  r.Ballot,
+			command: r.Command,
+			commit:  r.Commit,
+		}
+		p.slot = PaxiBFT.Max(p.slot, s)
+	}
+	p.exec()
+	log.Infof("Replica %s recovered ballot %v, %d slots and executed up to %d", p.ID(), p.ballot, p.slot+1, p.execute)
+}
+
+// persist writes the entry of slot s to write-ahead log
+func (p *Paxos) persist(s int) {
+	e := p.log[s]
+	p.WAL().Put(walSlot+strconv.Itoa(s), record{e.ballot, e.command, e.commit})
+}
+
 // IsLeader indecates if this node is current leader
 func (p *Paxos) IsLeader() bool {
 	return p.active || p.ballot.ID() == p.ID()
@@ -77,6 +149,22 @@ func (p *Paxos) SetBallot(b PaxiBFT.Ballot) {
 	p.ballot = b
 }
 
+// Lease indicates if this leader holds a valid lease from a phase-2 quorum,
+// so that no other leader can commit new values and reads can be served locally
+func (p *Paxos) Lease() bool {
+	if p.lease == 0 || !p.active || p.execute <= p.leaseSlot {
+		return false
+	}
+	q := PaxiBFT.NewQuorum()
+	now := time.Now()
+	for id, t := range p.leases {
+		if now.Before(t) {
+			q.ACK(id)
+		}
+	}
+	return p.Q2(q)
+}
+
 // HandleRequest handles request and start phase 1 or phase 2
 func (p *Paxos) HandleRequest(r PaxiBFT.Request) {
 	// log.Debugf("Replica %s received %v\n", p.ID(), r)
@@ -99,6 +187,9 @@ func (p *Paxos) P1a() {
 	p.ballot.Next(p.ID())
 	p.quorum.Reset()
 	p.quorum.ACK(p.ID())
+	p.leases = make(map[PaxiBFT.ID]time.Time)
+	p.leaseWait = p.grantUntil
+	p.WAL().Put(walBallot, p.ballot)
 	p.Broadcast(P1a{Ballot: p.ballot})
 }
 
@@ -113,6 +204,8 @@ func (p *Paxos) P2a(r *PaxiBFT.Request) {
 		timestamp: time.Now(),
 	}
 	p.log[p.slot].quorum.ACK(p.ID())
+	p.renew(p.ID(), p.log[p.slot].timestamp)
+	p.persist(p.slot)
 	m := P2a{
 		Ballot:  p.ballot,
 		Slot:    p.slot,
@@ -129,6 +222,12 @@ func (p *Paxos) P2a(r *PaxiBFT.Request) {
 func (p *Paxos) HandleP1a(m P1a) {
 	// log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.Ballot.ID(), m, p.ID())
 
+	// remaining lease this acceptor granted to some other leader
+	var lease time.Duration
+	if m.Ballot != p.grant {
+		lease = time.Until(p.grantUntil)
+	}
+
 	// new leader
 	if m.Ballot > p.ballot {
 		p.ballot = m.Ballot
@@ -149,10 +248,12 @@ func (p *Paxos) HandleP1a(m P1a) {
 		l[s] = CommandBallot{p.log[s].command, p.log[s].ballot}
 	}
 
+	p.WAL().Put(walBallot, p.ballot)
 	p.Send(m.Ballot.ID(), P1b{
 		Ballot: p.ballot,
 		ID:     p.ID(),
 		Log:    l,
+		Lease:  lease,
 	})
 }
 
@@ -196,30 +297,63 @@ func (p *Paxos) HandleP1b(m P1b) {
 	// ack message
 	if m.Ballot.ID() == p.ID() && m.Ballot == p.ballot {
 		p.quorum.ACK(m.ID)
-		if p.Q1(p.quorum) {
-			p.active = true
-			// propose any uncommitted entries
-			for i := p.execute; i <= p.slot; i++ {
-				// TODO nil gap?
-				if p.log[i] == nil || p.log[i].commit {
-					continue
-				}
-				p.log[i].ballot = p.ballot
-				p.log[i].quorum = PaxiBFT.NewQuorum()
-				p.log[i].quorum.ACK(p.ID())
-				p.Broadcast(P2a{
-					Ballot:  p.ballot,
-					Slot:    i,
-					Command: p.log[i].command,
-				})
-			}
-			// propose new commands
-			for _, req := range p.requests {
-				p.P2a(req)
-			}
-			p.requests = make([]*PaxiBFT.Request, 0)
+		if t := time.Now().Add(m.Lease); m.Lease > 0 && t.After(p.leaseWait) {
+			p.leaseWait = t
+		}
+		if p.Q1(p.quorum) && p.waiting != p.ballot {
+			p.activate()
+		}
+	}
+}
+
+// activate becomes leader of current ballot once its phase 1 quorum is formed.
+// Phase 1 quorum intersects every lease quorum of previous leader, so no value is proposed
+// until all of those leases run out, waiting without blocking the message loop.
+func (p *Paxos) activate() {
+	if wait := time.Until(p.leaseWait); wait > 0 {
+		p.waiting = p.ballot
+		m := Activate{Ballot: p.ballot}
+		time.AfterFunc(wait+p.drift, func() { p.Send(p.ID(), m) })
+		return
+	}
+	p.lead()
+}
+
+// HandleActivate handles Activate message, scheduled again if a later P1b reported a longer lease
+func (p *Paxos) HandleActivate(m Activate) {
+	if m.Ballot != p.ballot || p.active {
+		return
+	}
+	p.activate()
+}
+
+// lead activates the leader of current ballot, proposing entries learned in phase 1 and pending requests
+func (p *Paxos) lead() {
+	p.leaseSlot = p.slot
+	p.active = true
+	// propose any uncommitted entries
+	for i := p.execute; i <= p.slot; i++ {
+		// TODO nil gap?
+		if p.log[i] == nil || p.log[i].commit {
+			continue
 		}
+		p.log[i].ballot = p.ballot
+		p.log[i].quorum = PaxiBFT.NewQuorum()
+		p.log[i].quorum.ACK(p.ID())
+		p.log[i].timestamp = time.Now()
+		p.renew(p.ID(), p.log[i].timestamp)
+		p.persist(i)
+		p.Broadcast(P2a{
+			Ballot:  p.ballot,
+			Slot:    i,
+			Command: p.log[i].command,
+		})
+	}
+	// propose new commands
+	for _, req := range p.requests {
+		p.P2a(req)
 	}
+	p.requests = make([]*PaxiBFT.Request, 0)
 }
 
 // HandleP2a handles P2a message
@@ -229,6 +363,9 @@ func (p *Paxos) HandleP2a(m P2a) {
 	if m.Ballot >= p.ballot {
 		p.ballot = m.Ballot
 		p.active = false
+		// grant lease to the leader of accepted ballot
+		p.grant = m.Ballot
+		p.grantUntil = time.Now().Add(p.lease)
 		// update slot number
 		p.slot = PaxiBFT.Max(p.slot, m.Slot)
 		// update entry
@@ -250,6 +387,8 @@ func (p *Paxos) HandleP2a(m P2a) {
 				commit:  false,
 			}
 		}
+		p.WAL().Put(walBallot, p.ballot)
+		p.persist(m.Slot)
 	}
 
 	p.Send(m.Ballot.ID(), P2b{
@@ -266,6 +405,10 @@ func (p *Paxos) HandleP2b(m P2b) {
 	if !exist || m.Ballot < entry.ballot || entry.commit {
 		return
 	}
+	// entry learned from phase 1 is not proposed by this node until it becomes leader
+	if entry.quorum == nil && m.Ballot.ID() == p.ID() {
+		return
+	}
 
 	// log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, p.ID())
 
@@ -281,8 +424,10 @@ func (p *Paxos) HandleP2b(m P2b) {
 	// if no q2 can be formed, this slot will be retried when received p2a or p3
 	if m.Ballot.ID() == p.ID() && m.Ballot == p.log[m.Slot].ballot {
 		p.log[m.Slot].quorum.ACK(m.ID)
+		p.renew(m.ID, p.log[m.Slot].timestamp)
 		if p.Q2(p.log[m.Slot].quorum) {
 			p.log[m.Slot].commit = true
+			p.persist(m.Slot)
 			p.Broadcast(P3{
 				Ballot:  m.Ballot,
 				Slot:    m.Slot,
@@ -322,6 +467,7 @@ func (p *Paxos) HandleP3(m P3) {
 
 	e.command = m.Command
 	e.commit = true
+	p.persist(m.Slot)
 
 	if p.ReplyWhenCommit {
 		if e.request != nil {
@@ -358,8 +504,26 @@ func (p *Paxos) exec() {
 		// TODO clean up the log periodically
 		delete(p.log, p.execute)
 		p.execute++
+		if i := PaxiBFT.GetConfig().Checkpoint; i > 0 && p.execute%i == 0 {
+			p.Checkpoint(p.execute - 1)
+		}
 	}
 }
+// renew extends lease granted by acceptor id, which started no earlier than the P2a sent at time t
+func (p *Paxos) renew(id PaxiBFT.ID, t time.Time) {
+	if p.lease == 0 {
+		return
+	}
+	if id == p.ID() {
+		p.grant = p.ballot
+		p.grantUntil = t.Add(p.lease)
+	}
+	expire := t.Add(p.lease - p.drift)
+	if expire.After(p.leases[id]) {
+		p.leases[id] = expire
+	}
+}
+
 func (p *Paxos) forward() {
 	for _, m := range p.requests {
 		p.Forward(p.ballot.ID(), *m)
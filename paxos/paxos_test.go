@@ -1,11 +1,547 @@
 package paxos
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
-	"github.com/ailidani/paxi"
+	"github.com/salemmohammed/PaxiBFT"
 )
 
+// cluster starts zones * nodes paxos replicas in simulation with configuration c,
+// they are closed at the end of test before the next cluster changes configuration
+func cluster(t *testing.T, c PaxiBFT.Config, port, zones, nodes int) map[PaxiBFT.ID]*Replica {
+	replicas := make(map[PaxiBFT.ID]*Replica)
+	t.Cleanup(func() {
+		for _, r := range replicas {
+			r.Close()
+		}
+	})
+	err := PaxiBFT.Cluster(c, port, zones, nodes, func(id PaxiBFT.ID) {
+		replicas[id] = NewReplica(id)
+		go replicas[id].Run()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return replicas
+}
+
 func TestPaxos(t *testing.T) {
-	paxi.Simulation()
+	tests := []struct {
+		quorum PaxiBFT.QuorumConfig
+		zones  int          // zones of 3 replicas, 3 if zero
+		p1     []PaxiBFT.ID // drops tolerated by both phases
+		p2     []PaxiBFT.ID // additional drops only tolerated by phase 2
+	}{
+		{
+			quorum: PaxiBFT.QuorumConfig{Type: "majority"},
+			p1:     []PaxiBFT.ID{"2.1", "2.2", "3.1", "3.2"},
+		},
+		{
+			quorum: PaxiBFT.QuorumConfig{Type: "grid"},
+			p1:     []PaxiBFT.ID{"2.1", "2.2", "3.1", "3.2"},
+			p2:     []PaxiBFT.ID{"2.3", "3.3"},
+		},
+		{
+			quorum: PaxiBFT.QuorumConfig{Type: "fgrid", Fz: 0},
+			p1:     []PaxiBFT.ID{"2.1", "3.1"},
+			p2:     []PaxiBFT.ID{"2.2", "2.3", "3.2", "3.3"},
+		},
+		{
+			quorum: PaxiBFT.QuorumConfig{Type: "fgrid", Fz: 1},
+			p1:     []PaxiBFT.ID{"2.1", "3.1", "3.2", "3.3"},
+		},
+		{
+			quorum: PaxiBFT.QuorumConfig{Type: "count", Q1: 7, Q2: 3},
+			p1:     []PaxiBFT.ID{"3.1", "3.2"},
+			p2:     []PaxiBFT.ID{"2.1", "2.2", "2.3", "3.3"},
+		},
+		{
+			// majorities of different zones do not intersect, group quorums need a single zone
+			quorum: PaxiBFT.QuorumConfig{Type: "group"},
+			zones:  1,
+			p1:     []PaxiBFT.ID{"1.3"},
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(test.quorum.Type, func(t *testing.T) {
+			c := PaxiBFT.MakeDefaultConfig()
+			c.Quorum = test.quorum
+			zones := test.zones
+			if zones == 0 {
+				zones = 3
+			}
+			cluster(t, c, 20000+i*10, zones, 3)
+			client := PaxiBFT.NewHTTPClient("")
+			client.Client.Timeout = 5 * time.Second
+			key := PaxiBFT.Key(strconv.Itoa(i))
+			v1 := PaxiBFT.Value("v1")
+			v2 := PaxiBFT.Value("v2")
+
+			// phase 1 and phase 2 with leader 1.1
+			for _, id := range test.p1 {
+				client.Drop("1.1", id, 10)
+			}
+			_, _, err := client.RESTPut("1.1", key, v1)
+			if err != nil {
+				t.Fatalf("%+v phase 1: %v", test.quorum, err)
+			}
+
+			// phase 2 only
+			for _, id := range test.p2 {
+				client.Drop("1.1", id, 10)
+			}
+			v, _, err := client.RESTPut("1.1", key, v2)
+			if err != nil {
+				t.Fatalf("%+v phase 2: %v", test.quorum, err)
+			}
+			if !bytes.HasPrefix(v, v1) {
+				t.Errorf("%+v expect previous value %s, got %.2s", test.quorum, v1, v)
+			}
+		})
+	}
+}
+
+func TestLease(t *testing.T) {
+	*ephemeralLeader = true
+	defer func() { *ephemeralLeader = false }()
+
+	c := PaxiBFT.MakeDefaultConfig()
+	c.Lease = 500
+	c.MaxDrift = 50
+
+	key := PaxiBFT.Key("1")
+	v1 := PaxiBFT.Value("v1")
+	v2 := PaxiBFT.Value("v2")
+
+	t.Run("slow", func(t *testing.T) {
+		// old leader 1.1 learns about new leader 1.2 late
+		replicas := cluster(t, c, 20100, 1, 3)
+		client := PaxiBFT.NewHTTPClient("")
+		client.Client.Timeout = 2 * time.Second
+
+		if _, _, err := client.RESTPut("1.1", key, v1); err != nil {
+			t.Fatal(err)
+		}
+		v, meta, err := client.RESTPut("1.1", key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta[HTTPHeaderLease] != "true" || !bytes.HasPrefix(v, v1) {
+			t.Fatalf("expect lease read of %s, got %.2s lease=%s", v1, v, meta[HTTPHeaderLease])
+		}
+
+		replicas["1.2"].Slow("1.1", 1000, 2)
+		replicas["1.3"].Slow("1.1", 1000, 2)
+		if _, _, err := client.RESTPut("1.2", key, v2); err != nil {
+			t.Fatal(err)
+		}
+		// the read waits for messages of new leader slowed down
+		client.Client.Timeout = 5 * time.Second
+		v, meta, err = client.RESTPut("1.1", key, nil)
+		if err != nil {
+			t.Fatalf("read from old leader: %v", err)
+		}
+		if !bytes.HasPrefix(v, v2) {
+			t.Errorf("stale read %.2s from old leader after %s committed, lease=%s", v, v2, meta[HTTPHeaderLease])
+		}
+	})
+
+	t.Run("crash", func(t *testing.T) {
+		// old leader 1.1 crashed while new leader 1.2 takes over
+		replicas := cluster(t, c, 20200, 1, 3)
+		client := PaxiBFT.NewHTTPClient("")
+		client.Client.Timeout = 2 * time.Second
+
+		if _, _, err := client.RESTPut("1.1", key, v1); err != nil {
+			t.Fatal(err)
+		}
+		replicas["1.1"].Crash(5)
+		if _, _, err := client.RESTPut("1.2", key, v2); err != nil {
+			t.Fatal(err)
+		}
+		v, meta, err := client.RESTPut("1.2", key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta[HTTPHeaderLease] != "true" || !bytes.HasPrefix(v, v2) {
+			t.Errorf("expect lease read of %s from new leader, got %.2s lease=%s", v2, v, meta[HTTPHeaderLease])
+		}
+		// crashed leader cannot reach a quorum, it fails the read rather than answer a stale value
+		v, meta, err = client.RESTPut("1.1", key, nil)
+		if err == nil && !bytes.HasPrefix(v, v2) {
+			t.Errorf("stale read %.2s from crashed leader after %s committed, lease=%s", v, v2, meta[HTTPHeaderLease])
+		}
+	})
+}
+
+func TestTxn(t *testing.T) {
+	cluster(t, PaxiBFT.MakeDefaultConfig(), 20300, 1, 3)
+	client := PaxiBFT.NewHTTPClient("1.1")
+	client.Client.Timeout = 2 * time.Second
+
+	tr, err := client.Txn([]PaxiBFT.Command{
+		{Key: "a", Value: PaxiBFT.Value("1")},
+		{Key: "b", Value: PaxiBFT.Value("2")},
+		{Key: "a"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tr.OK || len(tr.Commands) != 3 || !bytes.HasPrefix(tr.Commands[2].Value, PaxiBFT.Value("1")) {
+		t.Fatalf("transaction reply %+v does not read its own write", tr)
+	}
+
+	kvs, err := client.Scan("a", "c", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 2 || kvs[0].Key != "a" || kvs[1].Key != "b" {
+		t.Errorf("scan [a, c) = %v, expected keys a and b", kvs)
+	}
+}
+
+func TestConditional(t *testing.T) {
+	cluster(t, PaxiBFT.MakeDefaultConfig(), 20400, 1, 3)
+	client := PaxiBFT.NewHTTPClient("1.1")
+	client.Client.Timeout = 2 * time.Second
+
+	if _, err := client.CAS("lock", nil, PaxiBFT.Value("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CAS("lock", nil, PaxiBFT.Value("b")); err != PaxiBFT.ErrMismatch {
+		t.Errorf("create-only put of existing key returned %v", err)
+	}
+	if _, err := client.CAS("lock", PaxiBFT.Value("a"), PaxiBFT.Value("b")); err != nil {
+		t.Errorf("compare-and-swap with expected value: %v", err)
+	}
+
+	client.Increment("n", 2)
+	v, err := client.Increment("n", 3)
+	if err != nil || string(v) != "2" {
+		t.Errorf("increment returned %s, %v, expected 2", v, err)
+	}
+
+	v, err = client.Delete("n")
+	if err != nil || string(v) != "5" {
+		t.Errorf("delete returned %s, %v, expected 5", v, err)
+	}
+
+	// increment of a value that is not an integer fails and leaves it as it is
+	if v, err := client.Increment("lock", 1); err != PaxiBFT.ErrNotInteger || !bytes.HasPrefix(v, PaxiBFT.Value("b")) {
+		t.Errorf("increment of non-integer returned %.1s, %v", v, err)
+	}
+	if _, err := client.CAS("lock", PaxiBFT.Value("b"), PaxiBFT.Value("c")); err != nil {
+		t.Errorf("value changed by failed increment: %v", err)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	c := PaxiBFT.MakeDefaultConfig()
+	c.WAL = PaxiBFT.StorageConfig{Engine: "file", Dir: t.TempDir(), Sync: "always"}
+	c.MultiVersion = true
+	c.Checkpoint = 2
+	replicas := cluster(t, c, 20500, 1, 3)
+	client := PaxiBFT.NewHTTPClient("1.1")
+	client.Client.Timeout = 2 * time.Second
+
+	if err := client.Put("a", PaxiBFT.Value("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Put("a", PaxiBFT.Value("2")); err != nil {
+		t.Fatal(err)
+	}
+	err := PaxiBFT.Retry(func() error {
+		if len(replicas["1.3"].History("a")) < 2 {
+			return errors.New("1.3 has not executed both puts")
+		}
+		if replicas["1.3"].WAL().Compacted() < 1 {
+			return errors.New("1.3 has not persisted checkpoint")
+		}
+		return nil
+	}, 50, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// kill 1.3 and restart it from its write-ahead log
+	replicas["1.3"].Close()
+	flag.Set("recover", "true")
+	defer flag.Set("recover", "false")
+	r := NewReplica("1.3")
+	replicas["1.3"] = r
+	go r.Run()
+	if r.Ballot() != replicas["1.1"].Ballot() {
+		t.Errorf("recovered ballot %v, expected %v", r.Ballot(), replicas["1.1"].Ballot())
+	}
+	// both puts are restored from the checkpoint, which keeps values without their history
+	if v := r.Read(PaxiBFT.Command{Key: "a"}); !bytes.HasPrefix(v, PaxiBFT.Value("2")) {
+		t.Errorf("recovered value of a = %.2s, expected 2", v)
+	}
+	if k := r.WAL().Keys(walSlot); len(k) != 0 {
+		t.Errorf("slots %v covered by checkpoint are left in write-ahead log", k)
+	}
+
+	// recovered replica keeps accepting from the leader
+	if err := client.Put("b", PaxiBFT.Value("3")); err != nil {
+		t.Fatal(err)
+	}
+	err = PaxiBFT.Retry(func() error {
+		if len(r.History("b")) == 0 {
+			return errors.New("recovered 1.3 has not executed new put")
+		}
+		return nil
+	}, 50, 10*time.Millisecond)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShards(t *testing.T) {
+	c := PaxiBFT.MakeDefaultConfig()
+	c.MultiVersion = true
+	c.Shards = map[string][]PaxiBFT.ID{
+		"a": {"1.1", "2.1", "3.1"},
+		"b": {"1.2", "2.2", "3.2"},
+	}
+	replicas := cluster(t, c, 20600, 3, 2)
+	client := PaxiBFT.NewHTTPClient("1.1")
+	client.Client.Timeout = 2 * time.Second
+
+	keys := make(map[string][]PaxiBFT.Key)
+	for i := 0; i < 20; i++ {
+		k := PaxiBFT.Key(strconv.Itoa(i))
+		if err := client.Put(k, PaxiBFT.Value("v")); err != nil {
+			t.Fatal(err)
+		}
+		shard := client.Router.Route(k)
+		keys[shard] = append(keys[shard], k)
+	}
+	if len(keys["a"]) == 0 || len(keys["b"]) == 0 {
+		t.Fatalf("keys are not spread over shards: %v", keys)
+	}
+
+	// each group orders only the keys of its shard
+	err := PaxiBFT.Retry(func() error {
+		for shard, ks := range keys {
+			for id, r := range replicas {
+				owner := c.Shard(id) == shard
+				if h := r.History(ks[0]); owner != (len(h) > 0) {
+					return fmt.Errorf("replica %s of shard %s has history %d of key %s in shard %s", id, c.Shard(id), len(h), ks[0], shard)
+				}
+			}
+		}
+		return nil
+	}, 50, 10*time.Millisecond)
+	if err != nil {
+		t.Error(err)
+	}
+
+	kvs, err := client.Scan("", "", 0)
+	if err != nil || len(kvs) != 20 {
+		t.Errorf("scan over shards returned %d keys, %v", len(kvs), err)
+	}
+}
+
+func TestCrossShard(t *testing.T) {
+	// replicas take over from a crashed leader
+	*ephemeralLeader = true
+	defer func() { *ephemeralLeader = false }()
+	flag.Set("orphan_timeout", "500")
+	defer flag.Set("orphan_timeout", "5000")
+
+	c := PaxiBFT.MakeDefaultConfig()
+	c.Shards = map[string][]PaxiBFT.ID{
+		"a": {"1.1", "2.1", "3.1"},
+		"b": {"1.2", "2.2", "3.2"},
+	}
+	replicas := cluster(t, c, 20700, 3, 2)
+	client := PaxiBFT.NewHTTPClient("1.1")
+	client.Client.Timeout = 15 * time.Second
+
+	// one key of each shard
+	var x, y PaxiBFT.Key
+	for i := 0; x == "" || y == ""; i++ {
+		k := PaxiBFT.Key(strconv.Itoa(i))
+		if client.Router.Route(k) == "a" {
+			x = k
+		} else {
+			y = k
+		}
+	}
+	get := func(k PaxiBFT.Key) string {
+		v, _, err := client.RESTPut("", k, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(v)
+	}
+
+	tr, err := client.Txn([]PaxiBFT.Command{
+		{Key: x, Value: PaxiBFT.Value("1")},
+		{Key: y, Value: PaxiBFT.Value("1")},
+	})
+	if err != nil || !tr.OK {
+		t.Fatalf("transaction over shards: %+v, %v", tr, err)
+	}
+	if get(x) != "1" || get(y) != "1" {
+		t.Fatalf("committed transaction wrote x=%s y=%s", get(x), get(y))
+	}
+
+	// failed compare-and-swap in shard b aborts the write in shard a
+	_, err = client.Txn([]PaxiBFT.Command{
+		{Key: x, Value: PaxiBFT.Value("2")},
+		PaxiBFT.CAS(y, PaxiBFT.Value("0"), PaxiBFT.Value("2")),
+	})
+	if err != PaxiBFT.ErrAborted {
+		t.Errorf("transaction with failed precondition returned %v", err)
+	}
+	if get(x) != "1" || get(y) != "1" {
+		t.Errorf("aborted transaction wrote x=%s y=%s", get(x), get(y))
+	}
+
+	// leader of shard b crashes during the transaction, which commits in both shards under a new leader
+	leader := replicas["1.2"].Leader()
+	replicas[leader].Crash(3)
+	tr, err = client.Txn([]PaxiBFT.Command{
+		{Key: x, Value: PaxiBFT.Value("3")},
+		PaxiBFT.CAS(y, PaxiBFT.Value("1"), PaxiBFT.Value("3")),
+	})
+	if err != nil || !tr.OK {
+		t.Fatalf("transaction over crashed leader: %+v, %v", tr, err)
+	}
+	// read through a replica that stayed up, the crashed one may lag behind
+	up := c.Shards["b"][0]
+	if up == leader {
+		up = c.Shards["b"][1]
+	}
+	if v, _, err := client.RESTPut(up, y, nil); err != nil || string(v) != "3" {
+		t.Errorf("transaction over crashed leader wrote y=%s, %v", v, err)
+	}
+	if get(x) != "3" {
+		t.Errorf("transaction over crashed leader wrote x=%s", get(x))
+	}
+
+	// steps of transactions coordinated by shard a posted to shard b as if by a coordinator
+	post := func(cmd PaxiBFT.Command) int {
+		b, _ := json.Marshal(cmd)
+		r, err := http.Post(PaxiBFT.GetConfig().HTTPAddrs[up]+"/", "application/json", bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Body.Close()
+		return r.StatusCode
+	}
+	prepare := func(id PaxiBFT.Key, value string) {
+		cmd := PaxiBFT.Prepare(id, []PaxiBFT.Command{{Key: y, Value: PaxiBFT.Value(value)}})
+		cmd.ClientID, cmd.CommandID = PaxiBFT.ID(id+"@b"), 1
+		if status := post(cmd); status != http.StatusOK {
+			t.Fatalf("prepare of %v replied %d", id, status)
+		}
+	}
+	if status := post(PaxiBFT.Decide("a/forged/1", PaxiBFT.Committed)); status != http.StatusForbidden {
+		t.Errorf("shard b recorded outcome of transaction of shard a, replied %d", status)
+	}
+
+	// commit that shard a never decided aborts the transaction
+	prepare("a/forged/1", "4")
+	post(PaxiBFT.Command{Key: "a/forged/1", Op: PaxiBFT.OpCommit})
+	if v, _, err := client.RESTPut(up, y, PaxiBFT.Value("5")); err != nil || string(v) != "3" {
+		t.Errorf("write after forged commit returned %s, %v", v, err)
+	}
+
+	// transaction whose coordinator crashed after prepare locks its key until participants abort it
+	prepare("a/orphan/1", "6")
+	if _, _, err := client.RESTPut(up, y, PaxiBFT.Value("7")); err != PaxiBFT.ErrLocked {
+		t.Errorf("write to locked key returned %v", err)
+	}
+	err = PaxiBFT.Retry(func() error {
+		_, _, err := client.RESTPut(up, y, PaxiBFT.Value("7"))
+		return err
+	}, 50, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("orphaned transaction not finished: %v", err)
+	}
+	if v, _, err := client.RESTPut(up, y, nil); err != nil || !bytes.HasPrefix(v, []byte("7\x00")) {
+		t.Errorf("write after orphaned transaction aborted wrote y=%.2s, %v", v, err)
+	}
+}
+
+func TestInstances(t *testing.T) {
+	c := PaxiBFT.MakeDefaultConfig()
+	c.MultiVersion = true
+	replicas := cluster(t, c, 20800, 3, 1)
+	for _, r := range replicas {
+		NewInstance(r.Instance("a"))
+		NewInstance(r.Instance("b"))
+	}
+	clients := map[string]*PaxiBFT.HTTPClient{}
+	for _, ns := range []string{"", "a", "b"} {
+		clients[ns] = PaxiBFT.NewHTTPClient("1.1")
+		clients[ns].Namespace = ns
+		clients[ns].Client.Timeout = 5 * time.Second
+	}
+
+	// every instance keeps its own log and database over the same nodes
+	for ns, client := range clients {
+		if err := client.Put("k", PaxiBFT.Value(ns+"1")); err != nil {
+			t.Fatalf("put in instance %q: %v", ns, err)
+		}
+	}
+	for ns, client := range clients {
+		v, _, err := client.RESTPut("", "k", nil)
+		if err != nil || string(bytes.TrimRight(v, "\x00")) != ns+"1" {
+			t.Errorf("instance %q read %q, %v", ns, bytes.TrimRight(v, "\x00"), err)
+		}
+	}
+	err := PaxiBFT.Retry(func() error {
+		for id, r := range replicas {
+			for ns := range clients {
+				if h := r.Instance(ns).History("k"); len(h) != 1 {
+					return fmt.Errorf("replica %s instance %q has history %d of key", id, ns, len(h))
+				}
+			}
+		}
+		return nil
+	}, 50, 10*time.Millisecond)
+	if err != nil {
+		t.Error(err)
+	}
+
+	client := PaxiBFT.NewHTTPClient("1.1")
+	client.Namespace = "c"
+	if err := client.Put("k", PaxiBFT.Value("c1")); err == nil {
+		t.Error("put in unknown instance succeeded")
+	}
+}
+
+func TestBinaryMessages(t *testing.T) {
+	cmd := PaxiBFT.Command{Key: "k", Value: PaxiBFT.Value("v"), ClientID: "1.1/1", CommandID: 2}
+	b := PaxiBFT.NewBallot(3, "1.2")
+	for _, send := range []interface{}{
+		P1a{Ballot: b},
+		P1b{Ballot: b, ID: "2.1", Log: map[int]CommandBallot{4: {Command: cmd, Ballot: b}}, Lease: time.Second},
+		P2a{Ballot: b, Slot: 4, Command: cmd},
+		P2b{Ballot: b, ID: "2.1", Slot: 4},
+		P3{Ballot: b, Slot: 4, Command: cmd},
+	} {
+		buf := new(bytes.Buffer)
+		c := PaxiBFT.NewCodec("binary", buf)
+		if err := c.Encode(&send); err != nil {
+			t.Fatal(err)
+		}
+		var recv interface{}
+		if err := c.Decode(&recv); err != nil || !reflect.DeepEqual(send, recv) {
+			t.Errorf("sent %v and received %v, %v", send, recv, err)
+		}
+	}
 }
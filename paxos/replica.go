@@ -16,6 +16,7 @@ const (
 	HTTPHeaderBallot     = "Ballot"
 	HTTPHeaderExecute    = "Execute"
 	HTTPHeaderInProgress = "Inprogress"
+	HTTPHeaderLease      = "Lease"
 )
 
 // Replica for one Paxos instance
@@ -26,8 +27,13 @@ type Replica struct {
 
 // NewReplica generates new Paxos replica
 func NewReplica(id PaxiBFT.ID) *Replica {
+	return NewInstance(PaxiBFT.NewNode(id))
+}
+
+// NewInstance generates Paxos replica on node n, which may be one of the consensus instances of a node
+func NewInstance(n PaxiBFT.Node) *Replica {
 	r := new(Replica)
-	r.Node = PaxiBFT.NewNode(id)
+	r.Node = n
 	r.Paxos = NewPaxos(r)
 	r.Register(PaxiBFT.Request{}, r.handleRequest)
 	r.Register(P1a{}, r.HandleP1a)
@@ -35,12 +41,28 @@ func NewReplica(id PaxiBFT.ID) *Replica {
 	r.Register(P2a{}, r.HandleP2a)
 	r.Register(P2b{}, r.HandleP2b)
 	r.Register(P3{}, r.HandleP3)
+	r.Register(Activate{}, r.HandleActivate)
 	return r
 }
 
 func (r *Replica) handleRequest(m PaxiBFT.Request) {
 	log.Debugf("Replica %s received %v\n", r.ID(), m)
 
+	// leader with valid lease reads locally without phase 2
+	if m.Command.IsRead() && r.Paxos.Lease() {
+		reply := PaxiBFT.Reply{
+			Command:    m.Command,
+			Value:      r.Node.Read(m.Command),
+			Properties: make(map[string]string),
+			Timestamp:  time.Now().Unix(),
+		}
+		reply.Properties[HTTPHeaderBallot] = r.Paxos.ballot.String()
+		reply.Properties[HTTPHeaderExecute] = strconv.Itoa(r.Paxos.execute - 1)
+		reply.Properties[HTTPHeaderLease] = "true"
+		m.Reply(reply)
+		return
+	}
+
 	if m.Command.IsRead() && *read != "" {
 		v, inProgress := r.readInProgress(m)
 		reply := PaxiBFT.Reply{
@@ -73,11 +95,14 @@ func (r *Replica) readInProgress(m PaxiBFT.Request) (PaxiBFT.Value, bool) {
 	// is in progress
 	for i := r.Paxos.slot; i >= r.Paxos.execute; i-- {
 		entry, exist := r.Paxos.log[i]
-		if exist && entry.command.Key == m.Command.Key {
+		if exist && m.Command.Covers(entry.command.Key) {
+			if m.Command.Op != PaxiBFT.OpGetPut || entry.command.Op != PaxiBFT.OpGetPut {
+				return r.Node.Read(m.Command), true
+			}
 			return entry.command.Value, true
 		}
 	}
 
 	// not in progress key
-	return r.Node.Execute(m.Command), false
+	return r.Node.Read(m.Command), false
 }
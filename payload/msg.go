@@ -0,0 +1,39 @@
+package payload
+
+import (
+	"encoding/gob"
+	"fmt"
+
+	"github.com/salemmohammed/PaxiBFT"
+)
+
+func init() {
+	gob.Register(Fetch{})
+	gob.Register(Payload{})
+}
+
+// Fetch asks for the payload of digest
+type Fetch struct {
+	ID     PaxiBFT.ID
+	Digest []byte
+}
+
+func (m Fetch) String() string {
+	return fmt.Sprintf("Fetch {ID %v, Digest %x}", m.ID, m.Digest)
+}
+
+// Payload answers fetch with the command of digest
+type Payload struct {
+	ID      PaxiBFT.ID
+	Digest  []byte
+	Command PaxiBFT.Command
+}
+
+func (m Payload) String() string {
+	return fmt.Sprintf("Payload {ID %v, Digest %x, Command %v}", m.ID, m.Digest, m.Command)
+}
+
+// retry is a local timer event of a fetch
+type retry struct {
+	Digest string
+}
@@ -0,0 +1,176 @@
+package payload
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"flag"
+	"hash"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/salemmohammed/PaxiBFT"
+	"github.com/salemmohammed/PaxiBFT/log"
+)
+
+var timeout = flag.Int("fetch_timeout", 50, "time in ms to wait for a fetched payload before asking the next replica")
+
+// window is the number of payloads kept for execution and for fetches of other replicas
+const window = 1000
+
+// want is a payload being fetched
+type want struct {
+	targets []PaxiBFT.ID // replicas to ask in turn
+	next    int          // next target
+	waiting []func(PaxiBFT.Command)
+}
+
+// Store keeps payloads of commands by digest apart from consensus, so that protocols can order
+// digests only. Every replica puts the commands its clients send; a replica that orders a digest
+// it has no payload for fetches it from the proposer first, then from every other replica in
+// turn, and checks the answer against the digest before handing it to the protocol.
+type Store struct {
+	PaxiBFT.Node
+
+	ids      []PaxiBFT.ID // sorted node ids
+	payloads map[string]PaxiBFT.Command
+	order    []string // digests in order of arrival, oldest are dropped beyond window
+	wants    map[string]*want
+}
+
+// NewStore creates payload store over node
+func NewStore(n PaxiBFT.Node) *Store {
+	ids := PaxiBFT.GetConfig().IDs()
+	sort.Sort(PaxiBFT.IDs(ids))
+	s := &Store{
+		Node:     n,
+		ids:      ids,
+		payloads: make(map[string]PaxiBFT.Command),
+		wants:    make(map[string]*want),
+	}
+	n.Register(Fetch{}, s.handleFetch)
+	n.Register(Payload{}, s.handlePayload)
+	n.Register(retry{}, s.handleRetry)
+	return s
+}
+
+// Digest of command, including the client session it belongs to, so that the same write
+// of two clients or sent twice by one client is ordered and executed as two commands
+func Digest(cmd PaxiBFT.Command) []byte {
+	hasher := md5.New()
+	hasher.Write([]byte(strconv.Itoa(len(cmd.ClientID)) + ":" + string(cmd.ClientID)))
+	binary.Write(hasher, binary.BigEndian, int64(cmd.CommandID))
+	digest(hasher, cmd)
+	return hasher.Sum(nil)
+}
+
+func digest(hasher hash.Hash, cmd PaxiBFT.Command) {
+	hasher.Write([]byte{byte(cmd.Op)})
+	hasher.Write([]byte(cmd.Key))
+	hasher.Write(cmd.Value)
+	hasher.Write(cmd.Expect)
+	if cmd.IsScan() {
+		hasher.Write([]byte(cmd.To))
+		hasher.Write([]byte(strconv.Itoa(cmd.Limit)))
+	}
+	if cmd.IsTxn() || cmd.Op == PaxiBFT.OpPrepare {
+		hasher.Write([]byte("txn" + strconv.Itoa(len(cmd.Commands))))
+		for _, c := range cmd.Commands {
+			digest(hasher, c)
+		}
+	}
+}
+
+// Put keeps payload of command and returns its digest
+func (s *Store) Put(cmd PaxiBFT.Command) []byte {
+	d := Digest(cmd)
+	s.put(string(d), cmd)
+	return d
+}
+
+func (s *Store) put(d string, cmd PaxiBFT.Command) {
+	if _, ok := s.payloads[d]; !ok {
+		s.payloads[d] = cmd
+		s.order = append(s.order, d)
+		if len(s.order) > window {
+			delete(s.payloads, s.order[0])
+			s.order = s.order[1:]
+		}
+	}
+	if w, ok := s.wants[d]; ok {
+		delete(s.wants, d)
+		for _, f := range w.waiting {
+			f(cmd)
+		}
+	}
+}
+
+// Get returns payload of digest if present
+func (s *Store) Get(digest []byte) (PaxiBFT.Command, bool) {
+	cmd, ok := s.payloads[string(digest)]
+	return cmd, ok
+}
+
+// Wait calls f with the payload of digest once present, fetching it from replica from first
+func (s *Store) Wait(digest []byte, from PaxiBFT.ID, f func(PaxiBFT.Command)) {
+	d := string(digest)
+	if cmd, ok := s.payloads[d]; ok {
+		f(cmd)
+		return
+	}
+	if w, ok := s.wants[d]; ok {
+		w.waiting = append(w.waiting, f)
+		return
+	}
+	w := &want{waiting: []func(PaxiBFT.Command){f}}
+	if from != s.ID() {
+		w.targets = append(w.targets, from)
+	}
+	for _, id := range s.ids {
+		if id != s.ID() && id != from {
+			w.targets = append(w.targets, id)
+		}
+	}
+	s.wants[d] = w
+	s.fetch(d, w)
+}
+
+// fetch asks next target of want and retries after timeout
+func (s *Store) fetch(d string, w *want) {
+	if len(w.targets) == 0 {
+		return
+	}
+	to := w.targets[w.next%len(w.targets)]
+	w.next++
+	s.Send(to, Fetch{ID: s.ID(), Digest: []byte(d)})
+	time.AfterFunc(time.Duration(*timeout)*time.Millisecond, func() {
+		s.Send(s.ID(), retry{Digest: d})
+	})
+}
+
+func (s *Store) handleRetry(m retry) {
+	if w, ok := s.wants[m.Digest]; ok {
+		s.fetch(m.Digest, w)
+	}
+}
+
+func (s *Store) handleFetch(m Fetch) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, s.ID())
+	if cmd, ok := s.payloads[string(m.Digest)]; ok {
+		s.Send(m.ID, Payload{ID: s.ID(), Digest: m.Digest, Command: cmd})
+	}
+}
+
+func (s *Store) handlePayload(m Payload) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, s.ID())
+	d := string(m.Digest)
+	if _, ok := s.wants[d]; !ok {
+		return
+	}
+	if !bytes.Equal(Digest(m.Command), m.Digest) {
+		log.Errorf("payload from %v does not match digest %x", m.ID, m.Digest)
+		return
+	}
+	s.put(d, m.Command)
+}
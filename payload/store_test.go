@@ -0,0 +1,144 @@
+package payload
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/salemmohammed/PaxiBFT"
+)
+
+// do runs f on the goroutine of replica
+type do struct {
+	f func()
+}
+
+type replica struct {
+	PaxiBFT.Node
+	store    *Store
+	received chan PaxiBFT.Command
+}
+
+func newReplica(id PaxiBFT.ID) *replica {
+	r := &replica{
+		Node:     PaxiBFT.NewNode(id),
+		received: make(chan PaxiBFT.Command, 10),
+	}
+	r.store = NewStore(r)
+	r.Register(do{}, func(m do) { m.f() })
+	return r
+}
+
+// run executes f on replica and waits for it
+func (r *replica) run(f func()) {
+	done := make(chan struct{})
+	r.Send(r.ID(), do{f: func() {
+		f()
+		close(done)
+	}})
+	<-done
+}
+
+// cluster starts n replicas in simulation, closed at the end of test
+func cluster(t *testing.T, port, n int) map[PaxiBFT.ID]*replica {
+	PaxiBFT.Simulation()
+	c := PaxiBFT.MakeDefaultConfig()
+	c.Addrs = make(map[PaxiBFT.ID]string)
+	c.HTTPAddrs = make(map[PaxiBFT.ID]string)
+	for i := 1; i <= n; i++ {
+		id := PaxiBFT.NewID(1, i)
+		p := strconv.Itoa(port + i)
+		c.Addrs[id] = "chan://127.0.0.1:" + p
+		c.HTTPAddrs[id] = "http://127.0.0.1:" + p
+	}
+	PaxiBFT.SetConfig(c)
+
+	replicas := make(map[PaxiBFT.ID]*replica)
+	t.Cleanup(func() {
+		for _, r := range replicas {
+			r.Close()
+		}
+	})
+	for id := range c.Addrs {
+		replicas[id] = newReplica(id)
+		go replicas[id].Run()
+		err := PaxiBFT.Retry(func() error {
+			r, err := http.Get(c.HTTPAddrs[id] + "/load")
+			if err == nil {
+				r.Body.Close()
+			}
+			return err
+		}, 50, 10*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return replicas
+}
+
+// expect waits for payload delivered to replica r
+func expect(t *testing.T, r *replica, cmd PaxiBFT.Command) {
+	select {
+	case got := <-r.received:
+		if got.Key != cmd.Key || !bytes.Equal(got.Value, cmd.Value) {
+			t.Errorf("replica %v received %v, expected %v", r.ID(), got, cmd)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("replica %v did not receive %v", r.ID(), cmd)
+	}
+}
+
+func TestStore(t *testing.T) {
+	*timeout = 20
+	replicas := cluster(t, 20700, 4)
+	cmd := PaxiBFT.Command{Key: "1", Value: PaxiBFT.Value("payload")}
+	d := Digest(cmd)
+
+	// present payload is delivered right away
+	replicas["1.1"].run(func() {
+		replicas["1.1"].store.Put(cmd)
+		replicas["1.1"].store.Wait(d, "1.1", func(c PaxiBFT.Command) { replicas["1.1"].received <- c })
+	})
+	expect(t, replicas["1.1"], cmd)
+
+	// 1.2 does not have the payload either, 1.3 asks 1.1 after timeout
+	r := replicas["1.3"]
+	r.run(func() {
+		r.store.Wait(d, "1.2", func(c PaxiBFT.Command) { r.received <- c })
+	})
+	expect(t, r, cmd)
+
+	// forged payload does not match digest and is dropped
+	r = replicas["1.4"]
+	r.run(func() {
+		r.store.Wait(d, "1.2", func(c PaxiBFT.Command) { r.received <- c })
+	})
+	replicas["1.2"].run(func() {
+		forged := PaxiBFT.Command{Key: "1", Value: PaxiBFT.Value("forged")}
+		replicas["1.2"].Send("1.4", Payload{ID: "1.2", Digest: d, Command: forged})
+	})
+	expect(t, r, cmd)
+	select {
+	case c := <-r.received:
+		t.Errorf("payload delivered twice, second %v", c)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDigest(t *testing.T) {
+	cmd := PaxiBFT.Command{Key: "1", Value: PaxiBFT.Value("v"), ClientID: "c", CommandID: 1}
+	for _, other := range []PaxiBFT.Command{
+		{Key: "1", Value: PaxiBFT.Value("v"), ClientID: "d", CommandID: 1},
+		{Key: "1", Value: PaxiBFT.Value("v"), ClientID: "c", CommandID: 2},
+		{Key: "1", Value: PaxiBFT.Value("v")},
+	} {
+		if bytes.Equal(Digest(cmd), Digest(other)) {
+			t.Errorf("%v and %v have the same digest", cmd, other)
+		}
+	}
+	if !bytes.Equal(Digest(cmd), Digest(cmd)) {
+		t.Errorf("digest of %v is not deterministic", cmd)
+	}
+}
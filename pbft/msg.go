@@ -35,8 +35,8 @@ type Prepare struct {
 	View   	PaxiBFT.View
 	Slot   	int
 	Digest 	[]byte
-	Command PaxiBFT.Command
-	Request PaxiBFT.Request
+	Command PaxiBFT.Command // left empty in hash first mode
+	Request PaxiBFT.Request // left empty in hash first mode
 }
 
 func (m Prepare) String() string {
@@ -50,8 +50,8 @@ type Commit struct {
 	View 	 PaxiBFT.View
 	Slot     int
 	Digest 	 []byte
-	Command  PaxiBFT.Command
-	Request  PaxiBFT.Request
+	Command  PaxiBFT.Command // left empty in hash first mode
+	Request  PaxiBFT.Request // left empty in hash first mode
 }
 
 func (m Commit) String() string {
@@ -1,12 +1,28 @@
 package pbft
 
 import (
+	"bytes"
 	"crypto/md5"
 	"github.com/salemmohammed/PaxiBFT"
 	"github.com/salemmohammed/PaxiBFT/log"
+	"github.com/salemmohammed/PaxiBFT/payload"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const walVote = "vote/"
+const walCommit = "commit/"
+
+// vote is what this replica sent for a slot, kept in write-ahead log
+// so a restarted replica never votes for another digest in the same view
+type vote struct {
+	View      PaxiBFT.View
+	Ballot    PaxiBFT.Ballot
+	Digest    []byte
+	Committed bool
+}
+
 type status int8
 
 const (
@@ -55,6 +71,10 @@ type Pbft struct {
 	ReplyWhenCommit bool
 	RecivedReq      bool
 	Member          *PaxiBFT.Memberlist
+	payload         *payload.Store       // payloads of digests ordered in hash first mode
+	hashFirst       bool                 // order digests only, execute once payload is present
+	replies         map[int]PaxiBFT.Reply // replies of executed slots whose request has not arrived yet
+	votes           map[int]vote          // votes recovered from write-ahead log
 }
 
 // NewPbft creates new pbft instance
@@ -70,13 +90,65 @@ func NewPbft(n PaxiBFT.Node, options ...func(*Pbft)) *Pbft {
 		ReplyWhenCommit: false,
 		RecivedReq:      false,
 		Member:          PaxiBFT.NewMember(),
+		payload:         payload.NewStore(n),
+		hashFirst:       PaxiBFT.GetConfig().HashFirst,
+		replies:         make(map[int]PaxiBFT.Reply),
+		votes:           make(map[int]vote),
 	}
 	for _, opt := range options {
 		opt(p)
 	}
+	if n.WAL().Recovering() {
+		p.recover()
+	}
 	return p
 }
 
+// recover restores votes, view and ballot from write-ahead log,
+// and executes the committed prefix again to rebuild the state machine
+func (p *Pbft) recover() {
+	w := p.WAL()
+	for _, k := range w.Keys(walVote) {
+		s, err := strconv.Atoi(strings.TrimPrefix(k, walVote))
+		if err != nil {
+			continue
+		}
+		var v vote
+		if !w.Get(k, &v) {
+			continue
+		}
+		p.votes[s] = v
+		if v.Ballot > p.ballot {
+			p.ballot = v.Ballot
+			p.view = v.View
+		}
+	}
+	var cmd PaxiBFT.Command
+	for w.Get(walCommit+strconv.Itoa(p.execute), &cmd) {
+		p.Execute(cmd)
+		p.execute++
+		cmd = PaxiBFT.Command{}
+	}
+	// replies of replayed slots come from the client session table
+	p.slot = p.execute - 1
+	log.Infof("Replica %s recovered %d votes in view %v and executed up to %d", p.ID(), len(p.votes), p.view, p.execute)
+}
+
+// vote persists vote for slot s before it is sent,
+// returns false if this replica already voted for another digest of slot s in the same view
+func (p *Pbft) vote(s int, view PaxiBFT.View, digest []byte, committed bool) bool {
+	if v, exists := p.votes[s]; exists && v.View == view && !bytes.Equal(v.Digest, digest) {
+		log.Warningf("Replica %s refuses to vote for slot %d again in view %v", p.ID(), s, view)
+		return false
+	}
+	v := vote{View: view, Ballot: p.ballot, Digest: digest, Committed: committed}
+	p.WAL().Put(walVote+strconv.Itoa(s), v)
+	if p.WAL() != nil {
+		p.votes[s] = v
+	}
+	return true
+}
+
 // Digest message
 func GetMD5Hash(r *PaxiBFT.Request) []byte {
 	hasher := md5.New()
@@ -89,6 +161,10 @@ func (p *Pbft) HandleRequest(r PaxiBFT.Request, s int) {
 
 	e := p.log[s]
 	e.Digest = GetMD5Hash(&r)
+	if p.hashFirst {
+		e.Digest = payload.Digest(r.Command)
+		p.receive(s, e.Digest, p.ID())
+	}
 	log.Debugf("[p.ballot.ID %v, p.ballot %v ]", p.ballot.ID(), p.ballot)
 	log.Debugf("PrePrepare will be called")
 	p.PrePrepare(&r, &e.Digest, s)
@@ -118,6 +194,16 @@ func (p *Pbft) PrePrepare(r *PaxiBFT.Request, s *[]byte, slt int) {
 		}
 	}
 
+	if p.hashFirst {
+		p.Broadcast(PrePrepare{
+			Ballot: p.ballot,
+			ID:     p.ID(),
+			View:   p.view,
+			Slot:   slt,
+			Digest: *s,
+		})
+		return
+	}
 	p.Broadcast(PrePrepare{
 		Ballot:     p.ballot,
 		ID:         p.ID(),
@@ -150,29 +236,40 @@ func (p *Pbft) HandlePre(m PrePrepare) {
 		p.log[m.Slot] = &entry{
 			ballot:    p.ballot,
 			view:      p.view,
-			command:   m.Command,
 			commit:    false,
 			active:    false,
 			Leader:    false,
-			request:   &m.Request,
 			timestamp: time.Now(),
-			Digest:    GetMD5Hash(&m.Request),
 			Q1:        PaxiBFT.NewQuorum(),
 			Q2:        PaxiBFT.NewQuorum(),
 			Q3:        PaxiBFT.NewQuorum(),
 			Q4:        PaxiBFT.NewQuorum(),
 		}
+		if !p.hashFirst {
+			// votes carry the request unless only digests are ordered
+			p.log[m.Slot].command = m.Command
+			p.log[m.Slot].request = &m.Request
+			p.log[m.Slot].Digest = GetMD5Hash(&m.Request)
+		}
 	}
 	e, ok = p.log[m.Slot]
 
-	e.Digest = GetMD5Hash(&m.Request)
-	for i, v := range e.Digest {
-		if v != m.Digest[i] {
-			return
+	if p.hashFirst {
+		e.Digest = m.Digest
+		p.receive(m.Slot, m.Digest, m.ID)
+	} else {
+		e.Digest = GetMD5Hash(&m.Request)
+		for i, v := range e.Digest {
+			if v != m.Digest[i] {
+				return
+			}
 		}
 	}
 	log.Debugf("m.Ballot=%v , p.ballot=%v, m.view=%v", m.Ballot, p.ballot, m.View)
 	log.Debugf("at the prepare handling")
+	if !p.vote(m.Slot, m.View, m.Digest, false) {
+		return
+	}
 	p.Broadcast(Prepare{
 		Ballot:  p.ballot,
 		ID:      p.ID(),
@@ -197,18 +294,21 @@ func (p *Pbft) HandlePrepare(m Prepare) {
 		p.log[m.Slot] = &entry{
 			ballot:    p.ballot,
 			view:      p.view,
-			command:   m.Command,
 			commit:    false,
 			active:    false,
 			Leader:    false,
-			request:   &m.Request,
 			timestamp: time.Now(),
-			Digest:    GetMD5Hash(&m.Request),
 			Q1:        PaxiBFT.NewQuorum(),
 			Q2:        PaxiBFT.NewQuorum(),
 			Q3:        PaxiBFT.NewQuorum(),
 			Q4:        PaxiBFT.NewQuorum(),
 		}
+		if !p.hashFirst {
+			// votes carry the request unless only digests are ordered
+			p.log[m.Slot].command = m.Command
+			p.log[m.Slot].request = &m.Request
+			p.log[m.Slot].Digest = GetMD5Hash(&m.Request)
+		}
 	}
 	e, ok = p.log[m.Slot]
 	e.Q1.ACK(m.ID)
@@ -216,6 +316,9 @@ func (p *Pbft) HandlePrepare(m Prepare) {
 	if e.Q1.Majority(){
 		e.Q1.Reset()
 		e.Pstatus = PREPARED
+		if !p.vote(m.Slot, p.view, m.Digest, true) {
+			return
+		}
 		p.Broadcast(Commit{
 			Ballot:  p.ballot,
 			ID:      p.ID(),
@@ -247,18 +350,21 @@ func (p *Pbft) HandleCommit(m Commit) {
 		p.log[m.Slot] = &entry{
 			ballot:    p.ballot,
 			view:      p.view,
-			command:   m.Command,
 			commit:    false,
 			active:    false,
 			Leader:    false,
-			request:   &m.Request,
 			timestamp: time.Now(),
-			Digest:    GetMD5Hash(&m.Request),
 			Q1:        PaxiBFT.NewQuorum(),
 			Q2:        PaxiBFT.NewQuorum(),
 			Q3:        PaxiBFT.NewQuorum(),
 			Q4:        PaxiBFT.NewQuorum(),
 		}
+		if !p.hashFirst {
+			// votes carry the request unless only digests are ordered
+			p.log[m.Slot].command = m.Command
+			p.log[m.Slot].request = &m.Request
+			p.log[m.Slot].Digest = GetMD5Hash(&m.Request)
+		}
 	}
 	e, exist = p.log[m.Slot]
 	e.Q2.ACK(m.ID)
@@ -285,6 +391,7 @@ func (p *Pbft) exec() {
 			log.Debugf("Break")
 			break
 		}
+		p.WAL().Put(walCommit+strconv.Itoa(p.execute), e.command)
 		value := p.Execute(e.command)
 		if len(value) > 0 {
 			log.Debugf("value=%v", value[:min(len(value), 100)])
@@ -298,7 +405,14 @@ func (p *Pbft) exec() {
 			Properties: make(map[string]string),
 		}
 
-		if e.request != nil && e.Leader{
+		if e.request == nil && p.slot < p.execute && e.command.ClientID != "" && e.command.CommandID > 0 {
+			// client session answers the request once it arrives and keeps it from the replica,
+			// so the slot is counted here
+			p.slot = p.execute
+		} else if e.request == nil {
+			// request of slot has not arrived yet in hash first mode
+			p.replies[p.execute] = reply
+		}else if e.Leader{
 			log.Debugf(" ********* Primary Request ********* %v", *e.request)
 			e.request.Reply(reply)
 			log.Debugf("********* Reply Primary *********")
@@ -312,9 +426,29 @@ func (p *Pbft) exec() {
 		}
 		delete(p.log, p.execute)
 		p.execute++
+		if i := PaxiBFT.GetConfig().Checkpoint; i > 0 && p.execute%i == 0 {
+			p.Checkpoint(p.execute - 1)
+		}
 	}
 }
 
+// receive waits for payload of digest ordered at slot s, fetching it from proposer if missing,
+// the slot commits only once its payload is present
+func (p *Pbft) receive(s int, digest []byte, from PaxiBFT.ID) {
+	p.payload.Wait(digest, from, func(cmd PaxiBFT.Command) {
+		e, ok := p.log[s]
+		if !ok || s < p.execute {
+			return
+		}
+		e.command = cmd
+		e.Rstatus = RECEIVED
+		if e.Cstatus == COMMITTED && e.Pstatus == PREPARED {
+			e.commit = true
+			p.exec()
+		}
+	})
+}
+
 func min(a, b int) int {
     if a < b {
         return a
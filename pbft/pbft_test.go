@@ -0,0 +1,167 @@
+package pbft
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/salemmohammed/PaxiBFT"
+)
+
+var errIncomplete = errors.New("history incomplete")
+
+// cluster starts n replicas in simulation with configuration c,
+// they are closed at the end of test before the next cluster changes configuration
+func cluster(t *testing.T, c PaxiBFT.Config, port, n int) map[PaxiBFT.ID]*Replica {
+	PaxiBFT.Simulation()
+	c.Addrs = make(map[PaxiBFT.ID]string)
+	c.HTTPAddrs = make(map[PaxiBFT.ID]string)
+	for i := 1; i <= n; i++ {
+		id := PaxiBFT.NewID(1, i)
+		p := strconv.Itoa(port + i)
+		c.Addrs[id] = "chan://127.0.0.1:" + p
+		c.HTTPAddrs[id] = "http://127.0.0.1:" + p
+	}
+	PaxiBFT.SetConfig(c)
+
+	replicas := make(map[PaxiBFT.ID]*Replica)
+	t.Cleanup(func() {
+		for _, r := range replicas {
+			r.Close()
+		}
+	})
+	for id := range c.Addrs {
+		replicas[id] = NewReplica(id)
+		go replicas[id].Run()
+		serving(t, id)
+	}
+	return replicas
+}
+
+// serving waits for http server of replica id
+func serving(t *testing.T, id PaxiBFT.ID) {
+	err := PaxiBFT.Retry(func() error {
+		r, err := http.Get(PaxiBFT.GetConfig().HTTPAddrs[id] + "/history?key=0")
+		if err == nil {
+			r.Body.Close()
+		}
+		return err
+	}, 50, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// put sends the same write to every given replica and waits for all replies
+func put(t *testing.T, client *PaxiBFT.HTTPClient, ids []PaxiBFT.ID, key PaxiBFT.Key, value PaxiBFT.Value) {
+	var wait sync.WaitGroup
+	for _, id := range ids {
+		wait.Add(1)
+		go func(id PaxiBFT.ID) {
+			defer wait.Done()
+			if _, _, err := client.RESTPut(id, key, value); err != nil {
+				t.Errorf("put %s to %v: %v", value, id, err)
+			}
+		}(id)
+	}
+	wait.Wait()
+}
+
+// history waits until every given replica executed n values of key and checks they are equal
+func history(t *testing.T, replicas map[PaxiBFT.ID]*Replica, ids []PaxiBFT.ID, key PaxiBFT.Key, n int) {
+	err := PaxiBFT.Retry(func() error {
+		for _, id := range ids {
+			if len(replicas[id].History(key)) < n {
+				return errIncomplete
+			}
+		}
+		return nil
+	}, 100, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		h := replicas[id].History(key)
+		for i := range h {
+			if !bytes.HasPrefix(h[i], []byte("v"+strconv.Itoa(i))) {
+				t.Fatalf("replica %v executed %.8s at %d", id, h[i], i)
+			}
+		}
+	}
+}
+
+func TestPBFT(t *testing.T) {
+	all := []PaxiBFT.ID{"1.1", "1.2", "1.3", "1.4"}
+	for i, hashFirst := range []bool{false, true} {
+		t.Run("hash_first="+strconv.FormatBool(hashFirst), func(t *testing.T) {
+			c := PaxiBFT.MakeDefaultConfig()
+			c.MultiVersion = true
+			c.HashFirst = hashFirst
+			replicas := cluster(t, c, 21000+i*10, 4)
+			client := PaxiBFT.NewHTTPClient("")
+			client.Client.Timeout = 10 * time.Second
+			client.Session = "c"
+
+			for i := 0; i < 4; i++ {
+				client.CID = i + 1
+				put(t, client, all, "0", PaxiBFT.Value("v"+strconv.Itoa(i)))
+			}
+			if !hashFirst {
+				history(t, replicas, all, "0", 4)
+				return
+			}
+
+			// 1.4 never receives the last request, it orders its digest and fetches the payload
+			client.CID = 5
+			put(t, client, all[:3], "0", PaxiBFT.Value("v4"))
+			if t.Failed() {
+				t.FailNow()
+			}
+			history(t, replicas, all, "0", 5)
+		})
+	}
+}
+
+func TestRecover(t *testing.T) {
+	all := []PaxiBFT.ID{"1.1", "1.2", "1.3", "1.4"}
+	c := PaxiBFT.MakeDefaultConfig()
+	c.WAL = PaxiBFT.StorageConfig{Engine: "file", Dir: t.TempDir(), Sync: "always"}
+	c.MultiVersion = true
+	replicas := cluster(t, c, 21020, 4)
+	client := PaxiBFT.NewHTTPClient("")
+	client.Client.Timeout = 10 * time.Second
+	client.Session = "c"
+	for i := 0; i < 3; i++ {
+		client.CID = i + 1
+		put(t, client, all, "0", PaxiBFT.Value("v"+strconv.Itoa(i)))
+	}
+	history(t, replicas, all, "0", 3)
+
+	// kill 1.4 and restart it from its write-ahead log
+	replicas["1.4"].Close()
+	flag.Set("recover", "true")
+	defer flag.Set("recover", "false")
+	r := NewReplica("1.4")
+	replicas["1.4"] = r
+	go r.Run()
+	serving(t, "1.4")
+	if h := r.History("0"); len(h) != 3 {
+		t.Fatalf("recovered history of 0 has %d values, expected 3", len(h))
+	}
+	if r.votes[2].View != replicas["1.1"].view {
+		t.Errorf("recovered vote in view %v, expected %v", r.votes[2].View, replicas["1.1"].view)
+	}
+
+	// recovered replica answers a retry from its session table and keeps executing new requests
+	if _, _, err := client.RESTPut("1.4", "0", PaxiBFT.Value("v2")); err != nil {
+		t.Errorf("retry to recovered 1.4: %v", err)
+	}
+	client.CID = 4
+	put(t, client, all, "0", PaxiBFT.Value("v3"))
+	history(t, replicas, all, "0", 4)
+}
@@ -41,6 +41,15 @@ func (p *Replica) handleRequest(m PaxiBFT.Request) {
 	if p.slot%1000 == 0 {
 		fmt.Print("p.slot", p.slot)
 	}
+	if p.hashFirst {
+		p.payload.Put(m.Command)
+		if reply, ok := p.replies[p.slot]; ok {
+			// slot executed with payload fetched before request arrived
+			delete(p.replies, p.slot)
+			m.Reply(reply)
+			return
+		}
+	}
 
 	e, ok := p.log[p.slot]
 	if !ok {
@@ -62,7 +71,10 @@ func (p *Replica) handleRequest(m PaxiBFT.Request) {
 	}
 	e = p.log[p.slot]
 	// Ensure that e is used after it's updated
-	e.command = m.Command
+	if !p.hashFirst {
+		// command of slot is the payload of ordered digest in hash first mode
+		e.command = m.Command
+	}
 	e.request = &m
 	log.Debugf("p.slot = %v ", p.slot)
 	log.Debugf("Key = %v ", m.Command.Key)
@@ -102,6 +114,9 @@ func (p *Replica) handleRequest(m PaxiBFT.Request) {
 		p.requests = append(p.requests, &m)
 		p.Pbft.HandleRequest(m, p.slot)
 	}
+	if p.hashFirst {
+		return
+	}
 	e.Rstatus = RECEIVED
 	if e.Cstatus == COMMITTED && e.Pstatus == PREPARED && e.Rstatus == RECEIVED{
 		e.commit = true
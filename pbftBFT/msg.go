@@ -50,7 +50,7 @@ type Commit struct {
 }
 
 func (m Commit) String() string {
-	return fmt.Sprintf("Commit {Ballot=%v, ID=%v, Slot=%v, Digest}", m.Ballot,m.ID,m.Slot,m.Digest)
+	return fmt.Sprintf("Commit {Ballot=%v, ID=%v, Slot=%v, Digest=%x}", m.Ballot,m.ID,m.Slot,m.Digest)
 }
 
 // ViewChange  message
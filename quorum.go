@@ -1,6 +1,10 @@
 package PaxiBFT
 
-import "github.com/salemmohammed/PaxiBFT/log"
+import (
+	"fmt"
+
+	"github.com/salemmohammed/PaxiBFT/log"
+)
 
 // Quorum records each acknowledgement and check for different types of quorum satisfied
 type Quorum struct {
@@ -83,6 +87,11 @@ func (q *Quorum) PreparedMajority() bool {
 	return q.size >= (config.n/2) -1
 }
 
+// ByzantineMajority quorum of more than two thirds of nodes satisfied, 2f+1 out of n = 3f+1
+func (q *Quorum) ByzantineMajority() bool {
+	return q.size > config.n*2/3
+}
+
 // FastQuorum from fast paxos
 func (q *Quorum) FastQuorum() bool {
 	return q.size >= config.n*3/4
@@ -151,42 +160,73 @@ func (q *Quorum) AID_ID(id ID) {
 	log.Debugf("ids %v", q.AID)
 }
 
-/*
-// Q1 returns true if config.Quorum type is satisfied
-func (q *Quorum) Q1() bool {
-	switch config.Quorum {
-	case "majority":
-		return q.Majority()
+// QuorumConfig describes the phase-1 and phase-2 quorum systems used by flexible paxos
+type QuorumConfig struct {
+	Type string `json:"type"` // quorum system {majority, grid, fgrid, group, count}
+	Fz   int    `json:"fz"`   // number of zone failures tolerated by fgrid
+	Q1   int    `json:"q1"`   // phase-1 quorum size for count
+	Q2   int    `json:"q2"`   // phase-2 quorum size for count
+}
+
+// Validate returns error if some phase-1 quorum may not intersect some phase-2 quorum
+func (c QuorumConfig) Validate() error {
+	switch c.Type {
+	case "", "majority", "grid":
+		// majorities always intersect, every grid row crosses every column
+		return nil
+	case "fgrid":
+		// z-Fz zones in phase 1 and Fz+1 zones in phase 2 share at least one zone
+		if c.Fz < 0 || c.Fz >= config.z {
+			return fmt.Errorf("fgrid quorum needs 0 <= fz < %d zones, got fz=%d", config.z, c.Fz)
+		}
+		return nil
+	case "group":
+		// majorities of two different zones never intersect
+		if config.z > 1 {
+			return fmt.Errorf("group quorum does not intersect across %d zones", config.z)
+		}
+		return nil
+	case "count":
+		if c.Q1 <= 0 || c.Q2 <= 0 || c.Q1 > config.n || c.Q2 > config.n {
+			return fmt.Errorf("count quorum sizes q1=%d q2=%d out of range [1, %d]", c.Q1, c.Q2, config.n)
+		}
+		if c.Q1+c.Q2 <= config.n {
+			return fmt.Errorf("count quorum q1=%d + q2=%d does not exceed n=%d", c.Q1, c.Q2, config.n)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown quorum type %s", c.Type)
+	}
+}
+
+// Phase1 returns the phase-1 quorum function of the configured quorum system
+func (c QuorumConfig) Phase1() func(*Quorum) bool {
+	switch c.Type {
 	case "grid":
-		return q.GridRow()
+		return func(q *Quorum) bool { return q.GridRow() }
 	case "fgrid":
-		return q.FGridQ1()
+		return func(q *Quorum) bool { return q.FGridQ1(c.Fz) }
 	case "group":
-		return q.ZoneMajority()
+		return func(q *Quorum) bool { return q.ZoneMajority() }
 	case "count":
-		return q.size >= config.n-config.F
+		return func(q *Quorum) bool { return q.size >= c.Q1 }
 	default:
-		log.Error("Unknown quorum type")
-		return false
+		return func(q *Quorum) bool { return q.size > config.n/2 }
 	}
 }
 
-// Q2 returns true if config.Quorum type is satisfied
-func (q *Quorum) Q2() bool {
-	switch config.Quorum {
-	case "majority":
-		return q.Majority()
+// Phase2 returns the phase-2 quorum function of the configured quorum system
+func (c QuorumConfig) Phase2() func(*Quorum) bool {
+	switch c.Type {
 	case "grid":
-		return q.GridColumn()
+		return func(q *Quorum) bool { return q.GridColumn() }
 	case "fgrid":
-		return q.FGridQ2()
+		return func(q *Quorum) bool { return q.FGridQ2(c.Fz) }
 	case "group":
-		return q.ZoneMajority()
+		return func(q *Quorum) bool { return q.ZoneMajority() }
 	case "count":
-		return q.size > config.F
+		return func(q *Quorum) bool { return q.size >= c.Q2 }
 	default:
-		log.Error("Unknown quorum type")
-		return false
+		return func(q *Quorum) bool { return q.size > config.n/2 }
 	}
 }
-*/
@@ -0,0 +1,125 @@
+package PaxiBFT
+
+import "testing"
+
+// grid of 3 zones with 3 nodes each
+func grid3x3() Config {
+	c := MakeDefaultConfig()
+	c.Addrs = make(map[ID]string)
+	for z := 1; z <= 3; z++ {
+		for n := 1; n <= 3; n++ {
+			c.Addrs[NewID(z, n)] = ""
+		}
+	}
+	return c
+}
+
+func TestQuorumValidate(t *testing.T) {
+	old := config
+	defer func() { config = old }()
+	SetConfig(grid3x3())
+
+	valid := []QuorumConfig{
+		{Type: ""},
+		{Type: "majority"},
+		{Type: "grid"},
+		{Type: "fgrid", Fz: 0},
+		{Type: "fgrid", Fz: 2},
+		{Type: "count", Q1: 7, Q2: 3},
+		{Type: "count", Q1: 9, Q2: 1},
+	}
+	for _, q := range valid {
+		if err := q.Validate(); err != nil {
+			t.Errorf("expect %+v to be valid, got %v", q, err)
+		}
+	}
+
+	invalid := []QuorumConfig{
+		{Type: "unknown"},
+		{Type: "group"},
+		{Type: "fgrid", Fz: 3},
+		{Type: "fgrid", Fz: -1},
+		{Type: "count", Q1: 5, Q2: 4},
+		{Type: "count", Q1: 10, Q2: 1},
+		{Type: "count", Q1: 9, Q2: 0},
+	}
+	for _, q := range invalid {
+		if err := q.Validate(); err == nil {
+			t.Errorf("expect %+v to be rejected", q)
+		}
+	}
+
+	// group quorum is majority when there is only one zone
+	c := MakeDefaultConfig()
+	c.Addrs = map[ID]string{NewID(1, 1): "", NewID(1, 2): "", NewID(1, 3): ""}
+	SetConfig(c)
+	if err := (QuorumConfig{Type: "group"}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQuorumPhases(t *testing.T) {
+	old := config
+	defer func() { config = old }()
+	SetConfig(grid3x3())
+
+	ack := func(ids ...ID) *Quorum {
+		q := NewQuorum()
+		for _, id := range ids {
+			q.ACK(id)
+		}
+		return q
+	}
+
+	column := ack(NewID(1, 1), NewID(1, 2), NewID(1, 3))
+	row := ack(NewID(1, 1), NewID(2, 1), NewID(3, 1))
+
+	grid := QuorumConfig{Type: "grid"}
+	if !grid.Phase1()(row) || grid.Phase1()(column) {
+		t.Error("grid phase 1 should be a row")
+	}
+	if !grid.Phase2()(column) || grid.Phase2()(row) {
+		t.Error("grid phase 2 should be a column")
+	}
+
+	// fz = 0 needs majority of every zone in phase 1 and one zone in phase 2
+	fgrid := QuorumConfig{Type: "fgrid", Fz: 0}
+	zones := ack(NewID(1, 1), NewID(1, 2), NewID(2, 1), NewID(2, 2), NewID(3, 1), NewID(3, 2))
+	if !fgrid.Phase1()(zones) || fgrid.Phase1()(column) {
+		t.Error("fgrid phase 1 should be majority of every zone")
+	}
+	if !fgrid.Phase2()(ack(NewID(2, 1), NewID(2, 2))) {
+		t.Error("fgrid phase 2 should be majority of one zone")
+	}
+
+	count := QuorumConfig{Type: "count", Q1: 7, Q2: 3}
+	if count.Phase1()(zones) || !count.Phase2()(row) {
+		t.Error("count quorum sizes mismatch")
+	}
+
+	majority := QuorumConfig{Type: "majority"}
+	if majority.Phase1()(ack(NewID(1, 1), NewID(1, 2), NewID(1, 3), NewID(2, 1))) {
+		t.Error("4 out of 9 is not majority")
+	}
+	if !majority.Phase2()(ack(NewID(1, 1), NewID(1, 2), NewID(1, 3), NewID(2, 1), NewID(2, 2))) {
+		t.Error("5 out of 9 is majority")
+	}
+}
+
+func TestQuorumByzantineMajority(t *testing.T) {
+	old := config
+	defer func() { config = old }()
+	SetConfig(grid3x3())
+
+	q := NewQuorum()
+	for _, id := range []ID{NewID(1, 1), NewID(1, 2), NewID(1, 3), NewID(2, 1), NewID(2, 2), NewID(2, 3)} {
+		q.ACK(id)
+	}
+	if q.ByzantineMajority() {
+		t.Error("6 out of 9 is not more than two thirds")
+	}
+	q.ACK(NewID(3, 1))
+	if !q.ByzantineMajority() {
+		t.Error("7 out of 9 is more than two thirds")
+	}
+}
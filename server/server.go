@@ -3,13 +3,18 @@ package main
 import (
 	"flag"
 	"github.com/salemmohammed/PaxiBFT/HotStuff"
+	"github.com/salemmohammed/PaxiBFT/HotStuff2"
 	"github.com/salemmohammed/PaxiBFT/HotStuffBFT"
 	"github.com/salemmohammed/PaxiBFT/HotStuff_SL"
+	"github.com/salemmohammed/PaxiBFT/bullshark"
+	"github.com/salemmohammed/PaxiBFT/mirbft"
 	"github.com/salemmohammed/PaxiBFT/paxos"
 	"github.com/salemmohammed/PaxiBFT/pbftBFT"
 	"github.com/salemmohammed/PaxiBFT/streamletBFT"
 	"github.com/salemmohammed/PaxiBFT/tendermint"
 	"github.com/salemmohammed/PaxiBFT/tendermintBFT"
+	"github.com/salemmohammed/PaxiBFT/wpaxos"
+	"strings"
 	"sync"
 
 	"github.com/salemmohammed/PaxiBFT"
@@ -23,6 +28,19 @@ var algorithm = flag.String("algorithm", "", "Distributed algorithm")
 var id = flag.String("id", "", "ID in format of Zone.Node.")
 var simulation = flag.Bool("sim", false, "simulation mode")
 var master = flag.String("master", "", "Master address.")
+var namespaces = flag.String("namespaces", "", "Comma separated namespaces of paxos instances hosted besides the default one.")
+
+// algorithms that persist their state in the write-ahead log and recover from it
+var durable = map[string]bool{
+	"paxos":      true,
+	"pbft":       true,
+	"hotstuff":   true,
+	"hotstuff2":  true,
+	"tendermint": true,
+	"streamlet":  true,
+	"mirbft":     true,
+	"bullshark":  true,
+}
 
 func replica(id PaxiBFT.ID) {
 	if *master != "" {
@@ -30,6 +48,9 @@ func replica(id PaxiBFT.ID) {
 	}
 
 	log.Infof("node %v starting...", id)
+	if PaxiBFT.GetConfig().WAL.Engine == "file" && !durable[*algorithm] {
+		log.Fatalf("algorithm %s keeps no write-ahead log to recover from", *algorithm)
+	}
 
 	switch *algorithm {
 
@@ -51,10 +72,24 @@ func replica(id PaxiBFT.ID) {
 		HotStuff.NewReplica(id).Run()
 	case "HotStuff_SL":
 		HotStuff_SL.NewReplica(id).Run()
+	case "hotstuff2":
+		HotStuff2.NewReplica(id).Run()
+	case "mirbft":
+		mirbft.NewReplica(id).Run()
 	case "hotstuffBFT":
 		HotStuffBFT.NewReplica(id).Run()
 	case "paxos":
-		paxos.NewReplica(id).Run()
+		r := paxos.NewReplica(id)
+		for _, ns := range strings.Split(*namespaces, ",") {
+			if ns != "" {
+				paxos.NewInstance(r.Instance(ns))
+			}
+		}
+		r.Run()
+	case "wpaxos":
+		wpaxos.NewReplica(id).Run()
+	case "bullshark":
+		bullshark.NewReplica(id).Run()
 
 	default:
 		panic("Unknown algorithm")
@@ -0,0 +1,208 @@
+package PaxiBFT
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash"
+	"sort"
+	"sync"
+
+	"github.com/salemmohammed/PaxiBFT/log"
+)
+
+// ErrStaleRequest is replied to a request older than the last request executed for its client
+var ErrStaleRequest = errors.New("stale request")
+
+// Session is the last command executed for a client and the value it returned.
+// A client sends one request at a time per session with increasing command ids,
+// so a retry of the last request is answered from Reply instead of executed again,
+// and a request older than the last one is a stale duplicate and dropped.
+type Session struct {
+	CommandID int   `json:"command_id"`
+	Reply     Value `json:"reply"`
+}
+
+// sessions is the client session table of the execution path,
+// commands without client id or command id bypass it
+type sessions map[ID]Session
+
+// tracked returns true if command belongs to a client session
+func tracked(c Command) bool {
+	return c.ClientID != "" && c.CommandID > 0
+}
+
+// executed returns the cached reply of command and true if the command was executed before
+// or is stale, in which case the reply is nil
+func (s sessions) executed(c Command) (Value, bool) {
+	if !tracked(c) {
+		return nil, false
+	}
+	last, ok := s[c.ClientID]
+	if !ok || c.CommandID > last.CommandID {
+		return nil, false
+	}
+	if c.CommandID == last.CommandID {
+		return last.Reply, true
+	}
+	log.Warningf("drop stale command %v, last command id of client is %d", c, last.CommandID)
+	return nil, true
+}
+
+// record remembers value returned by command
+func (s sessions) record(c Command, v Value) {
+	if tracked(c) {
+		s[c.ClientID] = Session{CommandID: c.CommandID, Reply: v}
+	}
+}
+
+// executor runs commands of protocols against the state machine of a node
+// through the client session table, so every state machine gets exactly-once semantics
+type executor struct {
+	sync.RWMutex
+	StateMachine
+	sessions sessions
+}
+
+func newExecutor(sm StateMachine) *executor {
+	return &executor{
+		StateMachine: sm,
+		sessions:     make(sessions),
+	}
+}
+
+// Execute executes command against state machine,
+// a command executed before for its client returns the cached value without executing again
+func (e *executor) Execute(c Command) Value {
+	e.Lock()
+	defer e.Unlock()
+	if v, ok := e.sessions.executed(c); ok {
+		return v
+	}
+	v := e.StateMachine.Execute(c)
+	if !bytes.Equal(v, Locked) {
+		// a command refused for a lock is sent again under the same command id
+		e.sessions.record(c, v)
+	}
+	return v
+}
+
+// Read executes a read-only command against state machine outside the client session table,
+// so reads served locally without ordering leave the state of the replica unchanged
+func (e *executor) Read(c Command) Value {
+	if !c.IsRead() {
+		log.Errorf("cannot read with command %v", c)
+		return nil
+	}
+	e.RLock()
+	defer e.RUnlock()
+	return e.StateMachine.Execute(c)
+}
+
+// Session returns the last command executed for client id
+func (e *executor) Session(id ID) (Session, bool) {
+	e.RLock()
+	defer e.RUnlock()
+	s, ok := e.sessions[id]
+	return s, ok
+}
+
+// executorSnapshot is the state machine snapshot together with the session table
+type executorSnapshot struct {
+	State    []byte   `json:"state"`
+	Sessions sessions `json:"sessions"`
+}
+
+// Snapshot encodes state machine and client session table
+func (e *executor) Snapshot() ([]byte, error) {
+	e.RLock()
+	defer e.RUnlock()
+	state, err := e.StateMachine.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(executorSnapshot{State: state, Sessions: e.sessions})
+}
+
+// Restore replaces state machine and client session table with snapshot
+func (e *executor) Restore(b []byte) error {
+	s := executorSnapshot{}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	e.Lock()
+	defer e.Unlock()
+	if err := e.StateMachine.Restore(s.State); err != nil {
+		return err
+	}
+	e.sessions = s.Sessions
+	if e.sessions == nil {
+		e.sessions = make(sessions)
+	}
+	return nil
+}
+
+// copySessions returns a copy of the client session table
+func (e *executor) copySessions() map[ID]Session {
+	e.RLock()
+	defer e.RUnlock()
+	s := make(map[ID]Session, len(e.sessions))
+	for id, session := range e.sessions {
+		s[id] = session
+	}
+	return s
+}
+
+// restoreCheckpoint replaces database db of the state machine and the client session table with
+// a checkpoint, which the database verifies against its hash first
+func (e *executor) restoreCheckpoint(db Database, b []byte) error {
+	cp := Checkpoint{}
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return err
+	}
+	e.Lock()
+	defer e.Unlock()
+	if err := db.Restore(b); err != nil {
+		return err
+	}
+	e.sessions = make(sessions, len(cp.Sessions))
+	for id, session := range cp.Sessions {
+		e.sessions[id] = session
+	}
+	return nil
+}
+
+// Hash combines hash of state machine with the session table
+func (e *executor) Hash() uint64 {
+	e.RLock()
+	defer e.RUnlock()
+	h := sha256.New()
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, e.StateMachine.Hash())
+	h.Write(b)
+	e.sessions.hash(h)
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+// hash writes the session table to h in client order
+func (s sessions) hash(h hash.Hash) {
+	ids := make([]string, 0, len(s))
+	for id := range s {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+	b := make([]byte, 8)
+	for _, id := range ids {
+		session := s[ID(id)]
+		binary.BigEndian.PutUint64(b, uint64(len(id)))
+		h.Write(b)
+		h.Write([]byte(id))
+		binary.BigEndian.PutUint64(b, uint64(session.CommandID))
+		h.Write(b)
+		binary.BigEndian.PutUint64(b, uint64(len(session.Reply)))
+		h.Write(b)
+		h.Write(session.Reply)
+	}
+}
@@ -0,0 +1,87 @@
+package PaxiBFT
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestSessionExactlyOnce(t *testing.T) {
+	kv := NewDatabase()
+	db := newExecutor(kv)
+
+	put := Command{Key: "1", Value: Value("a"), ClientID: "c", CommandID: 1}
+	db.Execute(put)
+	retry := put
+	retry.Value = Value("b")
+	if v := db.Execute(retry); v != nil {
+		t.Errorf("retry returned %q, expected cached nil", v)
+	}
+	if v := kv.Get("1"); !bytes.Equal(v, Value("a")) {
+		t.Errorf("retry executed again, value = %q", v)
+	}
+
+	next := Command{Key: "1", Value: Value("c"), ClientID: "c", CommandID: 2}
+	if v := db.Execute(next); !bytes.Equal(v, Value("a")) {
+		t.Errorf("next command returned %q, expected \"a\"", v)
+	}
+	if v := db.Execute(put); v != nil || !bytes.Equal(kv.Get("1"), Value("c")) {
+		t.Errorf("stale command was executed")
+	}
+
+	s, ok := db.Session("c")
+	if !ok || s.CommandID != 2 || !bytes.Equal(s.Reply, Value("a")) {
+		t.Errorf("session = %+v, %v", s, ok)
+	}
+
+	// commands without client session are always executed
+	anon := Command{Key: "2", Value: Value("x")}
+	db.Execute(anon)
+	if v := db.Execute(anon); !bytes.Equal(v, Value("x")) {
+		t.Errorf("untracked command returned %q, expected \"x\"", v)
+	}
+}
+
+func TestSessionRead(t *testing.T) {
+	kv := NewDatabase()
+	db := newExecutor(kv)
+	db.Execute(Command{Key: "1", Value: Value("a"), ClientID: "c", CommandID: 1})
+	hash := db.Hash()
+
+	// a local read leaves the session of its client and the state hash unchanged
+	read := Command{Key: "1", ClientID: "c", CommandID: 2}
+	if v := db.Read(read); !bytes.Equal(v, Value("a")) {
+		t.Errorf("read returned %q, expected \"a\"", v)
+	}
+	if s, _ := db.Session("c"); s.CommandID != 1 {
+		t.Errorf("read recorded in session %+v", s)
+	}
+	if h := db.Hash(); h != hash {
+		t.Errorf("read changed hash from %x to %x", hash, h)
+	}
+	if v := db.Read(Command{Key: "1", Value: Value("b")}); v != nil || !bytes.Equal(kv.Get("1"), Value("a")) {
+		t.Errorf("write executed as a read")
+	}
+}
+
+func TestSessionCheckpoint(t *testing.T) {
+	kv := NewDatabase()
+	db := newExecutor(kv)
+	db.Execute(Command{Key: "1", Value: Value("a"), ClientID: "c", CommandID: 1})
+	cp := <-kv.Checkpoint(0, db.copySessions())
+
+	// a restored checkpoint answers the retry of a request it covers from the session table
+	b, _ := json.Marshal(cp)
+	restoredKV := NewDatabase()
+	restored := newExecutor(restoredKV)
+	if err := restored.restoreCheckpoint(restoredKV, b); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Hash() != db.Hash() {
+		t.Errorf("restored hash %x, expected %x", restored.Hash(), db.Hash())
+	}
+	retry := Command{Key: "1", Value: Value("b"), ClientID: "c", CommandID: 1}
+	if restored.Execute(retry); !bytes.Equal(restoredKV.Get("1"), Value("a")) {
+		t.Errorf("retry executed again after restore")
+	}
+}
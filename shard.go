@@ -0,0 +1,128 @@
+package PaxiBFT
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/salemmohammed/PaxiBFT/lib"
+)
+
+// number of points of every shard on the hash ring, which evens out the key ranges shards own
+const virtualNodes = 64
+
+// Shard returns the name of the replica group node id belongs to, empty if keys are not sharded
+func (c Config) Shard(id ID) string {
+	for name, ids := range c.Shards {
+		for _, i := range ids {
+			if i == id {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// Group returns the replicas in the same group as node id, which is every node if keys are not sharded
+func (c Config) Group(id ID) []ID {
+	if len(c.Shards) == 0 {
+		return c.IDs()
+	}
+	return c.Shards[c.Shard(id)]
+}
+
+// ValidateShards checks every node belongs to exactly one shard, and all shards have the same
+// number of replicas in each zone, since quorum sizes are the same for every group
+func (c Config) ValidateShards() error {
+	if len(c.Shards) == 0 {
+		return nil
+	}
+	seen := make(map[ID]string)
+	var layout string
+	for name, ids := range c.Shards {
+		if len(ids) == 0 {
+			return fmt.Errorf("shard %s has no replicas", name)
+		}
+		if name == "" || strings.Contains(name, "/") {
+			// ids of cross-shard transactions start with the shard name and a slash
+			return fmt.Errorf("invalid shard name %q", name)
+		}
+		npz := make(map[int]int)
+		for _, id := range ids {
+			if _, exists := c.Addrs[id]; !exists {
+				return fmt.Errorf("replica %s of shard %s has no address", id, name)
+			}
+			if s, exists := seen[id]; exists {
+				return fmt.Errorf("replica %s belongs to shards %s and %s", id, s, name)
+			}
+			seen[id] = name
+			npz[id.Zone()]++
+		}
+		l := fmt.Sprint(npz)
+		if layout != "" && l != layout {
+			return fmt.Errorf("shard %s has replicas per zone %s, others have %s", name, l, layout)
+		}
+		layout = l
+	}
+	if len(seen) != len(c.Addrs) {
+		return fmt.Errorf("%d of %d nodes belong to a shard", len(seen), len(c.Addrs))
+	}
+	return nil
+}
+
+// Router maps every key to the shard that owns it through a consistent hash ring of shard names,
+// so adding a shard only moves keys from the shards next to its points on the ring
+type Router struct {
+	ring   *lib.HashRing
+	shards map[string][]ID
+}
+
+// NewRouter returns router of keys to shards, nil if there are no shards
+func NewRouter(shards map[string][]ID) *Router {
+	if len(shards) == 0 {
+		return nil
+	}
+	r := &Router{
+		ring:   new(lib.HashRing),
+		shards: make(map[string][]ID),
+	}
+	names := make([]string, 0, len(shards))
+	for name := range shards {
+		names = append(names, name)
+	}
+	// same ring on every client regardless of map order
+	sort.Strings(names)
+	for _, name := range names {
+		r.Add(name, shards[name])
+	}
+	return r
+}
+
+// Add puts shard with its replicas on the ring
+func (r *Router) Add(name string, ids []ID) {
+	r.shards[name] = ids
+	for i := 0; i < virtualNodes; i++ {
+		r.ring.Insert(name, []byte(name+"#"+strconv.Itoa(i)))
+	}
+}
+
+// Route returns the shard that owns key
+func (r *Router) Route(k Key) string {
+	return r.ring.Get([]byte(k)).(string)
+}
+
+// Replicas returns the replica group that owns key
+func (r *Router) Replicas(k Key) []ID {
+	return r.shards[r.Route(k)]
+}
+
+// Shards returns shard names in order
+func (r *Router) Shards() []string {
+	names := make([]string, 0, len(r.shards))
+	for name := range r.shards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,59 @@
+package PaxiBFT
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	shards := map[string][]ID{
+		"a": {"1.1", "2.1"},
+		"b": {"1.2", "2.2"},
+		"c": {"1.3", "2.3"},
+	}
+	r := NewRouter(shards)
+	owned := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		k := Key(strconv.Itoa(i))
+		owned[r.Route(k)]++
+		if NewRouter(shards).Route(k) != r.Route(k) {
+			t.Fatalf("routers of the same shards disagree on key %s", k)
+		}
+	}
+	for name := range shards {
+		if owned[name] < 500 {
+			t.Errorf("shard %s owns %d of 3000 keys", name, owned[name])
+		}
+	}
+
+	// a new shard only takes keys over from others
+	old := NewRouter(shards)
+	r.Add("d", []ID{"1.4", "2.4"})
+	for i := 0; i < 3000; i++ {
+		k := Key(strconv.Itoa(i))
+		if s := r.Route(k); s != "d" && s != old.Route(k) {
+			t.Fatalf("key %s moved from shard %s to %s", k, old.Route(k), s)
+		}
+	}
+}
+
+func TestValidateShards(t *testing.T) {
+	c := MakeDefaultConfig()
+	c.Addrs = map[ID]string{"1.1": "", "1.2": "", "2.1": "", "2.2": ""}
+	c.Shards = map[string][]ID{"a": {"1.1", "2.1"}, "b": {"1.2", "2.2"}}
+	if err := c.ValidateShards(); err != nil {
+		t.Error(err)
+	}
+	if g := c.Group("2.2"); len(g) != 2 || c.Shard("2.2") != "b" {
+		t.Errorf("group of 2.2 = %v in shard %s", g, c.Shard("2.2"))
+	}
+
+	c.Shards = map[string][]ID{"a": {"1.1", "1.2"}, "b": {"2.1", "2.2"}}
+	if c.ValidateShards() == nil {
+		t.Error("shards with different zones are valid")
+	}
+	c.Shards = map[string][]ID{"a": {"1.1", "2.1"}, "b": {"1.2"}}
+	if c.ValidateShards() == nil {
+		t.Error("node 2.2 without shard is valid")
+	}
+}
@@ -33,19 +33,42 @@ type Socket interface {
 	Slow(id ID, d int, t int)      // delays every message send to ID for d ms and last for t seconds
 	Flaky(id ID, p float64, t int) // drop message by chance p for t seconds
 	Crash(t int)                   // node crash for t seconds
+
+	// Load returns number of messages sent and received on the link of this node, the totals of Traffic
+	Load() Load
+
+	// Peers returns state of the link to every peer this node sent to
+	Peers() map[ID]PeerState
+
+	// Traffic returns messages and bytes sent to and received from every peer by message type
+	Traffic() Traffic
+}
+
+// Load counts messages through the link of one node
+type Load struct {
+	Sent     int64 `json:"sent"`
+	Received int64 `json:"received"`
+
+	Bytes    int64 `json:"bytes"`       // bytes of messages sent and received before compression
+	Wire     int64 `json:"wire"`        // bytes sent and received on the wire
+	Compress int64 `json:"compress_ns"` // time spent compressing and decompressing
 }
 
 type socket struct {
 	id        ID
 	addresses map[ID]string
 	nodes     map[ID]Transport
+	peers     map[ID]bool // replicas of the shard multicasts go to, every node if nil
 
 	crash bool
 	drop  map[ID]bool
 	slow  map[ID]int
 	flaky map[ID]float64
 
-	lock sync.RWMutex // locking map nodes
+	sent     peerCounter // messages sent to every peer by type
+	received peerCounter // messages received from every peer by type
+
+	lock sync.RWMutex // locking map nodes and injected faults
 }
 
 // NewSocket return Socket interface instance given self ID, node list, transport and codec name
@@ -59,6 +82,12 @@ func NewSocket(id ID, addrs map[ID]string) Socket {
 		slow:      make(map[ID]int),
 		flaky:     make(map[ID]float64),
 	}
+	if len(config.Shards) > 0 {
+		socket.peers = make(map[ID]bool)
+		for _, peer := range config.Group(id) {
+			socket.peers[peer] = true
+		}
+	}
 
 	socket.nodes[id] = NewTransport(addrs[id])
 	socket.nodes[id].Listen()
@@ -69,42 +98,29 @@ func NewSocket(id ID, addrs map[ID]string) Socket {
 func (s *socket) Send(to ID, m interface{}) {
 	log.Debugf("node %s send message %+v to %v", s.id, m, to)
 
-	if s.crash {
-		return
-	}
+	s.lock.RLock()
+	crash, drop, p, delay := s.crash, s.drop[to], s.flaky[to], s.slow[to]
+	t, exists := s.nodes[to]
+	s.lock.RUnlock()
 
-	if s.drop[to] {
+	if crash || drop {
 		return
 	}
 
-	if p, ok := s.flaky[to]; ok && p > 0 {
-		if rand.Float64() < p {
-			return
-		}
+	if p > 0 && rand.Float64() < p {
+		return
 	}
 
-	s.lock.RLock()
-	t, exists := s.nodes[to]
-	s.lock.RUnlock()
 	if !exists {
-		s.lock.RLock()
-		address, ok := s.addresses[to]
-		s.lock.RUnlock()
-		if !ok {
-			log.Errorf("socket does not have address of node %s", to)
+		t = s.connect(to)
+		if t == nil {
 			return
 		}
-		t = NewTransport(address)
-		err := Retry(t.Dial, 100, time.Duration(50)*time.Millisecond)
-		if err != nil {
-			panic(err)
-		}
-		s.lock.Lock()
-		s.nodes[to] = t
-		s.lock.Unlock()
 	}
 
-	if delay, ok := s.slow[to]; ok && delay > 0 {
+	s.sent.add(to, m, 1)
+	m = seal(s.id, m)
+	if delay > 0 {
 		timer := time.NewTimer(time.Duration(delay) * time.Millisecond)
 		go func() {
 			<-timer.C
@@ -122,7 +138,15 @@ func (s *socket) Recv() interface{} {
 	s.lock.RUnlock()
 	for {
 		m := t.Recv()
-		if !s.crash {
+		s.lock.RLock()
+		crash := s.crash
+		s.lock.RUnlock()
+		if !crash {
+			s.received.add(sender(m), m, 1)
+			// messages of the default instance go without envelope to the node
+			if e, ok := m.(Envelope); ok && e.Namespace == "" {
+				return e.Message
+			}
 			return m
 		}
 	}
@@ -131,7 +155,7 @@ func (s *socket) Recv() interface{} {
 func (s *socket) MulticastZone(zone int, m interface{}) {
 	//log.Debugf("node %s broadcasting message %+v in zone %d", s.id, m, zone)
 	for id := range s.addresses {
-		if id == s.id {
+		if id == s.id || !s.peer(id) {
 			continue
 		}
 		if id.Zone() == zone {
@@ -144,7 +168,7 @@ func (s *socket) MulticastQuorum(quorum int, m interface{}) {
 	//log.Debugf("node %s multicasting message %+v for %d nodes", s.id, m, quorum)
 	i := 0
 	for id := range s.addresses {
-		if id == s.id {
+		if id == s.id || !s.peer(id) {
 			continue
 		}
 		s.Send(id, m)
@@ -158,13 +182,112 @@ func (s *socket) MulticastQuorum(quorum int, m interface{}) {
 func (s *socket) Broadcast(m interface{}) {
 	//log.Debugf("node %s broadcasting message %+v", s.id, m)
 	for id := range s.addresses {
-		if id == s.id {
+		if id == s.id || !s.peer(id) {
 			continue
 		}
 		s.Send(id, m)
 	}
 }
 
+// peer returns true if node id replicates the same shard, multicasts stay within the group
+func (s *socket) peer(id ID) bool {
+	return s.peers == nil || s.peers[id]
+}
+
+// seal puts message into an envelope from node id, so that the receiver counts it by sender
+func seal(id ID, m interface{}) interface{} {
+	e, ok := m.(Envelope)
+	if !ok {
+		e = Envelope{Message: m}
+	}
+	e.From = id
+	return e
+}
+
+func (s *socket) Load() Load {
+	var l Load
+	for _, types := range s.sent.get() {
+		for _, n := range types {
+			l.Sent += n
+		}
+	}
+	for _, types := range s.received.get() {
+		for _, n := range types {
+			l.Received += n
+		}
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, t := range s.nodes {
+		p := t.State()
+		l.Bytes += p.Bytes
+		l.Wire += p.Wire
+		l.Compress += p.Compress
+	}
+	return l
+}
+
+func (s *socket) Peers() map[ID]PeerState {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	peers := make(map[ID]PeerState)
+	for id, t := range s.nodes {
+		if id != s.id {
+			peers[id] = t.State()
+		}
+	}
+	return peers
+}
+
+// connect registers the transport to peer id, which connects in the background
+// so that senders never wait for a peer that is down
+func (s *socket) connect(id ID) Transport {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if t, exists := s.nodes[id]; exists {
+		return t
+	}
+	address, ok := s.addresses[id]
+	if !ok {
+		log.Errorf("socket does not have address of node %s", id)
+		return nil
+	}
+	t := NewTransport(address)
+	t.Connect()
+	s.nodes[id] = t
+	return t
+}
+
+func (s *socket) Traffic() Traffic {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	t := Traffic{
+		Sent:     make(map[ID]map[string]Count),
+		Received: make(map[ID]map[string]Count),
+	}
+	for id, messages := range s.sent.get() {
+		var bytes map[string]int64
+		if tr, exists := s.nodes[id]; exists {
+			bytes, _ = tr.Traffic()
+		}
+		t.Sent[id] = counts(messages, bytes)
+	}
+	_, bytes := s.nodes[s.id].Traffic()
+	for id, messages := range s.received.get() {
+		t.Received[id] = counts(messages, bytes[id])
+	}
+	return t
+}
+
+// counts pairs numbers of messages with their bytes by message type
+func counts(messages, bytes map[string]int64) map[string]Count {
+	types := make(map[string]Count, len(messages))
+	for name, n := range messages {
+		types[name] = Count{Messages: n, Bytes: bytes[name]}
+	}
+	return types
+}
+
 func (s *socket) Close() {
 	for _, t := range s.nodes {
 		t.Close()
@@ -172,39 +295,36 @@ func (s *socket) Close() {
 }
 
 func (s *socket) Drop(id ID, t int) {
-	s.drop[id] = true
-	timer := time.NewTimer(time.Duration(t) * time.Second)
-	go func() {
-		<-timer.C
-		s.drop[id] = false
-	}()
+	s.fault(func() { s.drop[id] = true }, func() { s.drop[id] = false }, t)
 }
 
 func (s *socket) Slow(id ID, delay int, t int) {
-	s.slow[id] = delay
-	timer := time.NewTimer(time.Duration(t) * time.Second)
-	go func() {
-		<-timer.C
-		s.slow[id] = 0
-	}()
+	s.fault(func() { s.slow[id] = delay }, func() { s.slow[id] = 0 }, t)
 }
 
 func (s *socket) Flaky(id ID, p float64, t int) {
-	s.flaky[id] = p
-	timer := time.NewTimer(time.Duration(t) * time.Second)
-	go func() {
-		<-timer.C
-		s.flaky[id] = 0
-	}()
+	s.fault(func() { s.flaky[id] = p }, func() { s.flaky[id] = 0 }, t)
 }
 
 func (s *socket) Crash(t int) {
-	s.crash = true
+	var stop func()
 	if t > 0 {
-		timer := time.NewTimer(time.Duration(t) * time.Second)
-		go func() {
-			<-timer.C
-			s.crash = false
-		}()
+		stop = func() { s.crash = false }
+	}
+	s.fault(func() { s.crash = true }, stop, t)
+}
+
+// fault injects a fault by start and ends it by stop after t seconds, lasting forever if stop is nil
+func (s *socket) fault(start, stop func(), t int) {
+	s.lock.Lock()
+	start()
+	s.lock.Unlock()
+	if stop == nil {
+		return
 	}
+	time.AfterFunc(time.Duration(t)*time.Second, func() {
+		s.lock.Lock()
+		stop()
+		s.lock.Unlock()
+	})
 }
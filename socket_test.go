@@ -3,6 +3,7 @@ package PaxiBFT
 import (
 	"encoding/gob"
 	"testing"
+	"time"
 )
 
 var id1 = ID("1.1")
@@ -51,3 +52,78 @@ func TestSocket(t *testing.T) {
 	run("tcp", t)
 	run("udp", t)
 }
+
+func TestSocketTraffic(t *testing.T) {
+	gob.Register(MSG{})
+	id3 := ID("1.3")
+	address := map[ID]string{
+		id1: "tcp://127.0.0.1:1745",
+		id2: "tcp://127.0.0.1:1746",
+		id3: "tcp://127.0.0.1:1750",
+	}
+	sock1 := NewSocket(id1, address)
+	defer sock1.Close()
+	sock2 := NewSocket(id2, address)
+	defer sock2.Close()
+	sock3 := NewSocket(id3, address)
+	defer sock3.Close()
+
+	// messages of an instance count by the message in the envelope
+	sock1.Send(id2, MSG{1, "a"})
+	sock1.Send(id2, Envelope{Namespace: "b", Message: MSG{2, "b"}})
+	sock1.Send(id2, Request{Command: Command{Key: "1"}})
+	sock3.Send(id2, MSG{3, "c"})
+	for i := 0; i < 4; i++ {
+		if e, ok := sock2.Recv().(Envelope); ok && e.Namespace == "" {
+			t.Errorf("message %v of the default instance received in envelope", e.Message)
+		}
+	}
+
+	// receiver counts messages by the sender on their envelope
+	sent := sock1.Traffic().Sent[id2]
+	received := sock2.Traffic().Received[id1]
+	for name, n := range map[string]int64{"PaxiBFT.MSG": 2, "PaxiBFT.Request": 1} {
+		if sent[name].Messages != n || received[name].Messages != n {
+			t.Errorf("%s sent %+v and received %+v, expect %d messages", name, sent[name], received[name], n)
+		}
+		if sent[name].Bytes == 0 || received[name].Bytes == 0 {
+			t.Errorf("%s sent %d and received %d bytes", name, sent[name].Bytes, received[name].Bytes)
+		}
+	}
+	if _, exists := sent["PaxiBFT.Envelope"]; exists {
+		t.Errorf("envelope counted as message type: %+v", sent)
+	}
+	if c := sock2.Traffic().Received[id3]["PaxiBFT.MSG"]; c.Messages != 1 || c.Bytes == 0 {
+		t.Errorf("received %+v from %v, expect 1 message", c, id3)
+	}
+}
+
+func TestSocketPeerDown(t *testing.T) {
+	gob.Register(MSG{})
+	address := map[ID]string{
+		id1: "tcp://127.0.0.1:1748",
+		id2: "tcp://127.0.0.1:1749",
+	}
+	sock1 := NewSocket(id1, address)
+	defer sock1.Close()
+
+	// sending to a peer that is down never waits for it to come up
+	start := time.Now()
+	sock1.Send(id2, MSG{1, "buffered"})
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("send to a peer that is down took %v", d)
+	}
+
+	sock2 := NewSocket(id2, address)
+	defer sock2.Close()
+	recv := make(chan interface{}, 1)
+	go func() { recv <- sock2.Recv() }()
+	select {
+	case m := <-recv:
+		if m.(MSG) != (MSG{1, "buffered"}) {
+			t.Errorf("recv message %+v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("message not delivered once peer is up, peers %+v", sock1.Peers())
+	}
+}
@@ -1,12 +1,65 @@
 package PaxiBFT
 
-// StateMachine defines a deterministic state machine
+import (
+	"sync"
+
+	"github.com/salemmohammed/PaxiBFT/log"
+)
+
+// StateMachine defines a deterministic state machine replicated by every protocol,
+// commands are opaque operations to protocols and only interpreted by the state machine
 type StateMachine interface {
 	// Execute is the state-transition function
 	// returns current state value if state unchanged or previous state value
-	Execute(interface{}) interface{}
+	Execute(Command) Value
+
+	// Snapshot encodes the current state
+	Snapshot() ([]byte, error)
+
+	// Restore replaces the current state with a snapshot
+	Restore([]byte) error
+
+	State
 }
 
+// State is the digest of a state machine, equal for replicas that executed the same commands
 type State interface {
 	Hash() uint64
 }
+
+var stateMachines = struct {
+	sync.RWMutex
+	m map[string]func(ID) StateMachine
+}{m: map[string]func(ID) StateMachine{
+	"":        openDatabase,
+	"kv":      openDatabase,
+	"counter": func(ID) StateMachine { return NewCounter() },
+}}
+
+// openDatabase opens the database of node id on the configured storage engine
+func openDatabase(id ID) StateMachine {
+	db, err := OpenDatabase(id)
+	if err != nil {
+		log.Fatalf("cannot open database of node %v: %v", id, err)
+	}
+	return db
+}
+
+// RegisterStateMachine makes an application state machine selectable by name in Config,
+// f creates the state machine of a node, or of its consensus instance ns named id-ns
+func RegisterStateMachine(name string, f func(ID) StateMachine) {
+	stateMachines.Lock()
+	defer stateMachines.Unlock()
+	stateMachines.m[name] = f
+}
+
+// NewStateMachine creates a new state machine registered by name for node id
+func NewStateMachine(name string, id ID) StateMachine {
+	stateMachines.RLock()
+	f, exists := stateMachines.m[name]
+	stateMachines.RUnlock()
+	if !exists {
+		log.Fatalf("unknown state machine %s", name)
+	}
+	return f(id)
+}
@@ -0,0 +1,41 @@
+package PaxiBFT
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStateMachineSnapshot(t *testing.T) {
+	for _, name := range []string{"kv", "counter"} {
+		sm := newExecutor(NewStateMachine(name, "1.1"))
+		sm.Execute(Command{Key: "1", Value: Value("3"), ClientID: "c", CommandID: 1})
+		sm.Execute(Command{Key: "2", Value: Value("4"), ClientID: "c", CommandID: 2})
+
+		b, err := sm.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored := newExecutor(NewStateMachine(name, "1.1"))
+		if restored.Hash() == sm.Hash() {
+			t.Errorf("%s: empty state has same hash as non-empty state", name)
+		}
+		if err := restored.Restore(b); err != nil {
+			t.Fatal(err)
+		}
+		if restored.Hash() != sm.Hash() {
+			t.Errorf("%s: restored hash %x != %x", name, restored.Hash(), sm.Hash())
+		}
+		if s, ok := restored.Session("c"); !ok || s.CommandID != 2 {
+			t.Errorf("%s: session table not restored, got %+v", name, s)
+		}
+	}
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounter()
+	c.Execute(Command{Key: "1", Value: Value("5")})
+	c.Execute(Command{Key: "1", Value: Value("-2\x00\x00")})
+	if v := c.Execute(Command{Key: "1"}); !bytes.Equal(v, Value("3")) {
+		t.Errorf("counter = %s, expected 3", v)
+	}
+}
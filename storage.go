@@ -0,0 +1,311 @@
+package PaxiBFT
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/salemmohammed/PaxiBFT/log"
+)
+
+// StorageConfig selects the storage engine of the key-value database
+type StorageConfig struct {
+	Engine   string `json:"engine"`   // storage engine {memory, file}
+	Dir      string `json:"dir"`      // directory of data files of file engine
+	Sync     string `json:"sync"`     // fsync policy of file engine {none, always, group}
+	Interval int    `json:"interval"` // group commit interval in ms
+}
+
+// Engine persists the writes of database and replays them on startup
+type Engine interface {
+	Put(Key, Value) error
+	Delete(Key) error
+
+	// Replay calls f with every write persisted, in order
+	Replay(f func(k Key, v Value, deleted bool)) error
+
+	// Reset replaces everything persisted with data
+	Reset(data map[Key]Value) error
+
+	Close() error
+}
+
+// NewEngine opens the storage engine of node id from configuration
+func NewEngine(id ID, c StorageConfig) (Engine, error) {
+	switch c.Engine {
+	case "", "memory":
+		return memory{}, nil
+	case "file":
+		return OpenFileEngine(filepath.Join(c.Dir, string(id)+".db"), c.Sync, time.Duration(c.Interval)*time.Millisecond)
+	default:
+		return nil, errors.New("unknown storage engine " + c.Engine)
+	}
+}
+
+// memory engine keeps nothing, the database map is the only copy
+type memory struct{}
+
+func (memory) Put(Key, Value) error                            { return nil }
+func (memory) Delete(Key) error                                { return nil }
+func (memory) Replay(func(k Key, v Value, deleted bool)) error { return nil }
+func (memory) Reset(map[Key]Value) error                       { return nil }
+func (memory) Close() error                                    { return nil }
+
+// record types of file engine
+const (
+	recordPut byte = iota
+	recordDelete
+)
+
+// fileEngine is an append-only log of writes in one file,
+// every record is type, key length, key, value length, value and crc32 of them
+type fileEngine struct {
+	sync.Mutex
+	path  string
+	file  *os.File
+	sync  string
+	dirty bool
+	stop  chan struct{}
+}
+
+// OpenFileEngine opens or creates the append-only file at path with fsync policy
+// none, always (every write) or group (once per interval for all writes in it)
+func OpenFileEngine(path, sync string, interval time.Duration) (Engine, error) {
+	switch sync {
+	case "", "none", "always":
+	case "group":
+		if interval <= 0 {
+			return nil, errors.New("group commit needs a positive interval")
+		}
+	default:
+		return nil, errors.New("unknown fsync policy " + sync)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	e := &fileEngine{
+		path: path,
+		file: file,
+		sync: sync,
+		stop: make(chan struct{}),
+	}
+	if sync == "group" {
+		go e.group(interval)
+	}
+	return e, nil
+}
+
+func (e *fileEngine) Put(k Key, v Value) error {
+	return e.append(recordPut, k, v)
+}
+
+func (e *fileEngine) Delete(k Key) error {
+	return e.append(recordDelete, k, nil)
+}
+
+func (e *fileEngine) append(t byte, k Key, v Value) error {
+	e.Lock()
+	defer e.Unlock()
+	if err := e.write(t, k, v); err != nil {
+		return err
+	}
+	switch e.sync {
+	case "always":
+		return e.file.Sync()
+	case "group":
+		e.dirty = true
+	}
+	return nil
+}
+
+// write encodes one record to file with engine locked
+func (e *fileEngine) write(t byte, k Key, v Value) error {
+	b := make([]byte, 0, 1+2*binary.MaxVarintLen64+len(k)+len(v)+4)
+	b = append(b, t)
+	b = binary.AppendUvarint(b, uint64(len(k)))
+	b = append(b, k...)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	b = append(b, v...)
+	b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+	_, err := e.file.Write(b)
+	return err
+}
+
+// group syncs writes of every interval together
+func (e *fileEngine) group(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.Lock()
+			if e.dirty {
+				if err := e.file.Sync(); err != nil {
+					log.Error(err)
+				}
+				e.dirty = false
+			}
+			e.Unlock()
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// Replay reads records from the start of file and truncates a torn or corrupted tail left by a crash
+func (e *fileEngine) Replay(f func(k Key, v Value, deleted bool)) error {
+	e.Lock()
+	defer e.Unlock()
+	if _, err := e.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := &countReader{r: bufio.NewReader(e.file)}
+	var good int64
+	for {
+		t, k, v, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Warningf("truncate %s at offset %d: %v", e.path, good, err)
+			if err := e.file.Truncate(good); err != nil {
+				return err
+			}
+			break
+		}
+		good = r.n
+		f(k, v, t == recordDelete)
+	}
+	_, err := e.file.Seek(good, io.SeekStart)
+	return err
+}
+
+var errCorrupt = errors.New("corrupted record")
+
+// readRecord reads the next record, returns io.EOF only at a record boundary
+func readRecord(r *countReader) (byte, Key, Value, error) {
+	r.buf = r.buf[:0]
+	t, err := r.ReadByte()
+	if err != nil {
+		return 0, "", nil, err
+	}
+	if t != recordPut && t != recordDelete {
+		return 0, "", nil, errCorrupt
+	}
+	k, err := readBytes(r)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	v, err := readBytes(r)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	sum := crc32.ChecksumIEEE(r.buf)
+	crc := make([]byte, 4)
+	if _, err := io.ReadFull(r, crc); err != nil {
+		return 0, "", nil, unexpected(err)
+	}
+	if binary.BigEndian.Uint32(crc) != sum {
+		return 0, "", nil, errCorrupt
+	}
+	if t == recordDelete {
+		v = nil
+	}
+	return t, Key(k), Value(v), nil
+}
+
+func readBytes(r *countReader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	if n > 1<<31 {
+		return nil, errCorrupt
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, unexpected(err)
+}
+
+// unexpected turns end of file in the middle of a record into a torn record
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// countReader counts bytes read and keeps the bytes of the current record for its checksum
+type countReader struct {
+	r   *bufio.Reader
+	n   int64
+	buf []byte
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	c.buf = append(c.buf, p[:n]...)
+	return n, err
+}
+
+func (c *countReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+		c.buf = append(c.buf, b)
+	}
+	return b, err
+}
+
+// Reset writes data to a new file and renames it over the log, so a crash keeps either of them
+func (e *fileEngine) Reset(data map[Key]Value) error {
+	e.Lock()
+	defer e.Unlock()
+	tmp := e.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	old := e.file
+	e.file = file
+	for k, v := range data {
+		if err := e.write(recordPut, k, v); err != nil {
+			e.file = old
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		e.file = old
+		file.Close()
+		return err
+	}
+	if err := os.Rename(tmp, e.path); err != nil {
+		e.file = old
+		file.Close()
+		return err
+	}
+	e.dirty = false
+	return old.Close()
+}
+
+func (e *fileEngine) Close() error {
+	close(e.stop)
+	e.Lock()
+	defer e.Unlock()
+	if err := e.file.Sync(); err != nil {
+		return err
+	}
+	return e.file.Close()
+}
@@ -0,0 +1,148 @@
+package PaxiBFT
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFileEngineRecovery(t *testing.T) {
+	old := config
+	defer func() { config = old }()
+	c := MakeDefaultConfig()
+	c.Storage = StorageConfig{Engine: "file", Dir: t.TempDir(), Sync: "always"}
+	SetConfig(c)
+
+	db, err := OpenDatabase("1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Execute(Command{Key: "a", Value: Value("1")})
+	db.Execute(Command{Key: "b", Value: Value("2")})
+	db.Execute(Command{Key: "a", Value: Value("3")})
+	db.Execute(Delete("b"))
+	db.(*database).engine.Close()
+
+	// torn record of a crash in the middle of a write
+	path := filepath.Join(c.Storage.Dir, "1.1.db")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{recordPut, 5, 'c'})
+	f.Close()
+
+	db, err = OpenDatabase("1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := db.Get("a"); string(v) != "3" {
+		t.Errorf("recovered a = %s, expected 3", v)
+	}
+	if v := db.Get("b"); v != nil {
+		t.Errorf("recovered deleted b = %s", v)
+	}
+
+	// writes after recovery append after the truncated tail
+	db.Execute(Command{Key: "c", Value: Value("4")})
+	db.(*database).engine.Close()
+	db, err = OpenDatabase("1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := db.Get("c"); string(v) != "4" {
+		t.Errorf("recovered c = %s, expected 4", v)
+	}
+
+	// restore replaces everything persisted
+	snapshot, _ := NewDatabase().Snapshot()
+	if err := db.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	db.(*database).engine.Close()
+	db, err = OpenDatabase("1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.Scan("", "", 0)) != 0 {
+		t.Errorf("restored empty snapshot recovers keys")
+	}
+	db.(*database).engine.Close()
+}
+
+func TestFileEngineGroupCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "group.db")
+	e, err := OpenFileEngine(path, "group", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Put("a", Value("1"))
+	time.Sleep(10 * time.Millisecond)
+	f := e.(*fileEngine)
+	f.Lock()
+	dirty := f.dirty
+	f.Unlock()
+	if dirty {
+		t.Errorf("write not synced after group commit interval")
+	}
+	e.Close()
+
+	if _, err := OpenFileEngine(path, "group", 0); err == nil {
+		t.Errorf("group commit without interval")
+	}
+}
+
+// BenchmarkStorage compares put latency of the in-memory and file engines
+func BenchmarkStorage(b *testing.B) {
+	engines := []StorageConfig{
+		{Engine: "memory"},
+		{Engine: "file", Sync: "none"},
+		{Engine: "file", Sync: "group", Interval: 10},
+		{Engine: "file", Sync: "always"},
+	}
+	value := GenerateRandVal(128)
+	for _, s := range engines {
+		b.Run(s.Engine+"/"+s.Sync, func(b *testing.B) {
+			s.Dir = b.TempDir()
+			e, err := NewEngine("1.1", s)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer e.Close()
+			d := NewDatabase().(*database)
+			d.engine = e
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				d.Put(Key(strconv.Itoa(i)), value)
+			}
+		})
+	}
+}
+
+func TestInstanceStorage(t *testing.T) {
+	old := config
+	defer func() { config = old }()
+	c := MakeDefaultConfig()
+	c.Addrs = map[ID]string{"1.1": "chan://127.0.0.1:1747"}
+	c.Storage = StorageConfig{Engine: "file", Dir: t.TempDir(), Sync: "always"}
+	SetConfig(c)
+
+	n := NewNode("1.1")
+	defer n.Close()
+	n.Execute(Command{Key: "a", Value: Value("1")})
+	n.Instance("b").Execute(Command{Key: "a", Value: Value("2")})
+
+	// every instance replays only its own writes
+	for id, v := range map[ID]string{"1.1": "1", "1.1-b": "2"} {
+		db, err := OpenDatabase(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := db.Get("a"); string(got) != v {
+			t.Errorf("database %s recovered a = %s, expected %s", id, got, v)
+		}
+		db.(*database).engine.Close()
+	}
+}
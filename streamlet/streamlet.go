@@ -1,13 +1,24 @@
 package streamlet
 
 import (
+	"bytes"
 	"crypto/md5"
 	"github.com/salemmohammed/PaxiBFT"
 	"github.com/salemmohammed/PaxiBFT/log"
 	"strconv"
+	"strings"
 	"time"
 )
 
+const walVote = "vote/"
+const walCommit = "commit/"
+
+// vote is the digest this replica voted for in a slot, persisted before the vote is sent
+type vote struct {
+	Ballot PaxiBFT.Ballot
+	Digest []byte
+}
+
 type status int8
 const (
 	NONE status = iota
@@ -49,6 +60,7 @@ type Streamlet struct {
 	Delta 						int
 	Node_ID                     PaxiBFT.ID
 	Sent                        bool
+	votes						map[int]vote				// votes persisted in write-ahead log
 }
 func NewStreamlet(n PaxiBFT.Node, options ...func(*Streamlet)) *Streamlet {
 	p := &Streamlet{
@@ -58,13 +70,66 @@ func NewStreamlet(n PaxiBFT.Node, options ...func(*Streamlet)) *Streamlet {
 		Requests:      	 	make([]*PaxiBFT.Request, 0),
 		Leader:				false,
 		Delta:				0,
+		votes:				make(map[int]vote),
 	}
 	for _, opt := range options {
 		opt(p)
 	}
+	if n.WAL().Recovering() {
+		p.recover()
+	}
 	return p
 }
 
+// recover restores votes and ballot from write-ahead log,
+// and executes the committed prefix again to rebuild the state machine
+func (p *Streamlet) recover() {
+	w := p.WAL()
+	for _, k := range w.Keys(walVote) {
+		s, err := strconv.Atoi(strings.TrimPrefix(k, walVote))
+		if err != nil {
+			continue
+		}
+		var v vote
+		if !w.Get(k, &v) {
+			continue
+		}
+		p.votes[s] = v
+		if v.Ballot > p.ballot {
+			p.ballot = v.Ballot
+		}
+	}
+	var cmd PaxiBFT.Command
+	for w.Get(walCommit+strconv.Itoa(p.execute), &cmd) {
+		p.Execute(cmd)
+		p.execute++
+		cmd = PaxiBFT.Command{}
+	}
+	// replies of replayed slots come from the client session table
+	p.slot = p.execute - 1
+	log.Infof("Replica %s recovered %d votes and executed up to %d", p.ID(), len(p.votes), p.execute)
+}
+
+// vote persists vote for digest of slot s in ballot before it is sent,
+// returns false if this replica already voted for another digest of slot s in the same ballot
+func (p *Streamlet) vote(s int, ballot PaxiBFT.Ballot, digest []byte) bool {
+	if p.WAL() == nil {
+		return true
+	}
+	v, exists := p.votes[s]
+	if exists && v.Ballot == ballot {
+		if !bytes.Equal(v.Digest, digest) {
+			log.Warningf("Replica %s refuses to vote for slot %d again in ballot %v", p.ID(), s, ballot)
+			return false
+		}
+		return true
+	}
+	v = vote{Ballot: ballot, Digest: digest}
+	p.WAL().Put(walVote+strconv.Itoa(s), v)
+	p.votes[s] = v
+	return true
+}
+
 func GetMD5Hash(r *PaxiBFT.Request) []byte {
 	hasher := md5.New()
 	hasher.Write([]byte(r.Command.Value))
@@ -113,6 +178,9 @@ func (p *Streamlet) handlePropose(m Propose) {
 		}
 	}
 	e = p.log[m.Slot]
+	if !p.vote(m.Slot, m.Ballot, GetMD5Hash(&m.Request)) {
+		return
+	}
 	e.Pstatus = PREPARED
 	//time.Sleep(500 * time.Millisecond)
 	p.Broadcast(Vote{
@@ -180,6 +248,7 @@ func (p *Streamlet) exec() {
 				log.Debugf("Break")
 				break
 			}
+			p.WAL().Put(walCommit+strconv.Itoa(p.execute), e.request.Command)
 			value := p.Execute(e.request.Command)
 			reply := PaxiBFT.Reply{
 				Command:    e.request.Command,
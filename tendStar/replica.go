@@ -31,9 +31,9 @@ func NewReplica(id PaxiBFT.ID) *Replica {
 	r.Tendermint = NewTendermint(r)
 
 	r.Register(PaxiBFT.Request{},  r.handleRequest)
-	r.Register(Propose{},       r.handlePropose)
-	r.Register(PreVote{},       r.HandlePreVote)
-	r.Register(PreCommit{},     r.HandlePreCommit)
+	r.tree.Register(Propose{},       r.handlePropose)
+	r.tree.Register(PreVote{},       r.HandlePreVote)
+	r.tree.Register(PreCommit{},     r.HandlePreCommit)
 	r.Register(ActPropose{},    r.HandleActPropose)
 	r.Register(ActPreCommit{},  r.HandleActPreCommit)
 	r.Register(ActPreVote{},  r.HandleActPreVote)
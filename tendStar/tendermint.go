@@ -4,6 +4,7 @@ import (
 	//"container/list"
 	"github.com/salemmohammed/PaxiBFT"
 	"github.com/salemmohammed/PaxiBFT/log"
+	"github.com/salemmohammed/PaxiBFT/tree"
 	"strconv"
 	"sync"
 	"time"
@@ -75,6 +76,7 @@ type Tendermint struct {
 	Sent          				bool
 	MyTurn        				bool
 	Node_ID                     PaxiBFT.ID
+	tree						*tree.Tree					// dissemination of proposals and votes
 }
 // NewPaxos creates new paxos instance
 func NewTendermint(n PaxiBFT.Node, options ...func(*Tendermint)) *Tendermint {
@@ -92,6 +94,7 @@ func NewTendermint(n PaxiBFT.Node, options ...func(*Tendermint)) *Tendermint {
 		EarlyPropose:		false,
 		Leader:				false,
 		Plist:				make([]PaxiBFT.ID,0),
+		tree:				tree.NewTree(n),
 	}
 	for _, opt := range options {
 		opt(p)
@@ -105,7 +108,7 @@ func (p *Tendermint) HandleRequest(r PaxiBFT.Request, s int, total int) {
 	log.Debugf("\n<---R----HandleRequest----R------>\n")
 	log.Debugf("Sender ID %v, slot=%v", r.NodeID, s)
 
-	p.Broadcast(Propose{
+	p.tree.Multicast(Propose{
 		Ballot:     p.ballot,
 		ID:         p.ID(),
 		Request:    r,
@@ -150,7 +153,7 @@ func (p *Tendermint) handlePropose(m Propose) {
 		}
 	}
 	e = p.log[m.Slot]
-	p.Send(m.ID, ActPropose{
+	p.tree.Vote(m.ID, ActPropose{
 		Ballot:     p.ballot,
 		ID:         p.ID(),
 		Slot:       m.Slot,
@@ -174,7 +177,7 @@ func (p *Tendermint) HandleActPropose(m ActPropose) {
 	e.Q1.ACK(m.ID)
 	if e.active && e.Q1.Majority(){
 		e.Q1.Reset()
-		p.Broadcast(PreVote{
+		p.tree.Multicast(PreVote{
 		Ballot:     p.ballot,
 		ID:         p.ID(),
 		Slot:       m.Slot,
@@ -193,7 +196,7 @@ func (p *Tendermint) HandlePreVote(m PreVote) {
 		p.ballot = m.Ballot
 	}
 
-	p.Send(m.ID, ActPreVote{
+	p.tree.Vote(m.ID, ActPreVote{
 		Ballot:     p.ballot,
 		ID:         p.ID(),
 		Slot:       m.Slot,
@@ -217,7 +220,7 @@ func (p *Tendermint) HandleActPreVote(m ActPreVote) {
 	e.Q2.ACK(m.ID)
 	if e.active && e.Q2.Majority(){
 		e.Q2.Reset()
-		p.Broadcast(PreCommit{
+		p.tree.Multicast(PreCommit{
 			Ballot:     p.ballot,
 			ID:         p.ID(),
 			Slot:       m.Slot,
@@ -237,7 +240,7 @@ func (p *Tendermint) HandlePreCommit(m PreCommit) {
 	}
 
 	if p.ID() != m.ID{
-		p.Send(m.ID, ActPreCommit{
+		p.tree.Vote(m.ID, ActPreCommit{
 			Ballot:  p.ballot,
 			ID:      p.ID(),
 			Slot:    m.Slot,
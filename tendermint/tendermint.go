@@ -1,12 +1,25 @@
 package tendermint
 
 import (
+	"bytes"
 	"github.com/salemmohammed/PaxiBFT"
 	"github.com/salemmohammed/PaxiBFT/log"
+	"github.com/salemmohammed/PaxiBFT/payload"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
+
+const walVote = "vote/"
+const walCommit = "commit/"
+
+// vote is the digest this replica voted for in a slot, persisted before the vote is sent
+type vote struct {
+	Ballot PaxiBFT.Ballot
+	Digest []byte
+}
+
 type status int8
 const (
 	NONE status = iota
@@ -57,6 +70,7 @@ type Tendermint struct {
 	Sent          bool
 	MyTurn        bool
 	Node_ID     PaxiBFT.ID
+	votes        map[int]vote       // votes persisted in write-ahead log
 
 }
 func NewTendermint(n PaxiBFT.Node, options ...func(*Tendermint)) *Tendermint {
@@ -71,13 +85,66 @@ func NewTendermint(n PaxiBFT.Node, options ...func(*Tendermint)) *Tendermint {
 		EarlyPropose:		false,
 		Leader:				false,
 		Plist:				make([]PaxiBFT.ID,0),
+		votes:				make(map[int]vote),
 	}
 
 	for _, opt := range options {
 		opt(p)
 	}
+	if n.WAL().Recovering() {
+		p.recover()
+	}
 	return p
 }
+
+// recover restores votes and ballot from write-ahead log,
+// and executes the committed prefix again to rebuild the state machine
+func (p *Tendermint) recover() {
+	w := p.WAL()
+	for _, k := range w.Keys(walVote) {
+		s, err := strconv.Atoi(strings.TrimPrefix(k, walVote))
+		if err != nil {
+			continue
+		}
+		var v vote
+		if !w.Get(k, &v) {
+			continue
+		}
+		p.votes[s] = v
+		if v.Ballot > p.ballot {
+			p.ballot = v.Ballot
+		}
+	}
+	var cmd PaxiBFT.Command
+	for w.Get(walCommit+strconv.Itoa(p.execute), &cmd) {
+		p.Execute(cmd)
+		p.execute++
+		cmd = PaxiBFT.Command{}
+	}
+	// replies of replayed slots come from the client session table
+	p.slot = p.execute - 1
+	log.Infof("Replica %s recovered %d votes and executed up to %d", p.ID(), len(p.votes), p.execute)
+}
+
+// vote persists vote for digest of slot s in ballot before it is sent,
+// returns false if this replica already voted for another digest of slot s in the same ballot
+func (p *Tendermint) vote(s int, ballot PaxiBFT.Ballot, digest []byte) bool {
+	if p.WAL() == nil {
+		return true
+	}
+	v, exists := p.votes[s]
+	if exists && v.Ballot == ballot {
+		if !bytes.Equal(v.Digest, digest) {
+			log.Warningf("Replica %s refuses to vote for slot %d again in ballot %v", p.ID(), s, ballot)
+			return false
+		}
+		return true
+	}
+	v = vote{Ballot: ballot, Digest: digest}
+	p.WAL().Put(walVote+strconv.Itoa(s), v)
+	p.votes[s] = v
+	return true
+}
 func (p *Tendermint) HandleRequest(r PaxiBFT.Request, slot int,total int) {
 	log.Debugf("<---R----HandleRequest----R------>\n")
 
@@ -141,6 +208,9 @@ func (p *Tendermint) handlePropose(m Propose) {
 		}
 	}
 	e = p.log[m.Slot]
+	if !p.vote(m.Slot, m.Ballot, payload.Digest(m.Request.Command)) {
+		return
+	}
 	e.Pstatus = PREPARED
 	for _, i1 := range m.ID_LIST_PR.AID{
 		flagMatch := false
@@ -237,6 +307,9 @@ func (p *Tendermint) HandlePreVote(m PreVote) {
 		log.Debugf("We cannot allocate the log b/c prevote b/f request")
 		return
 	}
+	if !p.vote(m.Slot, m.Ballot, payload.Digest(m.Request.Command)) {
+		return
+	}
 	for _, i1 := range m.ID_LIST_PV.AID{
 		flagMatch := false
 		for _, i2 := range e.PV.AID{
@@ -339,6 +412,9 @@ func (p *Tendermint) HandlePreCommit(m PreCommit) {
 		log.Debugf("Not consistent")
 		return
 	}
+	if !p.vote(m.Slot, m.Ballot, payload.Digest(m.Request.Command)) {
+		return
+	}
 	for _, i1 := range m.ID_LIST_PC.AID{
 		flagMatch := false
 		for _, i2 := range e.PC.AID{
@@ -400,6 +476,7 @@ func (p *Tendermint) exec() {
 			log.Debugf("break")
 			break
 		}
+		p.WAL().Put(walCommit+strconv.Itoa(p.execute), e.request.Command)
 		value := p.Execute(e.request.Command)
 		if e.request != nil && e.active && e.Leader {
 			reply := PaxiBFT.Reply{
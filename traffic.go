@@ -0,0 +1,110 @@
+package PaxiBFT
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Count is a number of messages and their bytes on the wire, which are zero over the chan transport
+type Count struct {
+	Messages int64 `json:"messages"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// Traffic is what a node sent to every peer and received from every peer, by message type.
+// The sender of a received message is the one its socket put on the envelope.
+type Traffic struct {
+	Sent     map[ID]map[string]Count `json:"sent"`
+	Received map[ID]map[string]Count `json:"received"`
+}
+
+// Total returns messages sent to every peer by type
+func (t Traffic) Total() map[string]Count {
+	total := make(map[string]Count)
+	for _, types := range t.Sent {
+		for name, c := range types {
+			s := total[name]
+			s.Messages += c.Messages
+			s.Bytes += c.Bytes
+			total[name] = s
+		}
+	}
+	return total
+}
+
+// sender returns the node that sent message in an envelope, empty if unknown
+func sender(m interface{}) ID {
+	if e, ok := m.(Envelope); ok {
+		return e.From
+	}
+	return ""
+}
+
+// messageType names the type of message, or of the message in an envelope
+func messageType(m interface{}) string {
+	if e, ok := m.(Envelope); ok {
+		m = e.Message
+	}
+	if m == nil {
+		return "nil"
+	}
+	return reflect.TypeOf(m).String()
+}
+
+// typeCounter counts messages or bytes by message type
+type typeCounter struct {
+	sync.Mutex
+	types map[string]int64
+}
+
+func (c *typeCounter) add(m interface{}, n int64) {
+	name := messageType(m)
+	c.Lock()
+	if c.types == nil {
+		c.types = make(map[string]int64)
+	}
+	c.types[name] += n
+	c.Unlock()
+}
+
+// get returns a copy of the counts
+func (c *typeCounter) get() map[string]int64 {
+	c.Lock()
+	defer c.Unlock()
+	types := make(map[string]int64, len(c.types))
+	for name, n := range c.types {
+		types[name] = n
+	}
+	return types
+}
+
+// peerCounter counts messages or bytes by peer and message type
+type peerCounter struct {
+	sync.Mutex
+	peers map[ID]*typeCounter
+}
+
+func (c *peerCounter) add(id ID, m interface{}, n int64) {
+	c.Lock()
+	if c.peers == nil {
+		c.peers = make(map[ID]*typeCounter)
+	}
+	t, exists := c.peers[id]
+	if !exists {
+		t = new(typeCounter)
+		c.peers[id] = t
+	}
+	c.Unlock()
+	t.add(m, n)
+}
+
+// get returns a copy of the counts of every peer
+func (c *peerCounter) get() map[ID]map[string]int64 {
+	c.Lock()
+	defer c.Unlock()
+	peers := make(map[ID]map[string]int64, len(c.peers))
+	for id, t := range c.peers {
+		peers[id] = t.get()
+	}
+	return peers
+}
@@ -1,14 +1,16 @@
 package PaxiBFT
 
 import (
-	"bytes"
-	"encoding/gob"
+	"bufio"
 	"errors"
 	"flag"
+	"io"
 	"net"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/salemmohammed/PaxiBFT/log"
 )
@@ -29,13 +31,45 @@ type Transport interface {
 	// Dial connects to remote server non-blocking once connected
 	Dial() error
 
+	// Connect dials remote server in the background until connected or closed,
+	// messages sent meanwhile wait in the queue or are dropped by reconnect policy
+	Connect()
+
 	// Listen waits for connections, non-blocking once listener starts
 	Listen()
 
 	// Close closes send channel and stops listener
 	Close()
+
+	// State returns state of the connection to remote server
+	State() PeerState
+
+	// Traffic returns bytes on the wire of messages sent by message type and received by sender and message type
+	Traffic() (sent map[string]int64, received map[ID]map[string]int64)
 }
 
+// PeerState is the state of the link to one peer
+type PeerState struct {
+	State    string `json:"state"`    // connecting, connected, backoff or closed
+	Dials    int64  `json:"dials"`    // connections established
+	Failures int64  `json:"failures"` // failed dials and broken connections
+	Dropped  int64  `json:"dropped"`  // messages dropped by a broken connection or while disconnected
+
+	Bytes    int64 `json:"bytes"`       // bytes of framed messages before compression, sent or received
+	Wire     int64 `json:"wire"`        // bytes of frames on the wire
+	Compress int64 `json:"compress_ns"` // time spent compressing and decompressing
+}
+
+// ReconnectConfig is how tcp links redial broken connections
+type ReconnectConfig struct {
+	Backoff    int    `json:"backoff"`     // delay in ms before the first redial, doubled after every failed one
+	MaxBackoff int    `json:"max_backoff"` // maximum delay in ms between redials
+	Policy     string `json:"policy"`      // messages sent while disconnected {buffer, drop}, buffered up to chan_buffer_size
+}
+
+// tcpTimeout bounds dialing and writing one message, a peer that takes longer is disconnected
+const tcpTimeout = 5 * time.Second
+
 // NewTransport creates new transport object with url
 func NewTransport(addr string) Transport {
 	if !strings.Contains(addr, "://") {
@@ -51,6 +85,9 @@ func NewTransport(addr string) Transport {
 		send:  make(chan interface{}, config.ChanBufferSize),
 		recv:  make(chan interface{}, config.ChanBufferSize),
 		close: make(chan struct{}),
+		codec: config.Codec,
+		zip:   config.Compression,
+		state: PeerState{State: "connecting"},
 	}
 
 	switch uri.Scheme {
@@ -77,6 +114,12 @@ type transport struct {
 	send  chan interface{}
 	recv  chan interface{}
 	close chan struct{}
+	codec string            // codec of messages on the wire
+	zip   CompressionConfig // compression of messages on the wire
+	stats wireStats
+
+	mu    sync.Mutex // locking state
+	state PeerState
 }
 
 func (t *transport) Send(m interface{}) {
@@ -96,26 +139,25 @@ func (t *transport) Scheme() string {
 	return t.uri.Scheme
 }
 
-func (t *transport) Dial() error {
-	conn, err := net.Dial(t.Scheme(), t.uri.Host)
-	if err != nil {
-		return err
-	}
+func (t *transport) State() PeerState {
+	t.mu.Lock()
+	s := t.state
+	t.mu.Unlock()
+	s.Bytes = atomic.LoadInt64(&t.stats.bytes)
+	s.Wire = atomic.LoadInt64(&t.stats.wire)
+	s.Compress = atomic.LoadInt64(&t.stats.nanos)
+	return s
+}
 
-	go func(conn net.Conn) {
-		// w := bufio.NewWriter(conn)
-		// codec := NewCodec(config.Codec, conn)
-		encoder := gob.NewEncoder(conn)
-		defer conn.Close()
-		for m := range t.send {
-			err := encoder.Encode(&m)
-			if err != nil {
-				log.Error(err)
-			}
-		}
-	}(conn)
+func (t *transport) Traffic() (sent map[string]int64, received map[ID]map[string]int64) {
+	return t.stats.sent.get(), t.stats.received.get()
+}
 
-	return nil
+// update changes state of the link
+func (t *transport) update(f func(s *PeerState)) {
+	t.mu.Lock()
+	f(&t.state)
+	t.mu.Unlock()
 }
 
 /******************************
@@ -123,6 +165,120 @@ func (t *transport) Dial() error {
 /******************************/
 type tcp struct {
 	*transport
+
+	lock     sync.Mutex // locking listener and conns
+	listener net.Listener
+	conns    map[net.Conn]bool // accepted connections
+}
+
+// Send queues message for the connection, which blocks while connected and the queue is full.
+// While disconnected messages are buffered as long as the queue has room or dropped by policy.
+func (t *tcp) Send(m interface{}) {
+	s := t.State().State
+	if s == "connected" {
+		t.send <- m
+		return
+	}
+	if config.Reconnect.Policy != "drop" {
+		select {
+		case t.send <- m:
+			return
+		default:
+		}
+	}
+	t.update(func(s *PeerState) { s.Dropped++ })
+}
+
+// Dial connects to remote server once, then keeps the link up in the background
+// by redialing broken connections with exponential backoff until closed
+func (t *tcp) Dial() error {
+	conn, err := net.DialTimeout("tcp", t.uri.Host, tcpTimeout)
+	if err != nil {
+		t.update(func(s *PeerState) { s.Failures++ })
+		return err
+	}
+	go t.connect(conn)
+	return nil
+}
+
+func (t *tcp) Connect() {
+	go t.connect(nil)
+}
+
+// backoff returns delay before the first redial and maximum delay between redials
+func backoff() (time.Duration, time.Duration) {
+	delay := time.Duration(config.Reconnect.Backoff) * time.Millisecond
+	if delay <= 0 {
+		delay = 50 * time.Millisecond
+	}
+	return delay, time.Duration(config.Reconnect.MaxBackoff) * time.Millisecond
+}
+
+// connect writes messages to connection and redials it once broken, or dials first if conn is nil
+func (t *tcp) connect(conn net.Conn) {
+	backoff, max := backoff()
+	delay := backoff
+	for {
+		if conn != nil {
+			delay = backoff
+			t.update(func(s *PeerState) { s.State = "connected"; s.Dials++ })
+			err := t.write(conn)
+			conn.Close()
+			if err == nil {
+				t.update(func(s *PeerState) { s.State = "closed" })
+				return
+			}
+			log.Warningf("connection to %s broken: %v", t.uri.Host, err)
+			t.update(func(s *PeerState) { s.State = "connecting"; s.Failures++ })
+		}
+
+		var err error
+		conn, err = net.DialTimeout("tcp", t.uri.Host, tcpTimeout)
+		if err == nil {
+			continue
+		}
+		t.update(func(s *PeerState) { s.State = "backoff"; s.Failures++ })
+		select {
+		case <-t.close:
+			t.update(func(s *PeerState) { s.State = "closed" })
+			return
+		case <-time.After(delay):
+		}
+		t.update(func(s *PeerState) { s.State = "connecting" })
+		if delay *= 2; max > 0 && delay > max {
+			delay = max
+		}
+	}
+}
+
+// write encodes messages to connection until send channel is closed, which returns nil,
+// or connection breaks. Remote server never writes back, so a read returns once it goes away.
+func (t *tcp) write(conn net.Conn) error {
+	broken := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		if err == nil {
+			err = errors.New("unexpected data from server")
+		}
+		broken <- err
+	}()
+
+	codec := newWire(t.codec, t.zip, conn, &t.stats)
+	for {
+		select {
+		case err := <-broken:
+			return err
+		case m, ok := <-t.send:
+			if !ok {
+				return nil
+			}
+			conn.SetWriteDeadline(time.Now().Add(tcpTimeout))
+			if err := codec.Encode(&m); err != nil {
+				t.update(func(s *PeerState) { s.Dropped++ })
+				return err
+			}
+		}
+	}
 }
 
 func (t *tcp) Listen() {
@@ -131,33 +287,53 @@ func (t *tcp) Listen() {
 	if err != nil {
 		log.Fatal("TCP Listener error: ", err)
 	}
+	t.lock.Lock()
+	t.listener = listener
+	t.conns = make(map[net.Conn]bool)
+	t.lock.Unlock()
 
 	go func(listener net.Listener) {
 		defer listener.Close()
 		for {
 			conn, err := listener.Accept()
 			if err != nil {
+				select {
+				case <-t.close:
+					return
+				default:
+				}
 				log.Error("TCP Accept error: ", err)
 				continue
 			}
+			t.lock.Lock()
+			t.conns[conn] = true
+			t.lock.Unlock()
 
 			go func(conn net.Conn) {
-				// codec := NewCodec(config.Codec, conn)
-				decoder := gob.NewDecoder(conn)
-				defer conn.Close()
-				//r := bufio.NewReader(conn)
+				codec := newWire(t.codec, t.zip, struct {
+					io.Reader
+					io.Writer
+				}{bufio.NewReader(conn), conn}, &t.stats)
+				defer func() {
+					conn.Close()
+					t.lock.Lock()
+					delete(t.conns, conn)
+					t.lock.Unlock()
+				}()
 				for {
+					var m interface{}
+					err := codec.Decode(&m)
+					if err != nil {
+						// the client redials a broken connection
+						if err != io.EOF {
+							log.Error(err)
+						}
+						return
+					}
 					select {
 					case <-t.close:
 						return
-					default:
-						var m interface{}
-						err := decoder.Decode(&m)
-						if err != nil {
-							log.Error(err)
-							continue
-						}
-						t.recv <- m
+					case t.recv <- m:
 					}
 				}
 			}(conn)
@@ -166,69 +342,18 @@ func (t *tcp) Listen() {
 	}(listener)
 }
 
-/******************************
-/*     UDP communication      *
-/******************************/
-type udp struct {
-	*transport
-}
-
-func (u *udp) Dial() error {
-	addr, err := net.ResolveUDPAddr("udp", u.uri.Host)
-	if err != nil {
-		log.Fatal("UDP resolve address error: ", err)
+// Close stops the connection to remote server, and the listener with its accepted
+// connections, so that clients notice and a restarted server can listen on the port
+func (t *tcp) Close() {
+	t.transport.Close()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.listener != nil {
+		t.listener.Close()
 	}
-	conn, err := net.DialUDP("udp", nil, addr)
-	if err != nil {
-		return err
+	for conn := range t.conns {
+		conn.Close()
 	}
-
-	go func(conn *net.UDPConn) {
-		// packet := make([]byte, 1500)
-		// w := bytes.NewBuffer(packet)
-		w := new(bytes.Buffer)
-		for m := range u.send {
-			gob.NewEncoder(w).Encode(&m)
-			_, err := conn.Write(w.Bytes())
-			if err != nil {
-				log.Error(err)
-			}
-			w.Reset()
-		}
-	}(conn)
-
-	return nil
-}
-
-func (u *udp) Listen() {
-	addr, err := net.ResolveUDPAddr("udp", ":"+u.uri.Port())
-	if err != nil {
-		log.Fatal("UDP resolve address error: ", err)
-	}
-	conn, err := net.ListenUDP("udp", addr)
-	if err != nil {
-		log.Fatal("UDP Listener error: ", err)
-	}
-	go func(conn *net.UDPConn) {
-		packet := make([]byte, 1500)
-		defer conn.Close()
-		for {
-			select {
-			case <-u.close:
-				return
-			default:
-				_, err := conn.Read(packet)
-				if err != nil {
-					log.Error(err)
-					continue
-				}
-				r := bytes.NewReader(packet)
-				var m interface{}
-				gob.NewDecoder(r).Decode(&m)
-				u.recv <- m
-			}
-		}
-	}(conn)
 }
 
 /*******************************
@@ -251,8 +376,10 @@ func (c *channel) Dial() error {
 	defer chansLock.RUnlock()
 	conn, ok := chans[c.uri.Host]
 	if !ok {
+		c.update(func(s *PeerState) { s.Failures++ })
 		return errors.New("server not ready")
 	}
+	c.update(func(s *PeerState) { s.State = "connected"; s.Dials++ })
 	go func(conn chan<- interface{}) {
 		for m := range c.send {
 			conn <- m
@@ -261,10 +388,29 @@ func (c *channel) Dial() error {
 	return nil
 }
 
+func (c *channel) Connect() {
+	go func() {
+		delay, max := backoff()
+		for c.Dial() != nil {
+			select {
+			case <-c.close:
+				return
+			case <-time.After(delay):
+			}
+			if delay *= 2; max > 0 && delay > max {
+				delay = max
+			}
+		}
+	}()
+}
+
 func (c *channel) Listen() {
 	chansLock.Lock()
 	defer chansLock.Unlock()
-	chans[c.uri.Host] = make(chan interface{}, config.ChanBufferSize)
+	// a restarted node listens on the channel its peers already dialed
+	if _, exists := chans[c.uri.Host]; !exists {
+		chans[c.uri.Host] = make(chan interface{}, config.ChanBufferSize)
+	}
 	go func(conn <-chan interface{}) {
 		for {
 			select {
@@ -2,7 +2,9 @@ package PaxiBFT
 
 import (
 	"encoding/gob"
+	"fmt"
 	"testing"
+	"time"
 )
 
 func TestTransport(t *testing.T) {
@@ -55,3 +57,47 @@ func TestTransport(t *testing.T) {
 		t.Error()
 	}
 }
+
+func TestTransportReconnect(t *testing.T) {
+	gob.Register(A{})
+
+	server := NewTransport("tcp://127.0.0.1:1738")
+	server.Listen()
+	client := NewTransport("tcp://127.0.0.1:1738")
+	defer client.Close()
+	if err := client.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	client.Send(A{I: 1})
+	if m := server.Recv(); m.(A).I != 1 {
+		t.Fatalf("recv message %+v", m)
+	}
+
+	// server restarts on the same port, messages sent in between are buffered
+	server.Close()
+	err := Retry(func() error {
+		if s := client.State(); s.State == "connected" {
+			return fmt.Errorf("connection state %+v", s)
+		}
+		return nil
+	}, 50, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Send(A{I: 2})
+	server = NewTransport("tcp://127.0.0.1:1738")
+	server.Listen()
+	defer server.Close()
+
+	select {
+	case m := <-server.(*tcp).recv:
+		if m.(A).I != 2 {
+			t.Errorf("recv message %+v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("message not delivered after reconnect, state %+v", client.State())
+	}
+	if s := client.State(); s.State != "connected" || s.Dials != 2 || s.Failures < 1 {
+		t.Errorf("connection state %+v after reconnect", s)
+	}
+}
@@ -0,0 +1,43 @@
+package tree
+
+import (
+	"encoding/gob"
+	"fmt"
+
+	"github.com/salemmohammed/PaxiBFT"
+)
+
+func init() {
+	gob.Register(Down{})
+	gob.Register(Up{})
+}
+
+// Down carries a multicast message of root down the tree
+type Down struct {
+	Root PaxiBFT.ID
+	Seq  int
+	Star bool // sent directly by root, votes go straight back to root
+	Msg  interface{}
+}
+
+func (m Down) String() string {
+	return fmt.Sprintf("Down {Root %v, Seq %v, Star %v, Msg %v}", m.Root, m.Seq, m.Star, m.Msg)
+}
+
+// Up carries votes aggregated in a subtree back to root
+type Up struct {
+	Root  PaxiBFT.ID
+	Seq   int
+	From  []PaxiBFT.ID  // nodes that delivered the multicast
+	Votes []interface{} // votes of nodes in From
+}
+
+func (m Up) String() string {
+	return fmt.Sprintf("Up {Root %v, Seq %v, From %v, Votes %d}", m.Root, m.Seq, m.From, len(m.Votes))
+}
+
+// wait is a local timer event of multicast instance
+type wait struct {
+	Root PaxiBFT.ID
+	Seq  int
+}
@@ -0,0 +1,299 @@
+package tree
+
+import (
+	"flag"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/salemmohammed/PaxiBFT"
+	"github.com/salemmohammed/PaxiBFT/log"
+)
+
+var timeout = flag.Int("tree_timeout", 50, "time in ms a tree node waits for votes of each level of its subtree")
+
+// window is the number of multicast instances of one root kept for late votes and fallback
+const window = 1000
+
+type key struct {
+	root PaxiBFT.ID
+	seq  int
+}
+
+// instance is the state of one multicast at one node
+type instance struct {
+	key
+	msg       interface{}         // multicast message, kept by root for fallback
+	star      bool                // delivered directly by root
+	children  []PaxiBFT.ID        // children in tree of root
+	reported  map[PaxiBFT.ID]bool // nodes of subtree that delivered the message
+	votes     []interface{}       // aggregated votes not forwarded yet
+	own       []interface{}       // own votes, resent to root on fallback
+	delivered bool
+	forwarded bool
+}
+
+// Tree disseminates messages of a root down a tree of replicas and aggregates votes back up
+// to it, so that root sends to and receives from fanout nodes instead of every node.
+// The tree of root lists root first and every other node in ID order, node i has children
+// i*fanout+1 to i*fanout+fanout. Root waits one timeout per tree level for the votes; if an
+// internal node has not reported by then, its subtree is served directly and root falls back
+// to star for later multicasts.
+//
+// Messages multicast through the tree must be registered with Tree.Register, and votes for
+// them sent with Tree.Vote from within their handlers. With fanout 0 the tree is a star:
+// Multicast is Broadcast and Vote is Send.
+type Tree struct {
+	PaxiBFT.Node
+
+	fanout    int
+	ids       []PaxiBFT.ID                // sorted node ids
+	orders    map[PaxiBFT.ID][]PaxiBFT.ID // tree layout by root
+	star      bool                        // fell back to star after an internal node failed
+	seq       int                         // sequence of own multicasts
+	handles   map[string]reflect.Value    // handle functions of multicast messages
+	current   *instance                   // instance being delivered, collecting own votes
+	instances map[key]*instance
+}
+
+// NewTree creates dissemination tree over node with fanout from configuration
+func NewTree(n PaxiBFT.Node) *Tree {
+	ids := PaxiBFT.GetConfig().IDs()
+	sort.Sort(PaxiBFT.IDs(ids))
+	t := &Tree{
+		Node:      n,
+		fanout:    PaxiBFT.GetConfig().Fanout,
+		ids:       ids,
+		orders:    make(map[PaxiBFT.ID][]PaxiBFT.ID),
+		handles:   make(map[string]reflect.Value),
+		instances: make(map[key]*instance),
+	}
+	n.Register(Down{}, t.handleDown)
+	n.Register(Up{}, t.handleUp)
+	n.Register(wait{}, t.handleWait)
+	return t
+}
+
+// Register a handle function for message type multicast through the tree
+func (t *Tree) Register(m interface{}, f interface{}) {
+	t.Node.Register(m, f)
+	t.handles[reflect.TypeOf(m).String()] = reflect.ValueOf(f)
+}
+
+// Multicast sends message to every other node
+func (t *Tree) Multicast(m interface{}) {
+	if t.fanout <= 0 || t.star {
+		t.Broadcast(m)
+		return
+	}
+	t.seq++
+	i := t.get(t.ID(), t.seq)
+	i.msg = m
+	i.delivered = true
+	d := Down{Root: t.ID(), Seq: t.seq, Msg: m}
+	for _, c := range i.children {
+		t.Send(c, d)
+	}
+	t.after(i, t.height(t.ID(), t.ID())+1)
+}
+
+// Vote sends vote to node to, aggregated in the tree if it answers a multicast of to
+func (t *Tree) Vote(to PaxiBFT.ID, m interface{}) {
+	if i := t.current; i != nil && i.root == to {
+		i.own = append(i.own, m)
+		i.votes = append(i.votes, m)
+		return
+	}
+	t.Send(to, m)
+}
+
+// order returns tree layout of root
+func (t *Tree) order(root PaxiBFT.ID) []PaxiBFT.ID {
+	o, ok := t.orders[root]
+	if !ok {
+		o = []PaxiBFT.ID{root}
+		for _, id := range t.ids {
+			if id != root {
+				o = append(o, id)
+			}
+		}
+		t.orders[root] = o
+	}
+	return o
+}
+
+func (t *Tree) index(root, id PaxiBFT.ID) int {
+	for i, v := range t.order(root) {
+		if v == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// children returns children of id in tree of root
+func (t *Tree) children(root, id PaxiBFT.ID) []PaxiBFT.ID {
+	o := t.order(root)
+	lo := t.index(root, id)*t.fanout + 1
+	if lo >= len(o) {
+		return nil
+	}
+	hi := lo + t.fanout
+	if hi > len(o) {
+		hi = len(o)
+	}
+	return o[lo:hi]
+}
+
+// parent returns parent of id in tree of root
+func (t *Tree) parent(root, id PaxiBFT.ID) PaxiBFT.ID {
+	return t.order(root)[(t.index(root, id)-1)/t.fanout]
+}
+
+// height returns number of levels below id in tree of root
+func (t *Tree) height(root, id PaxiBFT.ID) int {
+	h := 0
+	for _, c := range t.children(root, id) {
+		if ch := t.height(root, c) + 1; ch > h {
+			h = ch
+		}
+	}
+	return h
+}
+
+// get returns instance of multicast, creating it on first access
+func (t *Tree) get(root PaxiBFT.ID, seq int) *instance {
+	k := key{root, seq}
+	i, ok := t.instances[k]
+	if !ok {
+		i = &instance{
+			key:      k,
+			children: t.children(root, t.ID()),
+			reported: make(map[PaxiBFT.ID]bool),
+		}
+		t.instances[k] = i
+		delete(t.instances, key{root, seq - window})
+	}
+	return i
+}
+
+// after schedules wait event of instance after timeout of given levels
+func (t *Tree) after(i *instance, levels int) {
+	w := wait{Root: i.root, Seq: i.seq}
+	time.AfterFunc(time.Duration(levels**timeout)*time.Millisecond, func() {
+		t.Send(t.ID(), w)
+	})
+}
+
+func (t *Tree) handleDown(m Down) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.Root, m, t.ID())
+	i := t.get(m.Root, m.Seq)
+	if i.delivered {
+		// root did not hear from us, our parent may have failed
+		if m.Star {
+			t.Send(m.Root, Up{Root: m.Root, Seq: m.Seq, From: []PaxiBFT.ID{t.ID()}, Votes: i.own})
+		}
+		return
+	}
+	i.delivered = true
+	if m.Star {
+		i.star = true
+		i.children = nil
+	}
+	for _, c := range i.children {
+		t.Send(c, m)
+	}
+
+	f, exists := t.handles[reflect.TypeOf(m.Msg).String()]
+	if !exists {
+		log.Fatalf("no registered tree handle function for message type %T", m.Msg)
+	}
+	t.current = i
+	f.Call([]reflect.Value{reflect.ValueOf(m.Msg)})
+	t.current = nil
+
+	if len(i.children) > 0 {
+		t.after(i, t.height(m.Root, t.ID()))
+	}
+	t.forward(i, false)
+}
+
+func (t *Tree) handleUp(m Up) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.From, m, t.ID())
+	i := t.instances[key{m.Root, m.Seq}]
+	if m.Root == t.ID() {
+		if i != nil {
+			for _, id := range m.From {
+				i.reported[id] = true
+			}
+		}
+		for _, v := range m.Votes {
+			t.Send(t.ID(), v)
+		}
+		return
+	}
+	if i == nil || i.forwarded {
+		// late votes are relayed as they come
+		if i == nil || !i.star {
+			t.Send(t.parent(m.Root, t.ID()), m)
+		} else {
+			t.Send(m.Root, m)
+		}
+		return
+	}
+	for _, id := range m.From {
+		i.reported[id] = true
+	}
+	i.votes = append(i.votes, m.Votes...)
+	t.forward(i, false)
+}
+
+// forward sends votes of subtree to parent once every child reported, or regardless if partial
+func (t *Tree) forward(i *instance, partial bool) {
+	if i.forwarded || !i.delivered {
+		return
+	}
+	if !partial {
+		for _, c := range i.children {
+			if !i.reported[c] {
+				return
+			}
+		}
+	}
+	from := []PaxiBFT.ID{t.ID()}
+	for id := range i.reported {
+		from = append(from, id)
+	}
+	to := i.root
+	if !i.star {
+		to = t.parent(i.root, t.ID())
+	}
+	t.Send(to, Up{Root: i.root, Seq: i.seq, From: from, Votes: i.votes})
+	i.forwarded = true
+	i.votes = nil
+}
+
+func (t *Tree) handleWait(m wait) {
+	i, ok := t.instances[key{m.Root, m.Seq}]
+	if !ok {
+		return
+	}
+	if m.Root != t.ID() {
+		if !i.forwarded {
+			log.Debugf("Replica %s forwards partial votes of %v", t.ID(), m)
+			t.forward(i, true)
+		}
+		return
+	}
+
+	for _, id := range t.ids {
+		if id == t.ID() || i.reported[id] {
+			continue
+		}
+		if !t.star && len(t.children(t.ID(), id)) > 0 {
+			log.Warningf("Replica %s did not hear from internal node %s, falls back to star", t.ID(), id)
+			t.star = true
+		}
+		t.Send(id, Down{Root: t.ID(), Seq: m.Seq, Star: true, Msg: i.msg})
+	}
+}
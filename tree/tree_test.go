@@ -0,0 +1,154 @@
+package tree
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/salemmohammed/PaxiBFT"
+)
+
+type proposal struct {
+	ID  PaxiBFT.ID
+	Seq int
+}
+
+// start makes replica multicast proposal seq
+type start struct {
+	Seq int
+}
+
+type ack struct {
+	ID  PaxiBFT.ID
+	Seq int
+}
+
+// replica votes for every proposal and reports votes it receives
+type replica struct {
+	PaxiBFT.Node
+	tree *Tree
+	acks chan ack
+}
+
+func newReplica(id PaxiBFT.ID) *replica {
+	r := &replica{
+		Node: PaxiBFT.NewNode(id),
+		acks: make(chan ack, 100),
+	}
+	r.tree = NewTree(r)
+	r.tree.Register(proposal{}, func(m proposal) {
+		r.tree.Vote(m.ID, ack{ID: r.ID(), Seq: m.Seq})
+	})
+	r.Register(start{}, func(m start) {
+		r.tree.Multicast(proposal{ID: r.ID(), Seq: m.Seq})
+	})
+	r.Register(ack{}, func(m ack) {
+		r.acks <- m
+	})
+	return r
+}
+
+// cluster starts n replicas in simulation with configuration c
+func cluster(t *testing.T, c PaxiBFT.Config, port, n int) map[PaxiBFT.ID]*replica {
+	PaxiBFT.Simulation()
+	c.Addrs = make(map[PaxiBFT.ID]string)
+	c.HTTPAddrs = make(map[PaxiBFT.ID]string)
+	for i := 1; i <= n; i++ {
+		id := PaxiBFT.NewID(1, i)
+		p := strconv.Itoa(port + i)
+		c.Addrs[id] = "chan://127.0.0.1:" + p
+		c.HTTPAddrs[id] = "http://127.0.0.1:" + p
+	}
+	PaxiBFT.SetConfig(c)
+
+	replicas := make(map[PaxiBFT.ID]*replica)
+	for id := range c.Addrs {
+		replicas[id] = newReplica(id)
+		go replicas[id].Run()
+		err := PaxiBFT.Retry(func() error {
+			r, err := http.Get(c.HTTPAddrs[id] + "/load")
+			if err == nil {
+				r.Body.Close()
+			}
+			return err
+		}, 50, 10*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return replicas
+}
+
+// collect waits for votes of every node in ids for proposal seq
+func collect(t *testing.T, r *replica, seq int, ids ...PaxiBFT.ID) {
+	expect := make(map[PaxiBFT.ID]bool)
+	for _, id := range ids {
+		expect[id] = true
+	}
+	timer := time.NewTimer(2 * time.Second)
+	defer timer.Stop()
+	for len(expect) > 0 {
+		select {
+		case m := <-r.acks:
+			if m.Seq == seq {
+				delete(expect, m.ID)
+			}
+		case <-timer.C:
+			t.Fatalf("proposal %d missing votes of %v", seq, expect)
+		}
+	}
+}
+
+func TestTreeLayout(t *testing.T) {
+	tr := &Tree{fanout: 2, orders: make(map[PaxiBFT.ID][]PaxiBFT.ID)}
+	tr.ids = []PaxiBFT.ID{"1.1", "1.2", "1.3", "1.4", "1.5", "1.6", "1.7"}
+
+	// tree of 1.3 is 1.3 -> (1.1, 1.2), 1.1 -> (1.4, 1.5), 1.2 -> (1.6, 1.7)
+	if ch := tr.children("1.3", "1.3"); len(ch) != 2 || ch[0] != "1.1" || ch[1] != "1.2" {
+		t.Errorf("children of root 1.3 are %v", ch)
+	}
+	if ch := tr.children("1.3", "1.2"); len(ch) != 2 || ch[0] != "1.6" || ch[1] != "1.7" {
+		t.Errorf("children of 1.2 in tree of 1.3 are %v", ch)
+	}
+	if p := tr.parent("1.3", "1.5"); p != "1.1" {
+		t.Errorf("parent of 1.5 in tree of 1.3 is %v", p)
+	}
+	if h := tr.height("1.3", "1.3"); h != 2 {
+		t.Errorf("height of tree is %d", h)
+	}
+	if ch := tr.children("1.3", "1.7"); len(ch) != 0 {
+		t.Errorf("leaf 1.7 has children %v", ch)
+	}
+}
+
+func TestTree(t *testing.T) {
+	*timeout = 20
+	c := PaxiBFT.MakeDefaultConfig()
+	c.Fanout = 2
+	replicas := cluster(t, c, 20500, 7)
+	root := replicas["1.1"]
+	others := []PaxiBFT.ID{"1.2", "1.3", "1.4", "1.5", "1.6", "1.7"}
+
+	// root link only carries messages to and from its children
+	before := root.Load()
+	root.Send(root.ID(), start{Seq: 1})
+	collect(t, root, 1, others...)
+	load := root.Load()
+	if sent := load.Sent - before.Sent; sent != 2 {
+		t.Errorf("root sent %d messages, expected 2", sent)
+	}
+	if received := load.Received - before.Received; received != 2 {
+		t.Errorf("root received %d messages, expected 2", received)
+	}
+
+	// subtree of crashed internal node is served by root directly
+	replicas["1.2"].Crash(5)
+	root.Send(root.ID(), start{Seq: 2})
+	collect(t, root, 2, "1.3", "1.4", "1.5", "1.6", "1.7")
+	if !root.tree.star {
+		t.Error("root did not fall back to star")
+	}
+	root.Send(root.ID(), start{Seq: 3})
+	collect(t, root, 3, "1.3", "1.4", "1.5", "1.6", "1.7")
+}
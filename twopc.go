@@ -0,0 +1,264 @@
+package PaxiBFT
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/salemmohammed/PaxiBFT/log"
+)
+
+// ErrAborted is returned for a cross-shard transaction that did not commit
+var ErrAborted = errors.New("transaction aborted")
+
+// ErrLocked is returned for a write to a key locked by a cross-shard transaction in progress,
+// which can be sent again once the transaction finished
+var ErrLocked = errors.New("key locked by transaction")
+
+// ErrNoQuorum is returned when a shard does not reply to a transaction step in time
+var ErrNoQuorum = errors.New("no matching replies from shard")
+
+var orphan = flag.Int("orphan_timeout", 5000, "time in ms a prepared cross-shard transaction waits for its coordinator before participants finish it")
+
+// a transaction step sent to a shard is retried until enough of its replicas reply the same value
+const (
+	twoPCTimeout = 500 * time.Millisecond
+	twoPCRetries = 20
+)
+
+var twoPCClient = &http.Client{Timeout: twoPCTimeout}
+
+// crossShard returns true if transaction has keys outside the shard of this node
+func (n *node) crossShard(t Transaction) bool {
+	if len(config.Shards) == 0 {
+		return false
+	}
+	router := NewRouter(config.Shards)
+	shard := config.Shard(n.id)
+	for _, c := range t.Commands {
+		if router.Route(c.Key) != shard {
+			return true
+		}
+	}
+	return false
+}
+
+// coordinate runs transaction t of keys in several shards with two-phase commit. Every step is a command
+// ordered through the log of a replica group: each participant shard prepares its commands and votes,
+// the shard of this node records the outcome, and participants then commit or abort.
+// The transaction id names the coordinator shard and comes from the client session, so a client retry
+// through any node of the shard finishes the same transaction, as the recorded outcome never changes.
+// Participants act on the outcome in the log of the coordinator shard only, which they look up
+// themselves if the coordinator does not finish the transaction.
+func (n *node) coordinate(t Transaction, client ID, cid int) TransactionReply {
+	// the commands of the request stay with the caller, replies are filled in a copy
+	tr := TransactionReply{Commands: append([]Command(nil), t.Commands...), Timestamp: time.Now().UnixNano()}
+	shard := config.Shard(n.id)
+	id := Key(fmt.Sprintf("%s/%s/%d", shard, client, cid))
+	if client == "" {
+		id = Key(fmt.Sprintf("%s/%s/%d", shard, n.id, tr.Timestamp))
+	}
+
+	// commands of every shard and their positions in transaction
+	router := NewRouter(config.Shards)
+	commands := make(map[string][]Command)
+	index := make(map[string][]int)
+	for i, c := range t.Commands {
+		s := router.Route(c.Key)
+		commands[s] = append(commands[s], c)
+		index[s] = append(index[s], i)
+	}
+
+	// phase 1: every participant prepares its commands
+	votes := n.broadcast(commands, func(s string) Command {
+		c := Prepare(id, commands[s])
+		c.ClientID, c.CommandID = ID(fmt.Sprintf("%s@%s", id, s)), 1
+		return c
+	})
+	outcome := Committed
+	for s, v := range votes {
+		if !bytes.Equal(v, Prepared) {
+			log.Infof("node %v aborts transaction %v, shard %s voted %s", n.id, id, s, v)
+			outcome = Aborted
+		}
+	}
+
+	// the outcome is ordered in the log of the coordinator shard before anyone learns it
+	outcome, err := n.decide(id, outcome)
+	if err != nil {
+		tr.Err = err
+		return tr
+	}
+
+	// phase 2: participants commit or abort
+	values := n.broadcast(commands, func(s string) Command {
+		c := Command{Key: id, Op: OpAbort}
+		if bytes.Equal(outcome, Committed) {
+			c.Op = OpCommit
+		}
+		c.ClientID, c.CommandID = ID(fmt.Sprintf("%s@%s", id, s)), 2
+		return c
+	})
+	if !bytes.Equal(outcome, Committed) {
+		tr.Err = ErrAborted
+		return tr
+	}
+	for s, v := range values {
+		vs := make([]Value, 0)
+		if err := json.Unmarshal(v, &vs); err != nil || len(vs) != len(index[s]) {
+			tr.Err = fmt.Errorf("shard %s committed transaction %v with reply %q", s, id, v)
+			return tr
+		}
+		for i, j := range index[s] {
+			tr.Commands[j].Value = vs[i]
+		}
+	}
+	tr.OK = true
+	return tr
+}
+
+// coordinator returns the shard coordinating transaction id
+func coordinator(id Key) string {
+	return strings.SplitN(string(id), "/", 2)[0]
+}
+
+// decide orders outcome of transaction id in the log of its coordinator shard
+// and returns the outcome recorded there, which is the first one ordered
+func (n *node) decide(id Key, outcome Value) (Value, error) {
+	c := Decide(id, outcome)
+	c.ClientID, c.CommandID = ID(fmt.Sprintf("%s@decide", id)), 1
+	return n.order(coordinator(id), c)
+}
+
+// verify checks a step of cross-shard transaction posted to this node before it is ordered.
+// Only the coordinator shard records outcomes of its transactions, and participants commit or abort
+// by the outcome in the log of the coordinator shard whoever asks them to, where a transaction
+// without outcome yet is aborted.
+func (n *node) verify(c *Command) error {
+	if c.Op == OpDecide {
+		if coordinator(c.Key) != config.Shard(n.id) {
+			return fmt.Errorf("shard %s does not coordinate transaction %v", config.Shard(n.id), c.Key)
+		}
+		return nil
+	}
+	outcome, err := n.decide(c.Key, Aborted)
+	if err != nil {
+		return err
+	}
+	c.Op = OpAbort
+	if bytes.Equal(outcome, Committed) {
+		c.Op = OpCommit
+	}
+	return nil
+}
+
+// terminate finishes transactions prepared in database db of this node for longer than the orphan timeout,
+// whose coordinator may have crashed, until the node closes
+func (n *node) terminate(db Database) {
+	defer n.host.loops.Done()
+	timeout := time.Duration(*orphan) * time.Millisecond
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	prepared := make(map[Key]time.Time)
+	for {
+		select {
+		case <-n.host.done:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		pending := make(map[Key]time.Time)
+		for _, id := range db.Pending() {
+			since, ok := prepared[id]
+			if !ok {
+				since = now
+			}
+			pending[id] = since
+			if now.Sub(since) < timeout {
+				continue
+			}
+			// every replica of the shard verifies the outcome again before it is ordered
+			log.Infof("node %v finishes orphaned transaction %v", n.id, id)
+			shard := config.Shard(n.id)
+			c := Command{Key: id, Op: OpAbort}
+			c.ClientID, c.CommandID = ID(fmt.Sprintf("%s@%s", id, shard)), 2
+			if _, err := n.order(shard, c); err != nil {
+				log.Warningf("node %v cannot finish transaction %v: %v", n.id, id, err)
+			}
+		}
+		prepared = pending
+	}
+}
+
+// broadcast orders the command of every shard concurrently and returns their values,
+// a shard that does not reply has nil value
+func (n *node) broadcast(shards map[string][]Command, command func(string) Command) map[string]Value {
+	var mu sync.Mutex
+	var wait sync.WaitGroup
+	values := make(map[string]Value)
+	for s := range shards {
+		wait.Add(1)
+		go func(s string) {
+			defer wait.Done()
+			v, err := n.order(s, command(s))
+			if err != nil {
+				log.Error(err)
+			}
+			mu.Lock()
+			values[s] = v
+			mu.Unlock()
+		}(s)
+	}
+	wait.Wait()
+	return values
+}
+
+// order submits command to every replica of shard and returns its value once f+1 replicas reply the same,
+// where f = (n-1)/3 replicas of the group may be byzantine. Unanswered requests are sent again,
+// the client session of command keeps them from executing twice.
+func (n *node) order(shard string, cmd Command) (Value, error) {
+	replicas := config.Shards[shard]
+	f := (len(replicas) - 1) / 3
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < twoPCRetries; i++ {
+		replies := make(chan Value, len(replicas))
+		for _, id := range replicas {
+			go func(id ID) {
+				r, err := twoPCClient.Post(config.HTTPAddrs[id]+n.path("/"), "application/json", bytes.NewReader(b))
+				if err != nil {
+					replies <- nil
+					return
+				}
+				defer r.Body.Close()
+				v, err := io.ReadAll(r.Body)
+				if err != nil || r.StatusCode != http.StatusOK {
+					replies <- nil
+					return
+				}
+				replies <- Value(v)
+			}(id)
+		}
+		count := make(map[string]int)
+		for range replicas {
+			v := <-replies
+			if v == nil {
+				continue
+			}
+			count[string(v)]++
+			if count[string(v)] > f {
+				return v, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("shard %s on %v: %w", shard, cmd, ErrNoQuorum)
+}
@@ -0,0 +1,299 @@
+package PaxiBFT
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/salemmohammed/PaxiBFT/log"
+)
+
+/******************************
+/*     UDP communication      *
+/******************************/
+
+// UDPConfig is how the udp transport reassembles messages from datagrams
+type UDPConfig struct {
+	Timeout    int  `json:"timeout"`    // ms a partly received message waits for its missing fragments
+	Retransmit bool `json:"retransmit"` // receiver asks sender again for fragments missing for a while
+}
+
+const (
+	udpPacket   = 1472 // largest datagram without ip fragmentation on ethernet
+	udpHeader   = 16   // stream 8 bytes, message 4, fragment 2, count 2
+	udpFragment = udpPacket - udpHeader
+
+	udpNack    = 20 * time.Millisecond // fragments are missing once none arrived for so long
+	udpNacks   = 5                     // times receiver asks for missing fragments of one message
+	udpHistory = 256                   // messages sender keeps to retransmit
+)
+
+// frame is the header of a datagram with fragment index of count fragments of a message,
+// a frame of count zero asks sender of stream for the missing fragments of message in its payload
+type frame struct {
+	stream  uint64 // random id of the sending link, since one server reads from many
+	message uint32
+	index   uint16
+	count   uint16
+}
+
+func (f frame) put(b []byte) {
+	binary.BigEndian.PutUint64(b[0:], f.stream)
+	binary.BigEndian.PutUint32(b[8:], f.message)
+	binary.BigEndian.PutUint16(b[12:], f.index)
+	binary.BigEndian.PutUint16(b[14:], f.count)
+}
+
+func readFrame(b []byte) frame {
+	return frame{
+		stream:  binary.BigEndian.Uint64(b[0:]),
+		message: binary.BigEndian.Uint32(b[8:]),
+		index:   binary.BigEndian.Uint16(b[12:]),
+		count:   binary.BigEndian.Uint16(b[14:]),
+	}
+}
+
+// fragment splits encoded message into datagrams
+func fragment(stream uint64, message uint32, b []byte) [][]byte {
+	count := (len(b) + udpFragment - 1) / udpFragment
+	if count == 0 {
+		count = 1
+	}
+	packets := make([][]byte, count)
+	for i := range packets {
+		end := (i + 1) * udpFragment
+		if end > len(b) {
+			end = len(b)
+		}
+		p := make([]byte, udpHeader+end-i*udpFragment)
+		frame{stream, message, uint16(i), uint16(count)}.put(p)
+		copy(p[udpHeader:], b[i*udpFragment:end])
+		packets[i] = p
+	}
+	return packets
+}
+
+type udp struct {
+	*transport
+}
+
+// Connect opens the socket to remote server, which datagrams need no connection for
+func (u *udp) Connect() {
+	if err := u.Dial(); err != nil {
+		log.Errorf("cannot open udp socket to %s: %v", u.uri.Host, err)
+	}
+}
+
+func (u *udp) Dial() error {
+	addr, err := net.ResolveUDPAddr("udp", u.uri.Host)
+	if err != nil {
+		log.Fatal("UDP resolve address error: ", err)
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		u.update(func(s *PeerState) { s.Failures++ })
+		return err
+	}
+	u.update(func(s *PeerState) { s.State = "connected"; s.Dials++ })
+
+	stream := rand.Uint64()
+	retransmit := config.UDP.Retransmit
+	var lock sync.Mutex
+	sent := make(map[uint32][][]byte)
+	if retransmit {
+		go u.retransmit(conn, &lock, sent)
+	}
+
+	go func(conn *net.UDPConn) {
+		defer conn.Close()
+		w := new(bytes.Buffer)
+		for id := uint32(0); ; id++ {
+			m, ok := <-u.send
+			if !ok {
+				return
+			}
+			w.Reset()
+			if err := newWire(u.codec, u.zip, w, &u.stats).Encode(&m); err != nil {
+				log.Error(err)
+				continue
+			}
+			packets := fragment(stream, id, w.Bytes())
+			if len(packets) > 1<<16-1 {
+				log.Errorf("message of %d bytes is too large for udp", w.Len())
+				continue
+			}
+			if retransmit {
+				lock.Lock()
+				sent[id] = packets
+				delete(sent, id-udpHistory)
+				lock.Unlock()
+			}
+			for _, p := range packets {
+				if _, err := conn.Write(p); err != nil {
+					log.Error(err)
+				}
+			}
+		}
+	}(conn)
+
+	return nil
+}
+
+// retransmit sends fragments again that server asks for, until connection is closed
+func (u *udp) retransmit(conn *net.UDPConn, lock *sync.Mutex, sent map[uint32][][]byte) {
+	packet := make([]byte, udpPacket)
+	for {
+		n, err := conn.Read(packet)
+		if err != nil {
+			select {
+			case <-u.close:
+				return
+			default:
+			}
+			// nobody listens on the port yet
+			continue
+		}
+		if n < udpHeader {
+			continue
+		}
+		f := readFrame(packet)
+		lock.Lock()
+		packets := sent[f.message]
+		lock.Unlock()
+		for i := udpHeader; i+2 <= n; i += 2 {
+			if j := int(binary.BigEndian.Uint16(packet[i:])); j < len(packets) {
+				conn.Write(packets[j])
+			}
+		}
+	}
+}
+
+// partial is a message with fragments still missing
+type partial struct {
+	fragments [][]byte
+	missing   int
+	addr      *net.UDPAddr
+	first     time.Time // arrival of the first fragment
+	last      time.Time // arrival of the last fragment or nack
+	nacks     int
+}
+
+// udpMessage identifies a message by sending link
+type udpMessage struct {
+	stream uint64
+	id     uint32
+}
+
+func (u *udp) Listen() {
+	addr, err := net.ResolveUDPAddr("udp", ":"+u.uri.Port())
+	if err != nil {
+		log.Fatal("UDP resolve address error: ", err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		log.Fatal("UDP Listener error: ", err)
+	}
+	c := config.UDP
+	go func(conn *net.UDPConn) {
+		packet := make([]byte, 1<<16)
+		partials := make(map[udpMessage]*partial)
+		done := make(map[udpMessage]time.Time) // reassembled messages whose fragments may still arrive
+		swept := time.Now()
+		defer conn.Close()
+		for {
+			select {
+			case <-u.close:
+				return
+			default:
+			}
+			conn.SetReadDeadline(time.Now().Add(udpNack))
+			n, from, err := conn.ReadFromUDP(packet)
+			now := time.Now()
+			if now.Sub(swept) >= udpNack {
+				u.sweep(conn, c, partials, done, now)
+				swept = now
+			}
+			if err != nil {
+				if e, ok := err.(net.Error); !ok || !e.Timeout() {
+					log.Error(err)
+				}
+				continue
+			}
+			if n < udpHeader {
+				continue
+			}
+			f := readFrame(packet)
+			k := udpMessage{f.stream, f.message}
+			if f.count == 0 || f.index >= f.count {
+				continue
+			}
+			if _, ok := done[k]; ok {
+				continue
+			}
+
+			p, ok := partials[k]
+			if !ok {
+				p = &partial{
+					fragments: make([][]byte, f.count),
+					missing:   int(f.count),
+					first:     now,
+				}
+				partials[k] = p
+			}
+			p.addr = from
+			p.last = now
+			if p.fragments[f.index] == nil {
+				p.fragments[f.index] = append([]byte(nil), packet[udpHeader:n]...)
+				p.missing--
+			}
+			if p.missing > 0 {
+				continue
+			}
+
+			delete(partials, k)
+			done[k] = now
+			var m interface{}
+			err = newWire(u.codec, u.zip, bytes.NewBuffer(bytes.Join(p.fragments, nil)), &u.stats).Decode(&m)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			u.recv <- m
+		}
+	}(conn)
+}
+
+// sweep drops messages not reassembled in time and asks senders for fragments missing for a while
+func (u *udp) sweep(conn *net.UDPConn, c UDPConfig, partials map[udpMessage]*partial, done map[udpMessage]time.Time, now time.Time) {
+	timeout := time.Duration(c.Timeout) * time.Millisecond
+	for k, t := range done {
+		if now.Sub(t) > timeout {
+			delete(done, k)
+		}
+	}
+	for k, p := range partials {
+		if now.Sub(p.first) > timeout {
+			log.Warningf("udp message %d of stream %x misses %d of %d fragments", k.id, k.stream, p.missing, len(p.fragments))
+			delete(partials, k)
+			continue
+		}
+		if !c.Retransmit || p.nacks >= udpNacks || now.Sub(p.last) < udpNack {
+			continue
+		}
+		nack := make([]byte, udpHeader, udpPacket)
+		frame{k.stream, k.id, 0, 0}.put(nack)
+		for i, f := range p.fragments {
+			if f == nil && len(nack)+2 <= udpPacket {
+				nack = append(nack, byte(i>>8), byte(i))
+			}
+		}
+		if _, err := conn.WriteToUDP(nack, p.addr); err != nil {
+			log.Error(err)
+		}
+		p.nacks++
+		p.last = now
+	}
+}
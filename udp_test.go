@@ -0,0 +1,95 @@
+package PaxiBFT
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUDPFragmentation(t *testing.T) {
+	gob.Register(B{})
+
+	server := NewTransport("udp://127.0.0.1:1739")
+	server.Listen()
+	defer server.Close()
+	client := NewTransport("udp://127.0.0.1:1739")
+	defer client.Close()
+	if err := client.Dial(); err != nil {
+		t.Fatal(err)
+	}
+
+	// larger than one datagram, like the 10 KB values of http requests
+	s := strings.Repeat("paxi", 5000)
+	client.Send(B{S: s})
+	client.Send(B{S: "small"})
+	for _, want := range []string{s, "small"} {
+		select {
+		case m := <-server.(*udp).recv:
+			if m.(B).S != want {
+				t.Errorf("recv message of %d bytes, want %d", len(m.(B).S), len(want))
+			}
+		case <-time.After(time.Second):
+			t.Fatal("message not reassembled")
+		}
+	}
+}
+
+func TestUDPRetransmit(t *testing.T) {
+	gob.Register(B{})
+	c := config
+	defer func() { config = c }()
+	config.UDP.Retransmit = true
+
+	server := NewTransport("udp://127.0.0.1:1740")
+	server.Listen()
+	defer server.Close()
+
+	var m interface{} = B{S: strings.Repeat("x", 3*udpFragment)}
+	w := new(bytes.Buffer)
+	if err := newWire("gob", CompressionConfig{}, w, new(wireStats)).Encode(&m); err != nil {
+		t.Fatal(err)
+	}
+	packets := fragment(7, 1, w.Bytes())
+	if len(packets) < 3 {
+		t.Fatalf("message in %d fragments", len(packets))
+	}
+
+	addr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:1740")
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// fragment 1 gets lost
+	for i, p := range packets {
+		if i != 1 {
+			conn.Write(p)
+		}
+	}
+
+	// server asks for the lost fragment
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	nack := make([]byte, udpPacket)
+	n, err := conn.Read(nack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := readFrame(nack)
+	if f.stream != 7 || f.message != 1 || f.count != 0 || n != udpHeader+2 || binary.BigEndian.Uint16(nack[udpHeader:]) != 1 {
+		t.Fatalf("nack %+v of %d bytes", f, n)
+	}
+	conn.Write(packets[1])
+
+	select {
+	case r := <-server.(*udp).recv:
+		if r.(B).S != m.(B).S {
+			t.Error("retransmitted message differs")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not reassembled after retransmission")
+	}
+}
@@ -0,0 +1,223 @@
+package PaxiBFT
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"flag"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/salemmohammed/PaxiBFT/log"
+)
+
+var recovery = flag.Bool("recover", false, "recover protocol state of node from its write-ahead log")
+
+// walCheckpoint is the key of the last checkpoint persisted in write-ahead log
+const walCheckpoint = "checkpoint"
+
+// WAL is the write-ahead log of protocol state. Protocols put their votes and accepted proposals
+// under their own keys before sending messages that depend on them, and get them back after restart,
+// so a recovered replica never votes twice for the same slot. A nil WAL keeps nothing.
+type WAL struct {
+	sync.Mutex
+	engine Engine
+	state  map[Key]Value // latest value of every key replayed on startup
+	closed bool
+
+	compact   []string // prefixes of slot keys deleted once a checkpoint covers their slot
+	compacted int      // slot of the last checkpoint persisted, -1 if there is none
+}
+
+// OpenWAL opens write-ahead log of node id in configuration, the log is replayed if node is recovering
+// and starts empty otherwise. Returns nil if there is no log configured.
+func OpenWAL(id ID) (*WAL, error) {
+	c := config.WAL
+	if c.Engine == "" || c.Engine == "memory" {
+		return nil, nil
+	}
+	engine, err := OpenFileEngine(filepath.Join(c.Dir, string(id)+".wal"), c.Sync, time.Duration(c.Interval)*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	w := &WAL{
+		engine:    engine,
+		state:     make(map[Key]Value),
+		compacted: -1,
+	}
+	if !*recovery {
+		return w, engine.Reset(nil)
+	}
+	err = engine.Replay(func(k Key, v Value, deleted bool) {
+		if deleted {
+			delete(w.state, k)
+		} else {
+			w.state[k] = v
+		}
+	})
+	if err != nil {
+		engine.Close()
+		return nil, err
+	}
+	if b, exists := w.state[walCheckpoint]; exists {
+		cp := Checkpoint{}
+		if err := json.Unmarshal(b, &cp); err != nil {
+			engine.Close()
+			return nil, err
+		}
+		w.compacted = cp.Slot
+	}
+	// compact the log to the replayed state
+	return w, engine.Reset(w.state)
+}
+
+// Recovering returns true if protocol state was replayed from log
+func (w *WAL) Recovering() bool {
+	return w != nil && *recovery
+}
+
+// Put persists v under key before returning, a replica that cannot persist its state stops
+func (w *WAL) Put(key string, v interface{}) {
+	if w == nil {
+		return
+	}
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(v); err != nil {
+		log.Fatalf("cannot encode %s to write-ahead log: %v", key, err)
+	}
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		return
+	}
+	if err := w.engine.Put(Key(key), b.Bytes()); err != nil {
+		log.Fatalf("cannot write %s to write-ahead log: %v", key, err)
+	}
+}
+
+// Delete removes key from log
+func (w *WAL) Delete(key string) {
+	if w == nil {
+		return
+	}
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		return
+	}
+	if err := w.engine.Delete(Key(key)); err != nil {
+		log.Fatalf("cannot delete %s from write-ahead log: %v", key, err)
+	}
+}
+
+// Get decodes value of key replayed on startup into v, returns false if there is none
+func (w *WAL) Get(key string, v interface{}) bool {
+	if w == nil {
+		return false
+	}
+	w.Lock()
+	b, exists := w.state[Key(key)]
+	w.Unlock()
+	if !exists {
+		return false
+	}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(v); err != nil {
+		log.Errorf("cannot decode %s from write-ahead log: %v", key, err)
+		return false
+	}
+	return true
+}
+
+// Keys returns the keys with prefix replayed on startup in order
+func (w *WAL) Keys(prefix string) []string {
+	keys := make([]string, 0)
+	if w == nil {
+		return keys
+	}
+	w.Lock()
+	defer w.Unlock()
+	for k := range w.state {
+		if strings.HasPrefix(string(k), prefix) {
+			keys = append(keys, string(k))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Compact declares that keys prefix+N hold the state of slot N,
+// they are deleted once a checkpoint after slot N is persisted
+func (w *WAL) Compact(prefix string) {
+	if w == nil {
+		return
+	}
+	w.Lock()
+	defer w.Unlock()
+	w.compact = append(w.compact, prefix)
+}
+
+// Compacted returns the slot of the last checkpoint persisted, -1 if there is none
+func (w *WAL) Compacted() int {
+	if w == nil {
+		return -1
+	}
+	w.Lock()
+	defer w.Unlock()
+	return w.compacted
+}
+
+// PutCheckpoint persists checkpoint cp and deletes the slot keys it covers,
+// nothing is persisted unless some protocol state is compacted
+func (w *WAL) PutCheckpoint(cp *Checkpoint) {
+	if w == nil {
+		return
+	}
+	b, err := json.Marshal(cp)
+	if err != nil {
+		log.Fatalf("cannot encode checkpoint to write-ahead log: %v", err)
+	}
+	w.Lock()
+	defer w.Unlock()
+	if w.closed || len(w.compact) == 0 || cp.Slot <= w.compacted {
+		return
+	}
+	if err := w.engine.Put(walCheckpoint, b); err != nil {
+		log.Fatalf("cannot write checkpoint to write-ahead log: %v", err)
+	}
+	for s := w.compacted + 1; s <= cp.Slot; s++ {
+		for _, prefix := range w.compact {
+			if err := w.engine.Delete(Key(prefix + strconv.Itoa(s))); err != nil {
+				log.Fatalf("cannot delete slot %d from write-ahead log: %v", s, err)
+			}
+		}
+	}
+	w.compacted = cp.Slot
+}
+
+// checkpoint returns the checkpoint replayed on startup in json, nil if there is none
+func (w *WAL) checkpoint() []byte {
+	if w == nil {
+		return nil
+	}
+	w.Lock()
+	defer w.Unlock()
+	return w.state[walCheckpoint]
+}
+
+// Close closes log, later writes of a stopped node are dropped
+func (w *WAL) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.engine.Close()
+}
@@ -0,0 +1,111 @@
+package PaxiBFT
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestWAL(t *testing.T) {
+	old := config
+	defer func() { config = old; *recovery = false }()
+	c := MakeDefaultConfig()
+	c.WAL = StorageConfig{Engine: "file", Dir: t.TempDir(), Sync: "always"}
+	SetConfig(c)
+
+	w, err := OpenWAL("1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Recovering() {
+		t.Error("new write-ahead log is recovering")
+	}
+	w.Put("ballot", Ballot(3))
+	w.Put("slot/1", Command{Key: "a", Value: Value("1")})
+	w.Put("slot/0", Command{Key: "b"})
+	w.Put("slot/2", Command{Key: "c"})
+	w.Delete("slot/2")
+	w.Close()
+
+	*recovery = true
+	w, err = OpenWAL("1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b Ballot
+	if !w.Recovering() || !w.Get("ballot", &b) || b != 3 {
+		t.Errorf("recovered ballot %v, expected 3", b)
+	}
+	keys := w.Keys("slot/")
+	if len(keys) != 2 || keys[0] != "slot/0" || keys[1] != "slot/1" {
+		t.Errorf("recovered keys %v, expected slot/0 and slot/1", keys)
+	}
+	var cmd Command
+	if !w.Get("slot/1", &cmd) || cmd.Key != "a" || string(cmd.Value) != "1" {
+		t.Errorf("recovered command %v, expected a=1", cmd)
+	}
+	w.Close()
+
+	// without recovery the log starts empty
+	*recovery = false
+	w, err = OpenWAL("1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Keys("")) != 0 {
+		t.Errorf("new write-ahead log keeps %v", w.Keys(""))
+	}
+	w.Close()
+
+	// disabled log keeps nothing
+	SetConfig(MakeDefaultConfig())
+	w, err = OpenWAL("1.1")
+	if w != nil || err != nil {
+		t.Fatalf("memory write-ahead log = %v, %v", w, err)
+	}
+	w.Put("ballot", Ballot(1))
+	if w.Get("ballot", &b) || w.Recovering() {
+		t.Error("disabled write-ahead log recovered state")
+	}
+}
+
+func TestWALCompact(t *testing.T) {
+	old := config
+	defer func() { config = old; *recovery = false }()
+	c := MakeDefaultConfig()
+	c.WAL = StorageConfig{Engine: "file", Dir: t.TempDir(), Sync: "always"}
+	SetConfig(c)
+
+	w, err := OpenWAL("1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for s := 0; s < 4; s++ {
+		w.Put("slot/"+strconv.Itoa(s), Command{Key: "a"})
+	}
+	// nothing is persisted until some state is compacted
+	w.PutCheckpoint(&Checkpoint{Slot: 1})
+	if s := w.Compacted(); s != -1 {
+		t.Errorf("checkpoint at slot %d persisted without compacted state", s)
+	}
+	w.Compact("slot/")
+	w.PutCheckpoint(&Checkpoint{Slot: 1})
+	w.PutCheckpoint(&Checkpoint{Slot: 0})
+	if s := w.Compacted(); s != 1 {
+		t.Errorf("compacted up to slot %d, expected 1", s)
+	}
+	w.Close()
+
+	*recovery = true
+	w, err = OpenWAL("1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := w.Keys("slot/")
+	if len(keys) != 2 || keys[0] != "slot/2" || keys[1] != "slot/3" {
+		t.Errorf("recovered keys %v, expected slot/2 and slot/3", keys)
+	}
+	if s := w.Compacted(); s != 1 || w.checkpoint() == nil {
+		t.Errorf("recovered checkpoint at slot %d, expected 1", s)
+	}
+	w.Close()
+}
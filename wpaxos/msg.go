@@ -0,0 +1,90 @@
+package wpaxos
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/salemmohammed/PaxiBFT"
+	"github.com/salemmohammed/PaxiBFT/paxos"
+)
+
+func init() {
+	gob.Register(Prepare{})
+	gob.Register(Promise{})
+	gob.Register(Accept{})
+	gob.Register(Accepted{})
+	gob.Register(Commit{})
+	gob.Register(Activate{})
+	gob.Register(LeaderChange{})
+}
+
+// Prepare is paxos P1a tagged with the object key
+type Prepare struct {
+	Key PaxiBFT.Key
+	P1a paxos.P1a
+}
+
+func (m Prepare) String() string {
+	return fmt.Sprintf("Prepare {key=%v %v}", m.Key, m.P1a)
+}
+
+// Promise is paxos P1b tagged with the object key
+type Promise struct {
+	Key PaxiBFT.Key
+	P1b paxos.P1b
+}
+
+func (m Promise) String() string {
+	return fmt.Sprintf("Promise {key=%v %v}", m.Key, m.P1b)
+}
+
+// Accept is paxos P2a tagged with the object key
+type Accept struct {
+	Key PaxiBFT.Key
+	P2a paxos.P2a
+}
+
+func (m Accept) String() string {
+	return fmt.Sprintf("Accept {key=%v %v}", m.Key, m.P2a)
+}
+
+// Accepted is paxos P2b tagged with the object key
+type Accepted struct {
+	Key PaxiBFT.Key
+	P2b paxos.P2b
+}
+
+func (m Accepted) String() string {
+	return fmt.Sprintf("Accepted {key=%v %v}", m.Key, m.P2b)
+}
+
+// Commit is paxos P3 tagged with the object key
+type Commit struct {
+	Key PaxiBFT.Key
+	P3  paxos.P3
+}
+
+func (m Commit) String() string {
+	return fmt.Sprintf("Commit {key=%v %v}", m.Key, m.P3)
+}
+
+// Activate is paxos Activate tagged with the object key, only sent by a leader to itself
+type Activate struct {
+	Key      PaxiBFT.Key
+	Activate paxos.Activate
+}
+
+func (m Activate) String() string {
+	return fmt.Sprintf("Activate {key=%v %v}", m.Key, m.Activate)
+}
+
+// LeaderChange asks node To to steal the object Key from its current owner From
+type LeaderChange struct {
+	Key    PaxiBFT.Key
+	To     PaxiBFT.ID
+	From   PaxiBFT.ID
+	Ballot PaxiBFT.Ballot
+}
+
+func (m LeaderChange) String() string {
+	return fmt.Sprintf("LeaderChange {key=%v, from=%s, to=%s, b=%v}", m.Key, m.From, m.To, m.Ballot)
+}
@@ -0,0 +1,116 @@
+package wpaxos
+
+import (
+	"errors"
+	"github.com/salemmohammed/PaxiBFT"
+	"github.com/salemmohammed/PaxiBFT/log"
+)
+
+// ErrScan is replied to range scans, which span objects owned by different leaders
+var ErrScan = errors.New("wpaxos does not support scans")
+
+// Replica is WPaxos replica node
+// every key is an object owned by the leader of its own paxos instance
+type Replica struct {
+	PaxiBFT.Node
+	paxi map[PaxiBFT.Key]*kpaxos
+}
+
+// NewReplica generates new WPaxos replica
+func NewReplica(id PaxiBFT.ID) *Replica {
+	r := new(Replica)
+	r.Node = PaxiBFT.NewNode(id)
+	r.paxi = make(map[PaxiBFT.Key]*kpaxos)
+	r.Register(PaxiBFT.Request{}, r.handleRequest)
+	r.Register(Prepare{}, r.handlePrepare)
+	r.Register(Promise{}, r.handlePromise)
+	r.Register(Accept{}, r.handleAccept)
+	r.Register(Accepted{}, r.handleAccepted)
+	r.Register(Commit{}, r.handleCommit)
+	r.Register(Activate{}, r.handleActivate)
+	r.Register(LeaderChange{}, r.handleLeaderChange)
+	return r
+}
+
+// init returns the paxos instance of key, creating it on first access
+func (r *Replica) init(key PaxiBFT.Key) *kpaxos {
+	k, exists := r.paxi[key]
+	if !exists {
+		k = newKPaxos(r.Node, key)
+		r.paxi[key] = k
+	}
+	return k
+}
+
+func (r *Replica) handleRequest(m PaxiBFT.Request) {
+	log.Debugf("Replica %s received %v\n", r.ID(), m)
+
+	// objects are ordered by independent paxos instances, nothing orders a scan across them
+	if m.Command.IsScan() {
+		m.Reply(PaxiBFT.Reply{Command: m.Command, Err: ErrScan})
+		return
+	}
+
+	k := r.init(m.Command.Key)
+
+	if !k.IsLeader() && k.Ballot() != 0 {
+		go r.Forward(k.Leader(), m)
+		return
+	}
+
+	k.HandleRequest(m)
+
+	// only the current owner of the object tracks access pattern
+	// and hands the object over to the zone that keeps accessing it
+	if k.IsLeader() {
+		to := k.Hit(m.NodeID)
+		if to != "" && to.Zone() != r.ID().Zone() {
+			r.Send(to, LeaderChange{
+				Key:    m.Command.Key,
+				To:     to,
+				From:   r.ID(),
+				Ballot: k.Ballot(),
+			})
+		}
+	}
+}
+
+func (r *Replica) handlePrepare(m Prepare) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.P1a.Ballot.ID(), m, r.ID())
+	r.init(m.Key).HandleP1a(m.P1a)
+}
+
+func (r *Replica) handlePromise(m Promise) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.P1b.ID, m, r.ID())
+	r.init(m.Key).HandleP1b(m.P1b)
+}
+
+func (r *Replica) handleAccept(m Accept) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.P2a.Ballot.ID(), m, r.ID())
+	r.init(m.Key).HandleP2a(m.P2a)
+}
+
+func (r *Replica) handleAccepted(m Accepted) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.P2b.ID, m, r.ID())
+	r.init(m.Key).HandleP2b(m.P2b)
+}
+
+func (r *Replica) handleCommit(m Commit) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.P3.Ballot.ID(), m, r.ID())
+	r.init(m.Key).HandleP3(m.P3)
+}
+
+func (r *Replica) handleActivate(m Activate) {
+	r.init(m.Key).HandleActivate(m.Activate)
+}
+
+// handleLeaderChange steals the object by starting phase 1 with flexible grid Q1
+func (r *Replica) handleLeaderChange(m LeaderChange) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.From, m, r.ID())
+	k := r.init(m.Key)
+	if m.To != r.ID() || m.Ballot < k.Ballot() || k.IsLeader() {
+		return
+	}
+	k.SetBallot(m.Ballot)
+	k.P1a()
+}
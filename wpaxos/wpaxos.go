@@ -0,0 +1,73 @@
+package wpaxos
+
+import (
+	"github.com/salemmohammed/PaxiBFT"
+	"github.com/salemmohammed/PaxiBFT/log"
+	"github.com/salemmohammed/PaxiBFT/paxos"
+)
+
+// kpaxos is the paxos instance of one object key
+// it wraps the replica node so every outgoing paxos message is tagged with the key
+type kpaxos struct {
+	PaxiBFT.Node
+	PaxiBFT.Policy
+	*paxos.Paxos
+
+	key PaxiBFT.Key
+}
+
+// newKPaxos creates paxos instance for key using flexible grid quorums
+// that tolerate the zone failures fz of the quorum config
+func newKPaxos(n PaxiBFT.Node, key PaxiBFT.Key) *kpaxos {
+	k := &kpaxos{
+		Node:   n,
+		Policy: PaxiBFT.NewPolicy(),
+		key:    key,
+	}
+	fgrid := PaxiBFT.QuorumConfig{Type: "fgrid", Fz: PaxiBFT.GetConfig().Quorum.Fz}
+	if err := fgrid.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	k.Paxos = paxos.NewPaxos(k, func(p *paxos.Paxos) {
+		p.Q1 = fgrid.Phase1()
+		p.Q2 = fgrid.Phase2()
+	})
+	return k
+}
+
+// wrap tags paxos message with the key of this instance
+func (k *kpaxos) wrap(m interface{}) interface{} {
+	switch m := m.(type) {
+	case paxos.P1a:
+		return Prepare{Key: k.key, P1a: m}
+	case paxos.P1b:
+		return Promise{Key: k.key, P1b: m}
+	case paxos.P2a:
+		return Accept{Key: k.key, P2a: m}
+	case paxos.P2b:
+		return Accepted{Key: k.key, P2b: m}
+	case paxos.P3:
+		return Commit{Key: k.key, P3: m}
+	case paxos.Activate:
+		return Activate{Key: k.key, Activate: m}
+	default:
+		log.Errorf("key %v cannot wrap unknown message %v", k.key, m)
+		return m
+	}
+}
+
+func (k *kpaxos) Send(to PaxiBFT.ID, m interface{}) {
+	k.Node.Send(to, k.wrap(m))
+}
+
+func (k *kpaxos) Broadcast(m interface{}) {
+	k.Node.Broadcast(k.wrap(m))
+}
+
+func (k *kpaxos) MulticastZone(zone int, m interface{}) {
+	k.Node.MulticastZone(zone, k.wrap(m))
+}
+
+func (k *kpaxos) MulticastQuorum(quorum int, m interface{}) {
+	k.Node.MulticastQuorum(quorum, k.wrap(m))
+}
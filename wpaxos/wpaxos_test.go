@@ -0,0 +1,88 @@
+package wpaxos
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/salemmohammed/PaxiBFT"
+)
+
+var errIncomplete = errors.New("history incomplete")
+
+// cluster starts nodes replicas in each of zones in simulation with configuration c,
+// they are closed at the end of test before the next cluster changes configuration
+func cluster(t *testing.T, c PaxiBFT.Config, port, zones, nodes int) map[PaxiBFT.ID]*Replica {
+	replicas := make(map[PaxiBFT.ID]*Replica)
+	t.Cleanup(func() {
+		for _, r := range replicas {
+			r.Close()
+		}
+	})
+	err := PaxiBFT.Cluster(c, port, zones, nodes, func(id PaxiBFT.ID) {
+		replicas[id] = NewReplica(id)
+		go replicas[id].Run()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return replicas
+}
+
+func TestWPaxos(t *testing.T) {
+	const key = PaxiBFT.Key("0")
+	for i, f := range []int{0, 1} {
+		t.Run("fz="+strconv.Itoa(f), func(t *testing.T) {
+			c := PaxiBFT.MakeDefaultConfig()
+			c.Quorum.Fz = f
+			c.MultiVersion = true
+			c.Policy = "consecutive"
+			c.Threshold = 3
+			replicas := cluster(t, c, 21300+i*20, 3, 3)
+			client := PaxiBFT.NewHTTPClient("")
+			client.Client.Timeout = 5 * time.Second
+
+			// first write makes 1.1 the owner of the object, which commits writes of its own zone,
+			// then three consecutive writes from zone 2 make it hand the object over to 2.1
+			for i, id := range []PaxiBFT.ID{"1.1", "1.2", "1.3", "2.1", "2.1", "2.1", "2.1"} {
+				if _, _, err := client.RESTPut(id, key, PaxiBFT.Value("v"+strconv.Itoa(i))); err != nil {
+					t.Fatalf("put v%d to %v: %v", i, id, err)
+				}
+			}
+
+			// every replica executes the writes in order
+			err := PaxiBFT.Retry(func() error {
+				for _, r := range replicas {
+					if len(r.History(key)) < 7 {
+						return errIncomplete
+					}
+				}
+				return nil
+			}, 50, 20*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for id, r := range replicas {
+				h := r.History(key)
+				for i := range h {
+					if !bytes.HasPrefix(h[i], []byte("v"+strconv.Itoa(i))) {
+						t.Fatalf("replica %v executed %.2s at %d", id, h[i], i)
+					}
+				}
+			}
+
+			for _, r := range replicas {
+				// paxos instances are read once no handle function runs
+				r.Close()
+			}
+			if !replicas["2.1"].paxi[key].IsLeader() {
+				t.Errorf("2.1 did not steal the object, leader is %v", replicas["2.1"].paxi[key].Leader())
+			}
+			if replicas["1.1"].paxi[key].IsLeader() {
+				t.Errorf("1.1 still leads the object after 2.1 stole it")
+			}
+		})
+	}
+}